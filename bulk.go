@@ -0,0 +1,103 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// chunksPerWorker is the number of pieces UnmarshalAll divides its
+// input into for each worker, so that workers finishing early can
+// take on more of the input.
+const chunksPerWorker = 4
+
+// Workers makes UnmarshalAll decode its input with n goroutines. It
+// is ignored by Decoders and Encoders.
+func Workers(n int) Option {
+	return func(c *config) {
+		c.workers = n
+	}
+}
+
+// UnmarshalAll decodes every entry in data into the slice v points
+// to, following the rules of Unmarshal, and configured with the
+// given options. Entries are appended in the order they appear in
+// data. With the Workers option, the input is split on entry
+// boundaries into pieces that are decoded concurrently, which can
+// greatly speed up decoding very large inputs; the result is the
+// same as decoding them in order. If any entry cannot be decoded, the
+// error for the first such entry in data is returned, and v is left
+// unmodified.
+func UnmarshalAll(data []byte, v interface{}, opts ...Option) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice {
+		return &TypeError{reflect.TypeOf(v)}
+	}
+	c := newConfig(opts)
+	if c.workers <= 1 || c.paragraphs {
+		// Entry boundaries depend on the decoder's state
+		return NewDecoderWith(bytes.NewReader(data), opts...).decodeSlice(val)
+	}
+	offsets, lines := entryBounds(data)
+	n := c.workers * chunksPerWorker
+	if n > len(offsets) {
+		n = len(offsets)
+	}
+
+	// Each chunk starts at the first entry following its share of
+	// the input, so chunks hold similar numbers of bytes.
+	var starts []int
+	for i := 0; i < n; i++ {
+		want := int64(len(data) / n * i)
+		j := sort.Search(len(offsets), func(j int) bool {
+			return offsets[j] >= want
+		})
+		if j < len(offsets) && (i == 0 || j > starts[len(starts)-1]) {
+			starts = append(starts, j)
+		}
+	}
+	chunks := make([]reflect.Value, len(starts))
+	errs := make([]error, len(starts))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < c.workers && w < len(starts); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				first := starts[i]
+				end := len(data)
+				if i+1 < len(starts) {
+					end = int(offsets[starts[i+1]])
+				}
+				d := NewDecoderWith(bytes.NewReader(data[offsets[first]:end]), opts...)
+				d.offset, d.lineno = offsets[first], lines[first]-1
+				chunks[i] = reflect.New(val.Type().Elem())
+				errs[i] = d.decodeSlice(chunks[i])
+			}
+		}()
+	}
+	for i := range starts {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	total := 0
+	for i, err := range errs {
+		if err != nil {
+			return err
+		}
+		total += chunks[i].Elem().Len()
+	}
+	list := reflect.MakeSlice(val.Type().Elem(), 0, val.Elem().Len()+total)
+	list = reflect.AppendSlice(list, val.Elem())
+	for _, chunk := range chunks {
+		list = reflect.AppendSlice(list, chunk.Elem())
+	}
+	val.Elem().Set(list)
+	return nil
+}
@@ -0,0 +1,94 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func bulkInput(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "sys=host%d port=%d\n", i, i)
+		if i%7 == 0 {
+			fmt.Fprintf(&sb, "\tip=10.0.%d.%d\n# comment\n\n", i/256, i%256)
+		}
+	}
+	return sb.String()
+}
+
+func TestUnmarshalAll(t *testing.T) {
+	type host struct {
+		Sys  string   `ndb:"sys"`
+		Port int      `ndb:"port"`
+		IP   []string `ndb:"ip"`
+	}
+	input := []byte(bulkInput(1000))
+	var want []host
+	if err := UnmarshalAll(input, &want); err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 1000 {
+		t.Fatalf("Got %d entries, wanted 1000", len(want))
+	}
+	for _, workers := range []int{2, 3, 8, 2000} {
+		got := []host{{Sys: "first"}}
+		if err := UnmarshalAll(input, &got, Workers(workers)); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got[1:], want) || got[0].Sys != "first" {
+			t.Errorf("Workers(%d): results differ from sequential decoding", workers)
+		}
+	}
+
+	bad := []byte(strings.Replace(string(input), "port=500\n", "port=five\n", 1))
+	bad = []byte(strings.Replace(string(bad), "port=900\n", "port='\n", 1))
+	var seqErr, parErr error
+	var hosts []host
+	seqErr = UnmarshalAll(bad, &hosts)
+	parErr = UnmarshalAll(bad, &hosts, Workers(4))
+	if seqErr == nil || parErr == nil || seqErr.Error() != parErr.Error() {
+		t.Errorf("Got %v, wanted %v", parErr, seqErr)
+	}
+	if hosts != nil {
+		t.Errorf("Got %v after error, wanted v unmodified", hosts)
+	}
+	var se *SyntaxError
+	bad = []byte(strings.Replace(string(input), "port=900\n", "port='\n", 1))
+	line := bytes.Count(input[:bytes.Index(input, []byte("port=900\n"))], []byte("\n")) + 1
+	if err := UnmarshalAll(bad, &hosts, Workers(4)); !errors.As(err, &se) || se.Line != line {
+		t.Errorf("Got %v, wanted syntax error on line %d", err, line)
+	}
+	var h host
+	if err := UnmarshalAll(input, &h, Workers(2)); err == nil {
+		t.Error("Decoded a slice into a struct")
+	}
+	if err := UnmarshalAll(nil, &hosts, Workers(2)); err != nil || hosts == nil || len(hosts) != 0 {
+		t.Errorf("Got %v, %v, wanted empty slice", hosts, err)
+	}
+}
+
+func BenchmarkUnmarshalAll(b *testing.B) {
+	type host struct {
+		Sys  string   `ndb:"sys"`
+		Port int      `ndb:"port"`
+		IP   []string `ndb:"ip"`
+	}
+	input := []byte(bulkInput(100000))
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				var hosts []host
+				if err := UnmarshalAll(input, &hosts, Workers(workers)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
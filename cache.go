@@ -0,0 +1,308 @@
+package ndb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Index cache files hold a parsed Database in a compact binary form.
+// After a header holding the magic string, the SHA-256 checksum of
+// the source file and its path, every attribute and value is stored
+// once in a string table; entries and indexes refer to strings by
+// their position in the table. All integers are unsigned varints.
+const cacheMagic = "ndbcache\x01"
+
+// ErrStaleIndex is returned by LoadIndex when the file the index
+// cache was saved from has changed since.
+var ErrStaleIndex = errors.New("Index cache does not match its source file")
+
+var errBadCache = errors.New("ndb: malformed index cache")
+
+// SaveIndex writes the entries and indexes of the Database to a cache
+// file at path, along with the checksum of the file the Database was
+// read from, so that LoadIndex may restore it without parsing the
+// file again. The Database must have been opened with Open.
+func (db *Database) SaveIndex(path string) error {
+	db.rlock()
+	if db.path == "" {
+		db.mu.RUnlock()
+		return errNoPath
+	}
+	b := db.appendCache(nil)
+	db.mu.RUnlock()
+
+	dir, name := filepath.Split(path)
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(b); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadIndex restores a Database from the cache file at path, written
+// by SaveIndex. The Database's source file is read and checksummed,
+// but not parsed; if it has changed since the cache was saved,
+// LoadIndex returns ErrStaleIndex, and the caller should Open the
+// source file instead, and save a new cache. The restored Database
+// behaves as if it had been opened with Open and indexed again.
+func LoadIndex(path string) (*Database, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < len(cacheMagic)+sha256.Size || string(b[:len(cacheMagic)]) != cacheMagic {
+		return nil, errBadCache
+	}
+	r := cacheReader{buf: b[len(cacheMagic):]}
+	var sum [sha256.Size]byte
+	copy(sum[:], r.next(sha256.Size))
+	src := string(r.next(r.uint()))
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	if [sha256.Size]byte(h.Sum(nil)) != sum {
+		return nil, ErrStaleIndex
+	}
+
+	db := r.database()
+	if r.err != nil {
+		return nil, r.err
+	}
+	db.path = src
+	db.mtime = uint32(fi.ModTime().Unix())
+	db.file = fileState{fi.ModTime(), fi.Size(), sum}
+	return db, nil
+}
+
+// appendCache appends the cache file contents for the Database to
+// dst. The caller must hold db.mu.
+func (db *Database) appendCache(dst []byte) []byte {
+	ids := make(map[string]uint64)
+	var strs []string
+	id := func(s string) uint64 {
+		n, ok := ids[s]
+		if !ok {
+			n = uint64(len(strs))
+			ids[s] = n
+			strs = append(strs, s)
+		}
+		return n
+	}
+
+	// Entries and indexes are encoded first, so that the string
+	// table is complete when it is written. The positions of
+	// entries double as the offsets used with hash files, unless
+	// the entries were sorted.
+	var body []byte
+	if db.offsets != nil {
+		body = append(body, 1)
+	} else {
+		body = append(body, 0)
+	}
+	body = binary.AppendUvarint(body, uint64(len(db.entries)))
+	for _, e := range db.entries {
+		var pos Position
+		if len(e) > 0 {
+			pos = db.origins[&e[0]]
+		}
+		body = binary.AppendUvarint(body, uint64(pos.Line))
+		body = binary.AppendUvarint(body, uint64(pos.Offset))
+		body = binary.AppendUvarint(body, uint64(len(e)))
+		for _, p := range e {
+			body = binary.AppendUvarint(body, id(p.Attr))
+			body = binary.AppendUvarint(body, id(p.Val))
+		}
+	}
+	attrs := db.indexAttrs()
+	sort.Strings(attrs)
+	body = binary.AppendUvarint(body, uint64(len(attrs)))
+	for _, attr := range attrs {
+		ix := db.indexes[attr]
+		vals := make([]string, 0, len(ix))
+		for val := range ix {
+			vals = append(vals, val)
+		}
+		sort.Strings(vals)
+		body = binary.AppendUvarint(body, id(attr))
+		body = binary.AppendUvarint(body, uint64(len(vals)))
+		for _, val := range vals {
+			body = binary.AppendUvarint(body, id(val))
+			body = binary.AppendUvarint(body, uint64(len(ix[val])))
+			prev := 0
+			for _, i := range ix[val] {
+				body = binary.AppendUvarint(body, uint64(i-prev))
+				prev = i
+			}
+		}
+	}
+
+	dst = append(dst, cacheMagic...)
+	dst = append(dst, db.file.sum[:]...)
+	dst = binary.AppendUvarint(dst, uint64(len(db.path)))
+	dst = append(dst, db.path...)
+	dst = binary.AppendUvarint(dst, uint64(len(strs)))
+	for _, s := range strs {
+		dst = binary.AppendUvarint(dst, uint64(len(s)))
+	}
+	for _, s := range strs {
+		dst = append(dst, s...)
+	}
+	return append(dst, body...)
+}
+
+// A cacheReader decodes a cache file. The first error encountered
+// is kept in err, after which every read returns zero values.
+type cacheReader struct {
+	buf  []byte
+	err  error
+	strs []string
+}
+
+func (r *cacheReader) uint() int {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 || v > math.MaxInt {
+		r.err = errBadCache
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return int(v)
+}
+
+func (r *cacheReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.buf) {
+		r.err = errBadCache
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+// count reads a number of items, each of which takes at least one
+// byte, so that a corrupt file cannot cause a huge allocation.
+func (r *cacheReader) count() int {
+	n := r.uint()
+	if n > len(r.buf) {
+		r.err = errBadCache
+		return 0
+	}
+	return n
+}
+
+func (r *cacheReader) str() string {
+	i := r.uint()
+	if i >= len(r.strs) {
+		r.err = errBadCache
+		return ""
+	}
+	return r.strs[i]
+}
+
+// database decodes the string table, entries and indexes of a cache
+// file.
+func (r *cacheReader) database() *Database {
+	lens := make([]int, r.count())
+	total := 0
+	for i := range lens {
+		lens[i] = r.uint()
+		if lens[i] > len(r.buf)-total {
+			r.err = errBadCache
+			return nil
+		}
+		total += lens[i]
+	}
+	// The table is held in a single string, which the attributes
+	// and values of the entries share.
+	table := string(r.next(total))
+	if r.err != nil {
+		return nil
+	}
+	r.strs = make([]string, len(lens))
+	for i, n := range lens {
+		r.strs[i], table = table[:n], table[n:]
+	}
+
+	haveOffsets := r.next(1)
+	n := r.count()
+	db := &Database{
+		entries: make([]Entry, n),
+		origins: make(map[*Pair]Position, n),
+	}
+	if len(haveOffsets) == 1 && haveOffsets[0] == 1 {
+		db.offsets = make([]int64, n)
+	}
+	for i := range db.entries {
+		line, off := r.uint(), int64(r.uint())
+		e := make(Entry, r.count())
+		for j := range e {
+			e[j] = Pair{r.str(), r.str()}
+		}
+		if len(e) > 0 && line > 0 {
+			db.origins[&e[0]] = Position{Line: line, Offset: off}
+		}
+		if db.offsets != nil {
+			db.offsets[i] = off
+		}
+		db.entries[i] = e
+	}
+	if nattr := r.count(); nattr > 0 {
+		db.indexes = make(map[string]index, nattr)
+		for ; nattr > 0; nattr-- {
+			attr := r.str()
+			ix := make(index)
+			for nval := r.count(); nval > 0; nval-- {
+				val := r.str()
+				pos := make([]int, r.count())
+				prev := 0
+				for k := range pos {
+					prev += r.uint()
+					if prev >= len(db.entries) {
+						r.err = errBadCache
+						return nil
+					}
+					pos[k] = prev
+				}
+				ix[val] = pos
+			}
+			db.indexes[attr] = ix
+		}
+	}
+	if r.err == nil && len(r.buf) > 0 {
+		r.err = errBadCache
+	}
+	return db
+}
@@ -0,0 +1,72 @@
+package ndb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveIndex(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "local")
+	cache := filepath.Join(dir, "local.cache")
+	if err := os.WriteFile(path, []byte(testDB), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Index("ip")
+	if err := db.SaveIndex(cache); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIndex(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Entries(), db.Entries()) {
+		t.Errorf("Got %v, wanted %v", loaded.Entries(), db.Entries())
+	}
+	if !reflect.DeepEqual(loaded.indexes, db.indexes) {
+		t.Errorf("Got indexes %v, wanted %v", loaded.indexes, db.indexes)
+	}
+	found, err := loaded.Search("ip", "135.104.9.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Get("sys") != "oak" {
+		t.Fatalf("Search(ip, 135.104.9.3) = %v, wanted sys=oak", found)
+	}
+	if pos, _ := loaded.Position(found[0]); pos.String() != path+":3" {
+		t.Errorf("Got position %s, wanted %s:3", pos, path)
+	}
+
+	b, err := os.ReadFile(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := len(b) - 1; n > 0; n -= 7 {
+		if err := os.WriteFile(cache, b[:n], 0666); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadIndex(cache); err == nil {
+			t.Errorf("Loaded a cache truncated to %d bytes", n)
+		}
+	}
+	if err := os.WriteFile(cache, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("sys=elm\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIndex(cache); !errors.Is(err, ErrStaleIndex) {
+		t.Errorf("Got %v, wanted ErrStaleIndex", err)
+	}
+	if err := openTestDB(t).SaveIndex(cache); err == nil {
+		t.Error("Saved the index of a Database without a path")
+	}
+}
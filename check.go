@@ -0,0 +1,104 @@
+package ndb
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+// Valid reports whether data is valid ndb text.
+func Valid(data []byte) bool {
+	d := NewDecoder(bytes.NewReader(data))
+	d.nocount = true
+	for {
+		_, err := d.getPairs()
+		if err == io.EOF {
+			return true
+		} else if err != nil {
+			return false
+		}
+	}
+}
+
+// Check scans all of data and returns every syntax error in it, in
+// order, located by line and column, for linting. Unlike a Decoder,
+// which stops at the first error in an entry, Check resumes after an
+// invalid attribute or missing white space at the next white space,
+// and after other errors at the next entry.
+func Check(data []byte) []SyntaxError {
+	var errs []SyntaxError
+	d := NewDecoder(bytes.NewReader(data))
+	d.nocount = true
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return errs
+		}
+		d.line = line
+		for rest := int64(0); rest < int64(len(line)); {
+			d.pairbuf = d.pairbuf[:0]
+			_, err := d.parseLine(line[rest:])
+			e, ok := err.(*SyntaxError)
+			if !ok {
+				break
+			}
+			e.Data, e.Offset = line, rest+e.Offset
+			d.locate(e)
+			errs = append(errs, *e)
+			if e.Err != ErrBadAttribute && e.Err != ErrMissingSpace {
+				break
+			}
+			for rest = e.Offset + 1; rest < int64(len(line)) && !isSpace(rune(line[rest])); rest++ {
+			}
+		}
+	}
+}
+
+// Equal reports whether the ndb texts a and b hold the same entries,
+// in the same order, ignoring the order of tuples within each entry,
+// quoting, white space and comments. If either has a syntax error,
+// Equal returns it.
+func Equal(a, b []byte) (bool, error) {
+	da, err := OpenReader(bytes.NewReader(a))
+	if err != nil {
+		return false, err
+	}
+	db, err := OpenReader(bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	if len(da.entries) != len(db.entries) {
+		return false, nil
+	}
+	for i := range da.entries {
+		if !sameTuples(da.entries[i], db.entries[i]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// sameTuples reports whether e and f hold the same tuples, in any
+// order. It sorts both.
+func sameTuples(e, f Entry) bool {
+	if len(e) != len(f) {
+		return false
+	}
+	sortTuples(e)
+	sortTuples(f)
+	for i := range e {
+		if e[i] != f[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortTuples(e Entry) {
+	sort.Slice(e, func(i, j int) bool {
+		if e[i].Attr != e[j].Attr {
+			return e[i].Attr < e[j].Attr
+		}
+		return e[i].Val < e[j].Val
+	})
+}
@@ -0,0 +1,424 @@
+// Command ndbgen generates MarshalNDB and UnmarshalNDB methods for
+// struct types, so that they may be encoded and decoded without the
+// use of reflection. The generated methods satisfy ndb.Marshaler and
+// ndb.Unmarshaler, so the ndb package's Encoder and Decoder use them
+// as well. It is intended to be run by go generate:
+//
+//	//go:generate ndbgen -type Host,Network
+//
+// Struct fields are mapped to attributes the same way the ndb package
+// maps them: by field name, or by the name given in an `ndb:"name"`
+// tag. Unexported fields and fields tagged `ndb:"-"` are ignored.
+// Supported field types are string, bool, the integer and floating
+// point types, []byte, and slices of any of those except []byte,
+// which are stored as repeated attributes.
+//
+// The generated file contains helper functions shared by all of its
+// types, so every type in a package should be listed in a single
+// invocation. By default, the generated code is written to
+// typename_ndb.go in the package directory, where typename is the
+// lower-cased name of the first type.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of `types` to generate methods for")
+	output    = flag.String("output", "", "output file name")
+)
+
+// A field describes a struct field and the attribute it maps to.
+type field struct {
+	name  string // Go field name
+	attr  string // ndb attribute
+	kind  string // element type, such as "int" or "string"
+	slice bool   // field is a slice of kind
+}
+
+type structType struct {
+	name   string
+	fields []field
+}
+
+var basicKinds = map[string]bool{
+	"string": true, "bool": true, "bytes": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"uintptr": true, "byte": true, "rune": true,
+	"float32": true, "float64": true,
+}
+
+// fieldKind returns the element kind of a field's type expression,
+// and whether the field is a slice.
+func fieldKind(expr ast.Expr) (kind string, slice bool, ok bool) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name, false, basicKinds[t.Name]
+	case *ast.ArrayType:
+		elt, isIdent := t.Elt.(*ast.Ident)
+		if t.Len != nil || !isIdent {
+			return "", false, false
+		}
+		if elt.Name == "byte" || elt.Name == "uint8" {
+			return "bytes", false, true
+		}
+		return elt.Name, true, basicKinds[elt.Name]
+	}
+	return "", false, false
+}
+
+// tagValue returns the ndb key of a field's struct tag.
+func tagValue(f *ast.Field) string {
+	if f.Tag == nil {
+		return ""
+	}
+	tag, err := strconv.Unquote(f.Tag.Value)
+	if err != nil {
+		return ""
+	}
+	return reflect.StructTag(tag).Get("ndb")
+}
+
+func attrName(f *ast.Field, name string) string {
+	v := tagValue(f)
+	if i := strings.IndexByte(v, ','); i != -1 {
+		v = v[:i]
+	}
+	if v == "" {
+		return name
+	}
+	return v
+}
+
+func validAttr(attr string) bool {
+	for _, r := range attr {
+		if !(r == '-' || unicode.IsLetter(r) || unicode.IsNumber(r)) {
+			return false
+		}
+	}
+	return attr != ""
+}
+
+func parseStruct(name string, st *ast.StructType) (structType, error) {
+	s := structType{name: name}
+	seen := make(map[string]bool)
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return s, fmt.Errorf("%s: embedded fields are not supported", name)
+		}
+		kind, slice, ok := fieldKind(f.Type)
+		for _, id := range f.Names {
+			if !id.IsExported() || tagValue(f) == "-" {
+				continue
+			}
+			if !ok {
+				return s, fmt.Errorf("%s.%s: unsupported field type", name, id.Name)
+			}
+			attr := attrName(f, id.Name)
+			if !validAttr(attr) {
+				return s, fmt.Errorf("%s.%s: invalid attribute %q", name, id.Name, attr)
+			}
+			if seen[attr] {
+				return s, fmt.Errorf("%s.%s: duplicate attribute %q", name, id.Name, attr)
+			}
+			seen[attr] = true
+			s.fields = append(s.fields, field{id.Name, attr, kind, slice})
+		}
+	}
+	return s, nil
+}
+
+// findStructs parses the Go files in dir and returns the named struct
+// types along with the package name.
+func findStructs(dir string, names []string) (string, []structType, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	specs := make(map[string]*ast.StructType)
+	var pkgName string
+	for _, pkg := range pkgs {
+		pkgName = pkg.Name
+		for _, file := range pkg.Files {
+			ast.Inspect(file, func(n ast.Node) bool {
+				ts, ok := n.(*ast.TypeSpec)
+				if !ok {
+					return true
+				}
+				if st, ok := ts.Type.(*ast.StructType); ok {
+					specs[ts.Name.Name] = st
+				}
+				return false
+			})
+		}
+	}
+	var types []structType
+	for _, name := range names {
+		st, ok := specs[name]
+		if !ok {
+			return "", nil, fmt.Errorf("struct type %s not found in %s", name, dir)
+		}
+		s, err := parseStruct(name, st)
+		if err != nil {
+			return "", nil, err
+		}
+		types = append(types, s)
+	}
+	return pkgName, types, nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("ndbgen: ")
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	names := strings.Split(*typeNames, ",")
+	dir := "."
+	if args := flag.Args(); len(args) > 0 {
+		dir = args[0]
+	}
+	pkg, types, err := findStructs(dir, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := generate(pkg, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+	name := *output
+	if name == "" {
+		name = filepath.Join(dir, strings.ToLower(names[0])+"_ndb.go")
+	}
+	if err := ioutil.WriteFile(name, src, 0666); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type generator struct {
+	bytes.Buffer
+	imports  map[string]bool
+	needBool bool // whether ndbgenParseBool is used
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.Buffer, format, args...)
+}
+
+func generate(pkg string, types []structType) ([]byte, error) {
+	g := &generator{imports: map[string]bool{
+		"bytes":                   true,
+		"fmt":                     true,
+		"io":                      true,
+		"strings":                 true,
+		"unicode":                 true,
+		"aqwari.net/encoding/ndb": true,
+	}}
+	for _, s := range types {
+		g.genMarshal(s)
+		g.genUnmarshal(s)
+	}
+	g.WriteString(helpers)
+	if g.needBool {
+		g.WriteString(boolHelper)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by ndbgen; DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	var imports []string
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	for _, std := range []bool{true, false} {
+		if !std {
+			out.WriteString("\n")
+		}
+		for _, path := range imports {
+			if !strings.Contains(path, ".") == std {
+				fmt.Fprintf(&out, "\t%q\n", path)
+			}
+		}
+	}
+	fmt.Fprintf(&out, ")\n")
+	out.Write(g.Bytes())
+	return format.Source(out.Bytes())
+}
+
+// appendExpr returns an expression appending the value x of the
+// given kind to the byte slice b.
+func (g *generator) appendExpr(kind, x string) string {
+	switch kind {
+	case "bool":
+		g.imports["strconv"] = true
+		return fmt.Sprintf("strconv.AppendBool(b, %s)", x)
+	case "int", "int8", "int16", "int32", "int64", "rune":
+		g.imports["strconv"] = true
+		return fmt.Sprintf("strconv.AppendInt(b, int64(%s), 10)", x)
+	case "uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "byte":
+		g.imports["strconv"] = true
+		return fmt.Sprintf("strconv.AppendUint(b, uint64(%s), 10)", x)
+	case "float32", "float64":
+		g.imports["strconv"] = true
+		return fmt.Sprintf("strconv.AppendFloat(b, float64(%s), 'g', -1, %s)", x, kind[len("float"):])
+	}
+	return ""
+}
+
+func (g *generator) genMarshal(s structType) {
+	g.printf("\n// MarshalNDB encodes v as a single ndb entry.\n")
+	g.printf("func (v %s) MarshalNDB() ([]byte, error) {\n", s.name)
+	g.printf("var b []byte\nvar err error\n")
+	for _, f := range s.fields {
+		x := "v." + f.name
+		if f.slice {
+			g.printf("for _, x := range %s {\n", x)
+			x = "x"
+		}
+		g.printf("b = ndbgenAppendAttr(b, %q)\n", f.attr)
+		switch f.kind {
+		case "string":
+			g.printf("if b, err = ndbgenAppendString(b, %s); err != nil {\nreturn nil, err\n}\n", x)
+		case "bytes":
+			g.printf("if b, err = ndbgenAppendString(b, string(%s)); err != nil {\nreturn nil, err\n}\n", x)
+		default:
+			g.printf("b = %s\n", g.appendExpr(f.kind, x))
+		}
+		if f.slice {
+			g.printf("}\n")
+		}
+	}
+	g.printf("return b, err\n}\n")
+}
+
+// parseStmt returns statements storing the string s, converted to
+// kind, in dst.
+func (g *generator) parseStmt(kind, dst, s string) string {
+	bits := func(prefix string) string {
+		if n := kind[len(prefix):]; n != "" {
+			return n
+		}
+		return "0"
+	}
+	var parse, conv string
+	switch kind {
+	case "string":
+		return fmt.Sprintf("%s = %s\n", dst, s)
+	case "bytes":
+		return fmt.Sprintf("%s = []byte(%s)\n", dst, s)
+	case "bool":
+		parse, conv = fmt.Sprintf("ndbgenParseBool(%s)", s), "x"
+		g.needBool = true
+	case "rune":
+		parse, conv = fmt.Sprintf("strconv.ParseInt(%s, 10, 32)", s), "rune(x)"
+	case "byte":
+		parse, conv = fmt.Sprintf("strconv.ParseUint(%s, 10, 8)", s), "byte(x)"
+	case "int", "int8", "int16", "int32", "int64":
+		parse, conv = fmt.Sprintf("strconv.ParseInt(%s, 10, %s)", s, bits("int")), kind+"(x)"
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		parse, conv = fmt.Sprintf("strconv.ParseUint(%s, 10, %s)", s, bits("uint")), kind+"(x)"
+	case "uintptr":
+		parse, conv = fmt.Sprintf("strconv.ParseUint(%s, 10, 0)", s), "uintptr(x)"
+	case "float32", "float64":
+		parse, conv = fmt.Sprintf("strconv.ParseFloat(%s, %s)", s, kind[len("float"):]), kind+"(x)"
+	}
+	g.imports["strconv"] = true
+	return fmt.Sprintf("x, err := %s\nif err != nil {\nreturn err\n}\n%s = %s\n", parse, dst, conv)
+}
+
+func (g *generator) genUnmarshal(s structType) {
+	g.printf("\n// UnmarshalNDB decodes the first ndb entry in data into v.\n")
+	g.printf("func (v *%s) UnmarshalNDB(data []byte) error {\n", s.name)
+	g.printf("e, err := ndb.NewDecoder(bytes.NewReader(data)).DecodeEntry()\n")
+	g.printf("if err == io.EOF {\nreturn nil\n} else if err != nil {\nreturn err\n}\n")
+
+	// Fields are decoded into a copy of v, which is stored only if
+	// every value is valid, leaving v unmodified otherwise.
+	g.printf("w := *v\n")
+
+	// Slices are accumulated and only stored if the attribute
+	// was present, leaving them unmodified otherwise.
+	for i, f := range s.fields {
+		if f.slice {
+			g.printf("var s%d %s\n", i, "[]"+f.kind)
+		}
+	}
+	g.printf("for _, p := range e {\nswitch p.Attr {\n")
+	for i, f := range s.fields {
+		g.printf("case %q:\n", f.attr)
+		if f.slice {
+			g.printf("var elem %s\n", f.kind)
+			g.printf("%s", g.parseStmt(f.kind, "elem", "p.Val"))
+			g.printf("s%d = append(s%d, elem)\n", i, i)
+		} else {
+			g.printf("%s", g.parseStmt(f.kind, "w."+f.name, "p.Val"))
+		}
+	}
+	g.printf("}\n}\n")
+	for i, f := range s.fields {
+		if f.slice {
+			g.printf("if s%d != nil {\nw.%s = s%d\n}\n", i, f.name, i)
+		}
+	}
+	g.printf("*v = w\nreturn nil\n}\n")
+}
+
+const helpers = `
+func ndbgenAppendAttr(b []byte, attr string) []byte {
+	if len(b) > 0 {
+		b = append(b, ' ')
+	}
+	b = append(b, attr...)
+	return append(b, '=')
+}
+
+func ndbgenAppendString(b []byte, s string) ([]byte, error) {
+	if strings.IndexByte(s, '\n') != -1 {
+		return nil, fmt.Errorf("Invalid value %s", s)
+	}
+	if strings.HasPrefix(s, "'") && strings.IndexFunc(s, unicode.IsSpace) != -1 {
+		// A value that ndb.Quote cannot represent
+		return nil, fmt.Errorf("Invalid value %s", s)
+	}
+	return ndb.AppendQuote(b, s), nil
+}
+`
+
+// boolHelper parses bools as the ndb package does.
+const boolHelper = `
+func ndbgenParseBool(s string) (bool, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "":
+		// A bare attribute, such as trusted, states a fact
+		return true, nil
+	case "yes", "on", "enable":
+		return true, nil
+	case "no", "off", "disable":
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+`
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	pkg, types, err := findStructs("testdata", []string{"Host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg != "testdata" || len(types) != 1 {
+		t.Fatalf("Got package %s with %d types, wanted testdata with 1", pkg, len(types))
+	}
+	if n := len(types[0].fields); n != 7 {
+		t.Errorf("Got %d fields, wanted 7: %v", n, types[0].fields)
+	}
+	src, err := generate(pkg, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"func (v Host) MarshalNDB() ([]byte, error)",
+		"func (v *Host) UnmarshalNDB(data []byte) error",
+		`case "sys":`,
+		`ndbgenAppendAttr(b, "vlan")`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated code does not contain %q", want)
+		}
+	}
+}
+
+// roundTrip is the main function of a program built from the
+// generated code and testdata/host.go, which encodes a Host and
+// decodes it again.
+const roundTrip = `package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+)
+
+func main() {
+	in := Host{
+		Name:   "fir",
+		IP:     []string{"10.0.0.1", "10.0.0.2"},
+		Vlan:   []int{1, -2},
+		Port:   22,
+		Weight: 0.5,
+		Up:     true,
+		Key:    []byte("it's a key"),
+	}
+	b, err := in.MarshalNDB()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var out Host
+	if err := out.UnmarshalNDB(b); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !reflect.DeepEqual(in, out) {
+		fmt.Printf("encoded %q, decoded %+v\n", b, out)
+		os.Exit(1)
+	}
+
+	// Bools are spelled as the ndb package accepts them
+	for text, want := range map[string]bool{"Up": true, "Up=yes": true, "Up=OFF": false, "Up=true": true} {
+		out.Up = !want
+		if err := out.UnmarshalNDB([]byte(text)); err != nil || out.Up != want {
+			fmt.Printf("decoded %q as %v, %v\n", text, out.Up, err)
+			os.Exit(1)
+		}
+	}
+	// An invalid value leaves every field unmodified
+	out = in
+	if err := out.UnmarshalNDB([]byte("sys=oak Port=ssh")); err == nil || !reflect.DeepEqual(in, out) {
+		fmt.Printf("decoded invalid entry as %+v, %v\n", out, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s", b)
+}
+`
+
+func TestGenerateBuild(t *testing.T) {
+	gotool, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("building generated code requires the go tool")
+	}
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := os.ReadFile(filepath.Join("testdata", "host.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, types, err := findStructs("testdata", []string{"Host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate("main", types)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The program is built in a GOPATH of its own, holding this
+	// copy of the ndb package, without reflection.
+	gopath := t.TempDir()
+	ndbDir := filepath.Join(gopath, "src", "aqwari.net", "encoding", "ndb")
+	if err := os.MkdirAll(filepath.Dir(ndbDir), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(root, ndbDir); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(gopath, "src", "roundtrip")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"host.go":     bytes.Replace(host, []byte("package testdata"), []byte("package main"), 1),
+		"host_ndb.go": src,
+		"main.go":     []byte(roundTrip),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(gotool, "run", "-tags", "ndbnoreflect", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	want := "sys=fir ip=10.0.0.1 ip=10.0.0.2 vlan=1 vlan=-2 Port=22 Weight=0.5 Up=true Key='it''s a key'"
+	if string(out) != want {
+		t.Errorf("Got %q, wanted %q", out, want)
+	}
+}
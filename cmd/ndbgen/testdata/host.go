@@ -0,0 +1,13 @@
+package testdata
+
+type Host struct {
+	Name   string   `ndb:"sys"`
+	IP     []string `ndb:"ip"`
+	Vlan   []int    `ndb:"vlan"`
+	Port   uint16
+	Weight float64
+	Up     bool
+	Key    []byte
+	note   string
+	Cache  map[string]int `ndb:"-"`
+}
@@ -0,0 +1,202 @@
+// Command ndbgrep prints the ndb entries that match a set of
+// attribute expressions.
+//
+// Usage:
+//
+//	ndbgrep [-e expr]... [expr] [file ...]
+//
+// Each expression takes one of the forms
+//
+//	attr      the entry has the attribute attr
+//	attr=val  the entry has the tuple attr=val
+//	attr~pat  the entry has a value for attr matching the
+//	          shell pattern pat, as in path.Match
+//
+// and may be prefixed with '!' to negate it. An entry is printed
+// if it matches every expression. If no -e flags are given, the
+// first argument is taken as the expression. Input is read from
+// the named files, or from standard input if there are none.
+// Matching entries are printed exactly as they appear in the
+// input, including continuation lines.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"aqwari.net/encoding/ndb"
+)
+
+type exprList []string
+
+func (l *exprList) String() string     { return strings.Join(*l, " ") }
+func (l *exprList) Set(s string) error { *l = append(*l, s); return nil }
+
+var exprs exprList
+
+func init() {
+	flag.Var(&exprs, "e", "match entries against `expr`; may be repeated")
+}
+
+type matcher struct {
+	attr, val string
+	op        byte // 0, '=' or '~'
+	negate    bool
+}
+
+func parseExpr(s string) (matcher, error) {
+	var m matcher
+	if strings.HasPrefix(s, "!") {
+		m.negate = true
+		s = s[1:]
+	}
+	if i := strings.IndexAny(s, "=~"); i != -1 {
+		m.attr, m.op, m.val = s[:i], s[i], s[i+1:]
+		if m.op == '~' {
+			if _, err := path.Match(m.val, ""); err != nil {
+				return m, fmt.Errorf("bad pattern %q: %v", m.val, err)
+			}
+		}
+	} else {
+		m.attr = s
+	}
+	if m.attr == "" {
+		return m, fmt.Errorf("missing attribute in expression %q", s)
+	}
+	return m, nil
+}
+
+func (m matcher) match(e ndb.Entry) bool {
+	found := false
+	for _, p := range e {
+		if p.Attr != m.attr {
+			continue
+		}
+		switch m.op {
+		case 0:
+			found = true
+		case '=':
+			found = p.Val == m.val
+		case '~':
+			found, _ = path.Match(m.val, p.Val)
+		}
+		if found {
+			break
+		}
+	}
+	return found != m.negate
+}
+
+func matchAll(ms []matcher, e ndb.Entry) bool {
+	for _, m := range ms {
+		if !m.match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// grep copies the entries in r that match every matcher to w. An
+// entry is a line and any following lines that begin with white space.
+func grep(w io.Writer, r io.Reader, name string, ms []matcher) error {
+	var raw bytes.Buffer
+	var start, lineno int
+	flush := func() error {
+		defer raw.Reset()
+		if len(bytes.TrimSpace(raw.Bytes())) == 0 {
+			return nil
+		}
+		db, err := ndb.OpenReader(bytes.NewReader(raw.Bytes()))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, start, err)
+		}
+		for _, e := range db.Entries() {
+			if matchAll(ms, e) {
+				_, err = w.Write(raw.Bytes())
+				return err
+			}
+		}
+		return nil
+	}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			lineno++
+			if line[0] != ' ' && line[0] != '\t' {
+				if ferr := flush(); ferr != nil {
+					return ferr
+				}
+				start = lineno
+			}
+			raw.Write(line)
+			if line[len(line)-1] != '\n' {
+				raw.WriteByte('\n')
+			}
+		}
+		if err == io.EOF {
+			return flush()
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-e expr]... [expr] [file ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(exprs) == 0 {
+		if len(args) == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		exprs, args = exprList{args[0]}, args[1:]
+	}
+	var ms []matcher
+	for _, s := range exprs {
+		m, err := parseExpr(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ndbgrep:", err)
+			os.Exit(2)
+		}
+		ms = append(ms, m)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	status := 0
+	if len(args) == 0 {
+		if err := grep(out, os.Stdin, "<stdin>", ms); err != nil {
+			fmt.Fprintln(os.Stderr, "ndbgrep:", err)
+			status = 1
+		}
+	}
+	for _, name := range args {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ndbgrep:", err)
+			status = 1
+			continue
+		}
+		if err := grep(out, f, name, ms); err != nil {
+			fmt.Fprintln(os.Stderr, "ndbgrep:", err)
+			status = 1
+		}
+		f.Close()
+	}
+	// os.Exit does not run deferred calls
+	if err := out.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, "ndbgrep:", err)
+		status = 1
+	}
+	os.Exit(status)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const input = `sys=fir ip=10.0.0.1
+	dom=fir.example.com
+sys=oak ip=10.0.0.2
+sys=elm
+`
+
+func TestGrep(t *testing.T) {
+	tests := []struct {
+		exprs []string
+		out   string
+	}{
+		{[]string{"sys=fir"}, "sys=fir ip=10.0.0.1\n\tdom=fir.example.com\n"},
+		{[]string{"dom"}, "sys=fir ip=10.0.0.1\n\tdom=fir.example.com\n"},
+		{[]string{"ip~10.0.0.*", "!dom"}, "sys=oak ip=10.0.0.2\n"},
+		{[]string{"!ip"}, "sys=elm\n"},
+	}
+	for _, tt := range tests {
+		var ms []matcher
+		for _, s := range tt.exprs {
+			m, err := parseExpr(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ms = append(ms, m)
+		}
+		var buf bytes.Buffer
+		if err := grep(&buf, strings.NewReader(input), "input", ms); err != nil {
+			t.Error(err)
+		} else if buf.String() != tt.out {
+			t.Errorf("%v: got %q, wanted %q", tt.exprs, buf.String(), tt.out)
+		}
+	}
+}
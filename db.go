@@ -0,0 +1,316 @@
+package ndb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Database is an in-memory collection of ndb entries. Its methods
+// may be called concurrently from any number of goroutines, including
+// with Reload, Watch, SortBy and Index, which change the entries of
+// the Database while holding a write lock; queries such as Search and
+// Ipinfo hold a read lock, so they see the entries either before or
+// after a change, never a mixture. Entries and slices of entries
+// returned by a Database are never modified by it afterward, so they
+// may be used without locking after the Database changes.
+type Database struct {
+	mu      sync.RWMutex
+	path    string
+	entries []Entry
+	offsets []int64 // file offset of each entry, if unsorted
+	mtime   uint32
+	origins map[*Pair]Position // by first tuple of each entry
+	file    fileState          // of the file at path when read
+	indexes map[string]index   // by attribute, built by Index
+	mapped  bool               // opened with MapFile
+	lazy    *lazyFile          // unparsed entries, if mapped
+	skipped error              // first syntax error skipped by load
+	cache   *resultCache       // of query results, if enabled
+	metrics Metrics
+
+	hmu    sync.Mutex
+	hashes map[string]*hashFile
+
+	poll time.Duration // interval at which Watch checks the file
+}
+
+// A Position describes where an entry was read from.
+type Position struct {
+	Path   string // file name, if the Database was opened with Open
+	Line   int    // 1-based line number of the entry's first line
+	Offset int64  // byte offset of the entry
+}
+
+// String returns the position in the form path:line, or line N if
+// the path is unknown.
+func (p Position) String() string {
+	if p.Path == "" {
+		return "line " + strconv.Itoa(p.Line)
+	}
+	return p.Path + ":" + strconv.Itoa(p.Line)
+}
+
+// Open reads the ndb file at path and returns its entries as a
+// Database, like Plan 9's ndbopen. Of the options, only MapFile and
+// ReportMetrics apply to Open.
+func Open(path string, opts ...Option) (*Database, error) {
+	var db *Database
+	var err error
+	if c := newConfig(opts); c.mmap {
+		db, _, err = openMapped(path, c.metrics)
+	} else {
+		db, _, err = openFile(path, c.metrics)
+	}
+	return db, err
+}
+
+// openFile reads the ndb file at path into a new Database reporting
+// to m, and returns the contents of the file.
+func openFile(path string, m Metrics) (*Database, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	src, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := openReader(bytes.NewReader(src), m)
+	if err != nil {
+		return nil, nil, err
+	}
+	db.path = path
+	db.mtime = uint32(fi.ModTime().Unix())
+	db.file = newFileState(fi, src)
+	return db, src, nil
+}
+
+// OpenReader reads every entry from r and returns them as a
+// Database. Blank lines are skipped.
+func OpenReader(r io.Reader) (*Database, error) {
+	return openReader(r, nil)
+}
+
+func openReader(r io.Reader, m Metrics) (*Database, error) {
+	db := &Database{origins: make(map[*Pair]Position), metrics: m}
+	d := NewDecoderWith(r, ReportMetrics(m))
+	for {
+		p, err := d.getPairs()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(p) > 0 {
+			e := newEntry(p)
+			db.entries = append(db.entries, e)
+			db.offsets = append(db.offsets, d.start)
+			db.origins[&e[0]] = Position{Line: d.spans[0].line, Offset: d.start}
+		}
+	}
+	return db, nil
+}
+
+// Position returns the position in the input of an entry returned by
+// the Database, so that tools may report where a tuple came from.
+// It returns false for an entry that was not read from the input,
+// such as one built by Join or Project.
+func (db *Database) Position(e Entry) (Position, bool) {
+	if len(e) == 0 {
+		return Position{}, false
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.lazy != nil {
+		db.lazy.mu.Lock()
+		defer db.lazy.mu.Unlock()
+	}
+	pos, ok := db.origins[&e[0]]
+	pos.Path = db.path
+	return pos, ok
+}
+
+// Search returns every entry in the Database containing the tuple
+// attr=val, in the order they appear, like Plan 9's ndbsearch. If
+// attr is indexed, as by Index, the index is used to find the
+// entries. Otherwise, if the Database was opened with Open and an up
+// to date hash file for attr exists, as created by MkHash, it is used.
+func (db *Database) Search(attr, val string) ([]Entry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	count(db.metrics, MetricSearches, 1)
+	if ix, ok := db.indexes[attr]; ok {
+		return db.searchIndex(ix, val), nil
+	}
+	if h := db.hash(attr); h != nil {
+		return db.searchHash(h, attr, val)
+	}
+	if db.lazy != nil {
+		return db.searchLazy(attr, val)
+	}
+	var found []Entry
+	for _, e := range db.entries {
+		if e.has(attr, val) {
+			found = append(found, e)
+		}
+	}
+	return found, nil
+}
+
+// ErrNotFound is returned by Value when no entry has the tuple
+// searched for and the attribute wanted.
+var ErrNotFound = errors.New("Attribute not found")
+
+// Value returns the value of wantAttr in the first entry containing
+// the tuple matchAttr=matchVal that has one, like Plan 9's
+// ndbgetvalue, answering questions such as "what is the ip of
+// sys=fir". Within the entry, the tuples from the matched one onward
+// are searched first, wrapping around, as with Entry.FindAttr. If no
+// entry has the value, Value returns ErrNotFound.
+func (db *Database) Value(matchAttr, matchVal, wantAttr string) (string, error) {
+	found, err := db.Search(matchAttr, matchVal)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range found {
+		for i, p := range e {
+			if p.Attr != matchAttr || p.Val != matchVal {
+				continue
+			}
+			if f := e.FindAttr(wantAttr, i); f != nil {
+				return f[0].Val, nil
+			}
+			break
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Entries returns the entries in the Database, in the order they
+// were read. The returned slice must not be modified.
+func (db *Database) Entries() []Entry {
+	db.rlock()
+	defer db.mu.RUnlock()
+	return db.entries
+}
+
+// Clone returns a deep copy of the Database. Changes made to the
+// clone are not visible in db, and vice versa, so a clone may be
+// used as a snapshot while db is modified or reloaded.
+func (db *Database) Clone() *Database {
+	db.rlock()
+	defer db.mu.RUnlock()
+	c := &Database{entries: make([]Entry, len(db.entries))}
+	if db.origins != nil {
+		c.path = db.path
+		c.origins = make(map[*Pair]Position, len(db.origins))
+	}
+	for i, e := range db.entries {
+		c.entries[i] = append(Entry(nil), e...)
+		if len(e) == 0 {
+			continue
+		}
+		if pos, ok := db.origins[&e[0]]; ok {
+			c.origins[&c.entries[i][0]] = pos
+		}
+	}
+	c.indexes = buildIndexes(c.entries, db.indexAttrs())
+	return c
+}
+
+// Attrs returns the attribute names present in the Database,
+// mapped to the number of tuples in which each attribute appears.
+func (db *Database) Attrs() map[string]int {
+	db.rlock()
+	defer db.mu.RUnlock()
+	attrs := make(map[string]int)
+	for _, e := range db.entries {
+		for _, p := range e {
+			attrs[p.Attr]++
+		}
+	}
+	return attrs
+}
+
+// Project returns a new Database whose entries contain only the
+// tuples with the named attributes. Entries left with no tuples
+// are dropped.
+func (db *Database) Project(attrs ...string) *Database {
+	db.rlock()
+	defer db.mu.RUnlock()
+	keep := make(map[string]struct{}, len(attrs))
+	for _, a := range attrs {
+		keep[a] = struct{}{}
+	}
+	p := new(Database)
+	for _, e := range db.entries {
+		var add Entry
+		for _, t := range e {
+			if _, ok := keep[t.Attr]; ok {
+				add = append(add, t)
+			}
+		}
+		if len(add) > 0 {
+			p.entries = append(p.entries, add)
+		}
+	}
+	return p
+}
+
+// GroupBy buckets the entries in the Database by their values
+// for attr. An entry with several values for attr appears in
+// each of their groups; entries without attr are omitted.
+func (db *Database) GroupBy(attr string) map[string][]Entry {
+	db.rlock()
+	defer db.mu.RUnlock()
+	groups := make(map[string][]Entry)
+	for _, e := range db.entries {
+		groupEntry(groups, e, attr)
+	}
+	return groups
+}
+
+// Map returns the entries in the Database indexed by their values for
+// keyAttr, as a lookup table. An entry with several values for keyAttr
+// is indexed under each; entries without keyAttr are omitted. If two
+// entries have the same value for keyAttr, Map returns an error.
+func (db *Database) Map(keyAttr string) (map[string]Entry, error) {
+	db.rlock()
+	defer db.mu.RUnlock()
+	m := make(map[string]Entry, len(db.entries))
+	owner := make(map[string]int, len(db.entries))
+	for i, e := range db.entries {
+		for _, v := range e.GetAll(keyAttr) {
+			if j, ok := owner[v]; ok && j != i {
+				return nil, errDuplicateKey(keyAttr, v)
+			}
+			owner[v] = i
+			m[v] = e
+		}
+	}
+	return m, nil
+}
+
+func errDuplicateKey(attr, val string) error {
+	return errors.New("ndb: duplicate key " + attr + "=" + val)
+}
+
+func groupEntry(groups map[string][]Entry, e Entry, attr string) {
+	seen := make(map[string]struct{})
+	for _, v := range e.GetAll(attr) {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			groups[v] = append(groups[v], e)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package ndb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDB = `ipnet=murray-hill ip=135.104.0.0 ipmask=255.255.0.0
+sys=fir ip=135.104.9.1 dom=fir.example.com
+sys=oak ip=135.104.9.2 ip=135.104.9.3 dom=oak.example.com
+`
+
+func openTestDB(t *testing.T) *Database {
+	db, err := OpenReader(strings.NewReader(testDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestClone(t *testing.T) {
+	db := openTestDB(t)
+	c := db.Clone()
+	c.Entries()[1][0].Val = "pine"
+	if db.Entries()[1][0].Val != "fir" {
+		t.Errorf("modifying clone changed original: %v", db.Entries()[1])
+	}
+	if len(c.Entries()) != 3 {
+		t.Errorf("Got %d entries in clone, wanted 3", len(c.Entries()))
+	}
+}
+
+func TestAttrs(t *testing.T) {
+	want := map[string]int{"ipnet": 1, "ip": 4, "ipmask": 1, "sys": 2, "dom": 2}
+	got := openTestDB(t).Attrs()
+	if len(got) != len(want) {
+		t.Errorf("Got %v, wanted %v", got, want)
+	}
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("Got %v, wanted %v", got, want)
+		}
+	}
+}
+
+func TestProject(t *testing.T) {
+	p := openTestDB(t).Project("sys", "dom")
+	if len(p.Entries()) != 2 {
+		t.Fatalf("Got %d entries, wanted 2", len(p.Entries()))
+	}
+	for _, e := range p.Entries() {
+		if len(e) != 2 || e[0].Attr != "sys" || e[1].Attr != "dom" {
+			t.Errorf("Got %v, wanted sys and dom only", e)
+		}
+	}
+}
+
+const testInventory = `sys=fir owner=alice rack=r1
+sys=oak owner=bob rack=r2
+sys=elm owner=carol rack=r3
+`
+
+func TestJoin(t *testing.T) {
+	inv, err := OpenReader(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := Join(openTestDB(t), inv, "sys", JoinFirst)
+	if len(j.Entries()) != 2 {
+		t.Fatalf("Got %d entries, wanted 2: %v", len(j.Entries()), j.Entries())
+	}
+	e := j.Entries()[0]
+	if v := e.GetAll("owner"); len(v) != 1 || v[0] != "alice" {
+		t.Errorf("Got %v, wanted owner=alice", e)
+	}
+	if v := e.GetAll("sys"); len(v) != 1 {
+		t.Errorf("Got %v, wanted a single sys tuple", e)
+	}
+}
+
+func TestJoinLockOrder(t *testing.T) {
+	a, _ := OpenReader(strings.NewReader("sys=fir ip=10.0.0.1\n"))
+	b, _ := OpenReader(strings.NewReader("sys=fir owner=alice\n"))
+
+	// While b is locked by a writer, as by a Join of b and a
+	// waiting for a, Join(a, b) must not hold a's lock.
+	b.mu.Lock()
+	joined := make(chan *Database)
+	go func() { joined <- Join(a, b, "sys", JoinFirst) }()
+	time.Sleep(10 * time.Millisecond)
+	locked := make(chan struct{})
+	go func() {
+		a.SetPollInterval(time.Second)
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Error("Join held the lock of a while waiting for b")
+	}
+	b.mu.Unlock()
+	if j := <-joined; len(j.Entries()) != 1 {
+		t.Errorf("Got %v, wanted one entry", j.Entries())
+	}
+	<-locked
+}
+
+func TestMerge(t *testing.T) {
+	site, _ := OpenReader(strings.NewReader("sys=fir ip=10.0.0.1 dom=fir.example.com\nsys=oak ip=10.0.0.2\n"))
+	local, _ := OpenReader(strings.NewReader("sys=fir ip=10.0.0.9 ip=10.0.0.10 bootf=/386/9pxeload\nsys=elm\n"))
+
+	m := Merge(local, site)
+	want := []string{
+		"sys=fir ip=10.0.0.9 ip=10.0.0.10 bootf=/386/9pxeload dom=fir.example.com",
+		"sys=elm",
+		"sys=oak ip=10.0.0.2",
+	}
+	checkEntries(t, m.Entries(), want)
+
+	m = MergeUnion(local, site)
+	want[0] = "sys=fir ip=10.0.0.9 ip=10.0.0.10 bootf=/386/9pxeload ip=10.0.0.1 dom=fir.example.com"
+	checkEntries(t, m.Entries(), want)
+	if len(local.Entries()[0]) != 4 {
+		t.Errorf("Merge modified its input: %v", local.Entries()[0])
+	}
+}
+
+func checkEntries(t *testing.T, entries []Entry, want []string) {
+	t.Helper()
+	if len(entries) != len(want) {
+		t.Fatalf("Got %d entries, wanted %d", len(entries), len(want))
+	}
+	for i, e := range entries {
+		if b, _ := AppendEntry(nil, e); string(b) != want[i] {
+			t.Errorf("Got %s, wanted %s", b, want[i])
+		}
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	g := openTestDB(t).GroupBy("ip")
+	if len(g) != 4 {
+		t.Errorf("Got %d groups, wanted 4: %v", len(g), g)
+	}
+	if len(g["135.104.9.3"]) != 1 || g["135.104.9.3"][0].GetAll("sys")[0] != "oak" {
+		t.Errorf("Got %v for 135.104.9.3, wanted sys=oak", g["135.104.9.3"])
+	}
+	dg, err := NewDecoder(strings.NewReader(testDB)).GroupBy("ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dg) != len(g) {
+		t.Errorf("Decoder.GroupBy got %v, wanted %v", dg, g)
+	}
+}
+
+func TestDatabaseMap(t *testing.T) {
+	db := openTestDB(t)
+	m, err := db.Map("ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 4 || m["135.104.9.3"].Get("sys") != "oak" || m["135.104.9.2"].Get("sys") != "oak" {
+		t.Errorf("Got %v", m)
+	}
+	if m, err := db.Map("sys"); err != nil || len(m) != 2 {
+		t.Errorf("Got %v, %v, wanted 2 entries", m, err)
+	}
+	db.entries = append(db.entries, Entry{{"sys", "fir"}})
+	if _, err := db.Map("sys"); err == nil {
+		t.Error("Got nil, wanted error for duplicate key")
+	}
+}
+
+func TestPosition(t *testing.T) {
+	db, err := OpenReader(strings.NewReader("# hosts\n" + testDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, _ := db.Search("sys", "oak")
+	if len(found) != 1 {
+		t.Fatalf("Got %v, wanted sys=oak", found)
+	}
+	pos, ok := db.Position(found[0])
+	if want := (Position{Line: 4, Offset: 103}); !ok || pos != want {
+		t.Errorf("Got %+v, wanted %+v", pos, want)
+	}
+	if pos.String() != "line 4" {
+		t.Errorf("Got %s, wanted line 4", pos)
+	}
+	c := db.Clone()
+	if pos, ok := c.Position(c.Entries()[0]); !ok || pos.Line != 2 {
+		t.Errorf("Got %+v, %v for clone, wanted line 2", pos, ok)
+	}
+	if _, ok := db.Position(Entry{{"sys", "oak"}}); ok {
+		t.Error("Got position for an entry not in the Database")
+	}
+}
+
+func TestOpenSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local")
+	if err := os.WriteFile(path, []byte(testDB), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := db.Search("ip", "135.104.9.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Get("sys") != "oak" {
+		t.Errorf("Search(ip, 135.104.9.3) = %v, wanted sys=oak", found)
+	}
+	if pos, _ := db.Position(found[0]); pos.String() != path+":3" {
+		t.Errorf("Got position %s, wanted %s:3", pos, path)
+	}
+	if found, _ := db.Search("sys", "elm"); len(found) != 0 {
+		t.Errorf("Search(sys, elm) = %v, wanted no entries", found)
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Open succeeded on a missing file")
+	}
+}
+
+func TestValue(t *testing.T) {
+	db, err := OpenReader(strings.NewReader("sys=fir ip=10.0.0.1\n\tether=0011aabbccdd ip=10.0.0.2\nsys=oak\nsys=oak dom=oak.example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		attr, val, want, result string
+	}{
+		{"sys", "fir", "ip", "10.0.0.1"},
+		{"ether", "0011aabbccdd", "ip", "10.0.0.2"},
+		{"ip", "10.0.0.2", "sys", "fir"},
+		{"sys", "oak", "dom", "oak.example.com"},
+	}
+	for _, tt := range tests {
+		v, err := db.Value(tt.attr, tt.val, tt.want)
+		if err != nil || v != tt.result {
+			t.Errorf("Value(%s, %s, %s): Got %q, %v, wanted %q", tt.attr, tt.val, tt.want, v, err, tt.result)
+		}
+	}
+	if _, err := db.Value("sys", "elm", "ip"); err != ErrNotFound {
+		t.Errorf("Got %v, wanted %v", err, ErrNotFound)
+	}
+	if _, err := db.Value("sys", "fir", "bootf"); err != ErrNotFound {
+		t.Errorf("Got %v, wanted %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,81 @@
+package ndb
+
+import (
+	"net"
+	"slices"
+)
+
+// A BootHost holds the parameters a DHCP or BOOTP server needs to
+// serve a host, taken from its entry and, for those the entry lacks,
+// from the ipnet= entries of the networks containing its address.
+type BootHost struct {
+	Ether   net.HardwareAddr
+	Sys     string
+	IP      net.IP
+	Mask    net.IPMask // from ipmask=
+	Gateway net.IP     // from ipgw=
+	DNS     []net.IP   // from dns=
+	Bootf   string     // boot file
+	DHCP    string     // from dhcp=, the kind of DHCP client
+	Entry   Entry
+}
+
+// BootParams returns the boot parameters of the host with the
+// Ethernet address mac, from the first entry with an equal ether=
+// attribute. The host's address is its first valid ip=; the other
+// parameters are inherited as by Ipinfo. If the entry has no address,
+// they are taken from the entry alone. BootParams returns false if no
+// entry has the address mac.
+func (db *Database) BootParams(mac net.HardwareAddr) (BootHost, bool) {
+	db.rlock()
+	defer db.mu.RUnlock()
+	e, ok := db.findByEther(mac)
+	if !ok {
+		return BootHost{}, false
+	}
+	host := BootHost{Ether: mac, Sys: e.Get("sys"), Entry: e}
+	for _, s := range e.GetAll("ip") {
+		if host.IP = net.ParseIP(s); host.IP != nil {
+			break
+		}
+	}
+	attrs := []string{"ipmask", "ipgw", "dns", "bootf", "dhcp"}
+	var info []Pair
+	if host.IP != nil {
+		info, _ = db.ipinfo(host.IP.String(), attrs...)
+	} else {
+		for _, p := range e {
+			if slices.Contains(attrs, p.Attr) {
+				info = append(info, p)
+			}
+		}
+	}
+	for _, p := range info {
+		switch p.Attr {
+		case "ipmask":
+			if m := net.ParseIP(p.Val); m != nil && host.Mask == nil {
+				if m4 := m.To4(); m4 != nil {
+					m = m4
+				}
+				host.Mask = net.IPMask(m)
+			}
+		case "ipgw":
+			if host.Gateway == nil {
+				host.Gateway = net.ParseIP(p.Val)
+			}
+		case "dns":
+			if ip := net.ParseIP(p.Val); ip != nil {
+				host.DNS = append(host.DNS, ip)
+			}
+		case "bootf":
+			if host.Bootf == "" {
+				host.Bootf = p.Val
+			}
+		case "dhcp":
+			if host.DHCP == "" {
+				host.DHCP = p.Val
+			}
+		}
+	}
+	return host, true
+}
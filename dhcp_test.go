@@ -0,0 +1,38 @@
+package ndb
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestBootParams(t *testing.T) {
+	db, err := OpenReader(strings.NewReader(testNetworks + `ipnet=unix-room-boot ip=135.104.117.0 ipmask=255.255.255.0
+	bootf=/386/9pxeload
+sys=carl ip=135.104.117.6 ether=0011aabbccdd dhcp=ipconfig
+sys=dora ether=00:11:aa:bb:cc:ee bootf=/arm/9pi
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, _ := ParseEther("0011aabbccdd")
+	host, ok := db.BootParams(mac)
+	if !ok {
+		t.Fatal("no entry for 0011aabbccdd")
+	}
+	got := fmt.Sprintf("%s %s %s %s %v %s %s", host.Sys, host.IP, host.Mask, host.Gateway, host.DNS, host.Bootf, host.DHCP)
+	want := "carl 135.104.117.6 ffffff00 135.104.117.1 [135.104.1.1 135.104.1.2] /386/9pxeload ipconfig"
+	if got != want {
+		t.Errorf("Got %s, wanted %s", got, want)
+	}
+
+	mac, _ = net.ParseMAC("00:11:aa:bb:cc:ee")
+	if host, ok := db.BootParams(mac); !ok || host.Sys != "dora" || host.IP != nil || host.Bootf != "/arm/9pi" {
+		t.Errorf("Got %+v, %v, wanted dora without an address", host, ok)
+	}
+	mac, _ = net.ParseMAC("00:11:aa:bb:cc:ff")
+	if _, ok := db.BootParams(mac); ok {
+		t.Error("Got true for unknown address")
+	}
+}
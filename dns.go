@@ -0,0 +1,126 @@
+package ndb
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// A DNSRecord is a DNS resource record derived from the entries of
+// a Database, in the manner of Plan 9's ndb/dns. Names are fully
+// qualified, ending with a dot.
+type DNSRecord struct {
+	Name  string
+	Type  string // A, AAAA, CNAME, MX or PTR
+	Value string // an address, or a fully qualified name
+	Pref  int    // preference, for MX records
+}
+
+// ForwardRecords returns the records for the names given by the dom=
+// attributes of the Database's entries, in the order of the entries:
+// an A or AAAA record for each ip=, a CNAME record for each cname=,
+// and an MX record for each mx=. The preference of an MX record is
+// given by the first pref= following its mx=, before the next mx=,
+// and is 0 if there is none. Values that are not valid addresses or
+// numbers are skipped.
+func (db *Database) ForwardRecords() []DNSRecord {
+	db.rlock()
+	defer db.mu.RUnlock()
+	var rr []DNSRecord
+	for _, e := range db.entries {
+		for _, dom := range e.GetAll("dom") {
+			name := fqdn(dom)
+			for i, p := range e {
+				switch p.Attr {
+				case "ip":
+					ip := net.ParseIP(p.Val)
+					if ip == nil {
+						continue
+					}
+					typ := "AAAA"
+					if ip.To4() != nil {
+						typ = "A"
+					}
+					rr = append(rr, DNSRecord{Name: name, Type: typ, Value: ip.String()})
+				case "cname":
+					rr = append(rr, DNSRecord{Name: name, Type: "CNAME", Value: fqdn(p.Val)})
+				case "mx":
+					pref, ok := mxPref(e[i+1:])
+					if !ok {
+						continue
+					}
+					rr = append(rr, DNSRecord{Name: name, Type: "MX", Value: fqdn(p.Val), Pref: pref})
+				}
+			}
+		}
+	}
+	return rr
+}
+
+// mxPref returns the value of the first pref= in e before the next
+// mx=, or 0. It returns false if the value is not a number.
+func mxPref(e Entry) (int, bool) {
+	for _, p := range e {
+		switch p.Attr {
+		case "mx":
+			return 0, true
+		case "pref":
+			n, err := strconv.Atoi(p.Val)
+			return n, err == nil
+		}
+	}
+	return 0, true
+}
+
+// ReverseRecords returns a PTR record, in the in-addr.arpa or
+// ip6.arpa domain, for each ip= of the entries in the Database with a
+// dom= attribute, naming the first dom= of the entry, in the order of
+// the entries.
+func (db *Database) ReverseRecords() []DNSRecord {
+	db.rlock()
+	defer db.mu.RUnlock()
+	var rr []DNSRecord
+	for _, e := range db.entries {
+		dom, ok := e.first("dom")
+		if !ok {
+			continue
+		}
+		for _, v := range e.GetAll("ip") {
+			if ip := net.ParseIP(v); ip != nil {
+				rr = append(rr, DNSRecord{Name: reverseName(ip), Type: "PTR", Value: fqdn(dom)})
+			}
+		}
+	}
+	return rr
+}
+
+// reverseName returns the name of ip in the in-addr.arpa or ip6.arpa
+// domain.
+func reverseName(ip net.IP) string {
+	var b strings.Builder
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			b.WriteString(strconv.Itoa(int(ip4[i])))
+			b.WriteByte('.')
+		}
+		b.WriteString("in-addr.arpa.")
+		return b.String()
+	}
+	const hexDigits = "0123456789abcdef"
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[ip[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String()
+}
+
+// fqdn returns name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
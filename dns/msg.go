@@ -0,0 +1,191 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// Resource record types and classes understood by the server.
+const (
+	typeA     = 1
+	typeCNAME = 5
+	typePTR   = 12
+	typeAAAA  = 28
+
+	classINET = 1
+)
+
+// Response codes.
+const (
+	rcodeSuccess        = 0
+	rcodeFormatError    = 1
+	rcodeNameError      = 3
+	rcodeNotImplemented = 4
+)
+
+const headerLen = 12
+
+var (
+	errShort    = errors.New("dns: message too short")
+	errBadName  = errors.New("dns: malformed domain name")
+	errLongName = errors.New("dns: domain name too long")
+)
+
+type header struct {
+	id      uint16
+	flags   uint16
+	qdcount uint16
+	ancount uint16
+}
+
+func (h header) opcode() int    { return int(h.flags>>11) & 0xf }
+func (h header) response() bool { return h.flags&(1<<15) != 0 }
+
+type question struct {
+	name  string
+	qtype uint16
+	class uint16
+}
+
+type resource struct {
+	name  string
+	rtype uint16
+	ttl   uint32
+	data  []byte
+}
+
+// parseQuery parses the header and first question of a DNS message.
+// Only the question section is examined.
+func parseQuery(b []byte) (header, question, error) {
+	var h header
+	var q question
+	if len(b) < headerLen {
+		return h, q, errShort
+	}
+	h.id = binary.BigEndian.Uint16(b[0:])
+	h.flags = binary.BigEndian.Uint16(b[2:])
+	h.qdcount = binary.BigEndian.Uint16(b[4:])
+	if h.qdcount == 0 {
+		return h, q, nil
+	}
+	name, off, err := readName(b, headerLen)
+	if err != nil {
+		return h, q, err
+	}
+	if off+4 > len(b) {
+		return h, q, errShort
+	}
+	q.name = name
+	q.qtype = binary.BigEndian.Uint16(b[off:])
+	q.class = binary.BigEndian.Uint16(b[off+2:])
+	return h, q, nil
+}
+
+// readName reads a possibly compressed domain name starting at
+// off, returning it in presentation form without the trailing dot,
+// and the offset of the first byte after it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for hops := 0; ; hops++ {
+		if off >= len(b) || hops > 64 {
+			return "", 0, errBadName
+		}
+		n := int(b[off])
+		switch n & 0xc0 {
+		case 0x00:
+			if n == 0 {
+				if end < 0 {
+					end = off + 1
+				}
+				return strings.Join(labels, "."), end, nil
+			}
+			if off+1+n > len(b) {
+				return "", 0, errShort
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		case 0xc0:
+			if off+2 > len(b) {
+				return "", 0, errShort
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		default:
+			return "", 0, errBadName
+		}
+	}
+}
+
+// appendName appends the uncompressed wire form of name to b.
+func appendName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return nil, errLongName
+	}
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, errBadName
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// packResponse builds a response to query h, q, containing the
+// given answers. If the message would exceed max bytes, the answers
+// are dropped and the truncation bit is set.
+func packResponse(h header, q question, rcode int, answers []resource, max int) ([]byte, error) {
+	// QR, AA, with the query's opcode and RD bit
+	flags := uint16(1<<15|1<<10) | h.flags&(0xf<<11|1<<8) | uint16(rcode)
+	b := make([]byte, 0, 512)
+	b = appendUint16(b, h.id)
+	b = appendUint16(b, flags)
+	if h.qdcount == 0 {
+		b = appendUint16(b, 0)
+	} else {
+		b = appendUint16(b, 1)
+	}
+	b = appendUint16(b, uint16(len(answers)))
+	b = appendUint16(b, 0)
+	b = appendUint16(b, 0)
+	var err error
+	if h.qdcount > 0 {
+		if b, err = appendName(b, q.name); err != nil {
+			return nil, err
+		}
+		b = appendUint16(b, q.qtype)
+		b = appendUint16(b, q.class)
+	}
+	qlen := len(b)
+	for _, rr := range answers {
+		if b, err = appendName(b, rr.name); err != nil {
+			return nil, err
+		}
+		b = appendUint16(b, rr.rtype)
+		b = appendUint16(b, classINET)
+		b = appendUint32(b, rr.ttl)
+		b = appendUint16(b, uint16(len(rr.data)))
+		b = append(b, rr.data...)
+	}
+	if max > 0 && len(b) > max {
+		b = b[:qlen]
+		b[2] |= 0x02 // TC
+		b[6], b[7] = 0, 0
+	}
+	return b, nil
+}
@@ -0,0 +1,318 @@
+// Package dns implements a small DNS server that answers queries
+// directly from an ndb database, in the manner of Plan 9's ndb/dns.
+//
+// The server is authoritative for every name in the database. It
+// answers A and AAAA queries from the ip= attributes of entries whose
+// dom= attribute matches the query name, PTR queries for in-addr.arpa
+// and ip6.arpa names from the dom= attributes of entries with a
+// matching ip= attribute, and CNAME queries from entries with both
+// dom= and cname= attributes. Address queries for an alias are
+// answered with the CNAME record followed by the addresses of its
+// target, if the target is in the database.
+package dns
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"aqwari.net/encoding/ndb"
+)
+
+// DefaultTTL is the time to live, in seconds, of answers from a
+// Server whose TTL is zero.
+const DefaultTTL = 300
+
+// maxUDPLen is the largest response sent over UDP.
+const maxUDPLen = 512
+
+// A Server answers DNS queries from an ndb Database. The Database
+// may be replaced at any time with SetDatabase or ReloadFile, without
+// interrupting queries in progress.
+type Server struct {
+	// TTL is the time to live, in seconds, of every answer.
+	TTL uint32
+
+	mu sync.RWMutex
+	db *ndb.Database
+}
+
+// NewServer returns a Server answering queries from db.
+func NewServer(db *ndb.Database) *Server {
+	return &Server{db: db}
+}
+
+// SetDatabase replaces the Database used to answer queries.
+func (s *Server) SetDatabase(db *ndb.Database) {
+	s.mu.Lock()
+	s.db = db
+	s.mu.Unlock()
+}
+
+// ReloadFile reads the ndb file at path and, if it parses without
+// error, replaces the Database used to answer queries. On error,
+// the previous Database remains in use.
+func (s *Server) ReloadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	db, err := ndb.OpenReader(f)
+	if err != nil {
+		return err
+	}
+	s.SetDatabase(db)
+	return nil
+}
+
+func (s *Server) database() *ndb.Database {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db
+}
+
+func (s *Server) ttl() uint32 {
+	if s.TTL == 0 {
+		return DefaultTTL
+	}
+	return s.TTL
+}
+
+// ListenAndServe answers queries sent to addr over both UDP and TCP.
+// It returns when either listener fails.
+func (s *Server) ListenAndServe(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	errc := make(chan error, 2)
+	go func() { errc <- s.ServePacket(pc) }()
+	go func() { errc <- s.Serve(l) }()
+	return <-errc
+}
+
+// ServePacket answers queries received on a packet connection,
+// such as a UDP socket, until reading from it fails.
+func (s *Server) ServePacket(pc net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if resp := s.respond(buf[:n], maxUDPLen); resp != nil {
+			pc.WriteTo(resp, addr)
+		}
+	}
+}
+
+// Serve answers queries received on connections accepted from l,
+// using the TCP message framing, until accepting fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(c)
+	}
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	defer c.Close()
+	var size [2]byte
+	for {
+		if _, err := io.ReadFull(c, size[:]); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(c, msg); err != nil {
+			return
+		}
+		resp := s.respond(msg, 0xffff)
+		if resp == nil {
+			return
+		}
+		resp = append(appendUint16(nil, uint16(len(resp))), resp...)
+		if _, err := c.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// respond returns the response to the query msg, or nil if no
+// response should be sent.
+func (s *Server) respond(msg []byte, max int) []byte {
+	h, q, err := parseQuery(msg)
+	if err != nil {
+		if len(msg) < headerLen {
+			return nil
+		}
+		resp, _ := packResponse(h, q, rcodeFormatError, nil, max)
+		return resp
+	}
+	if h.response() {
+		return nil
+	}
+	var answers []resource
+	rcode := rcodeSuccess
+	switch {
+	case h.opcode() != 0:
+		rcode = rcodeNotImplemented
+	case h.qdcount != 1:
+		rcode = rcodeFormatError
+	default:
+		var found bool
+		answers, found = s.lookup(q)
+		if !found {
+			rcode = rcodeNameError
+		}
+	}
+	resp, err := packResponse(h, q, rcode, answers, max)
+	if err != nil {
+		resp, _ = packResponse(h, question{}, rcodeFormatError, nil, max)
+	}
+	return resp
+}
+
+// lookup returns the answers to q, and whether the name exists
+// in the database at all.
+func (s *Server) lookup(q question) ([]resource, bool) {
+	db := s.database()
+	if db == nil || q.class != classINET {
+		return nil, false
+	}
+	name := canonical(q.name)
+	if ip := reverseAddr(name); ip != nil {
+		if q.qtype != typePTR {
+			return nil, len(findPTR(db, ip)) > 0
+		}
+		var answers []resource
+		for _, dom := range findPTR(db, ip) {
+			data, err := appendName(nil, dom)
+			if err != nil {
+				continue
+			}
+			answers = append(answers, resource{q.name, typePTR, s.ttl(), data})
+		}
+		return answers, len(answers) > 0
+	}
+
+	entries := findDom(db, name)
+	if len(entries) == 0 {
+		return nil, false
+	}
+	var answers []resource
+	for _, e := range entries {
+		for _, target := range e.GetAll("cname") {
+			data, err := appendName(nil, target)
+			if err != nil {
+				continue
+			}
+			answers = append(answers, resource{q.name, typeCNAME, s.ttl(), data})
+			if q.qtype == typeA || q.qtype == typeAAAA {
+				for _, t := range findDom(db, canonical(target)) {
+					answers = append(answers, s.addrs(target, t, q.qtype)...)
+				}
+			}
+		}
+		if q.qtype == typeA || q.qtype == typeAAAA {
+			answers = append(answers, s.addrs(q.name, e, q.qtype)...)
+		}
+	}
+	return answers, true
+}
+
+// addrs returns the address records of type qtype for the entry e.
+func (s *Server) addrs(name string, e ndb.Entry, qtype uint16) []resource {
+	var answers []resource
+	for _, v := range e.GetAll("ip") {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil && qtype == typeA {
+			answers = append(answers, resource{name, typeA, s.ttl(), ip4})
+		} else if ip4 == nil && qtype == typeAAAA {
+			answers = append(answers, resource{name, typeAAAA, s.ttl(), ip.To16()})
+		}
+	}
+	return answers
+}
+
+func canonical(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// findDom returns the entries with a dom= attribute matching name.
+func findDom(db *ndb.Database, name string) []ndb.Entry {
+	var found []ndb.Entry
+	for _, e := range db.Entries() {
+		for _, dom := range e.GetAll("dom") {
+			if canonical(dom) == name {
+				found = append(found, e)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// findPTR returns the dom= values of entries with an ip= attribute
+// equal to ip.
+func findPTR(db *ndb.Database, ip net.IP) []string {
+	var names []string
+	for _, e := range db.Entries() {
+		for _, v := range e.GetAll("ip") {
+			if ip.Equal(net.ParseIP(v)) {
+				names = append(names, e.GetAll("dom")...)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// reverseAddr returns the address named by an in-addr.arpa or
+// ip6.arpa name, or nil if name is not a complete reverse name.
+func reverseAddr(name string) net.IP {
+	if rest := strings.TrimSuffix(name, ".in-addr.arpa"); rest != name {
+		parts := strings.Split(rest, ".")
+		if len(parts) != 4 {
+			return nil
+		}
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+		return net.ParseIP(strings.Join(parts, ".")).To4()
+	}
+	if rest := strings.TrimSuffix(name, ".ip6.arpa"); rest != name {
+		nibbles := strings.Split(rest, ".")
+		if len(nibbles) != 32 {
+			return nil
+		}
+		var hex []byte
+		for i := len(nibbles) - 1; i >= 0; i-- {
+			if len(nibbles[i]) != 1 {
+				return nil
+			}
+			hex = append(hex, nibbles[i][0])
+			if i%4 == 0 && i > 0 {
+				hex = append(hex, ':')
+			}
+		}
+		return net.ParseIP(string(hex))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"aqwari.net/encoding/ndb"
+)
+
+const testDB = `sys=fir ip=135.104.9.1 ip=2001:db8::1 dom=fir.example.com
+sys=oak ip=135.104.9.2 dom=oak.example.com
+dom=www.example.com cname=fir.example.com
+`
+
+func testServer(t *testing.T) *Server {
+	db, err := ndb.OpenReader(strings.NewReader(testDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(db)
+}
+
+func query(t *testing.T, name string, qtype uint16) []byte {
+	b := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	b, err := appendName(b, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = appendUint16(b, qtype)
+	return appendUint16(b, classINET)
+}
+
+// parseAnswers returns the rcode and answer records of a response.
+func parseAnswers(t *testing.T, b []byte) (int, []resource) {
+	h, _, err := parseQuery(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.id != 0x1234 || !h.response() {
+		t.Fatalf("bad response header %+v", h)
+	}
+	_, off, err := readName(b, headerLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off += 4
+	var answers []resource
+	for i := 0; i < int(binary.BigEndian.Uint16(b[6:])); i++ {
+		var rr resource
+		if rr.name, off, err = readName(b, off); err != nil {
+			t.Fatal(err)
+		}
+		rr.rtype = binary.BigEndian.Uint16(b[off:])
+		rr.ttl = binary.BigEndian.Uint32(b[off+4:])
+		n := int(binary.BigEndian.Uint16(b[off+8:]))
+		rr.data = b[off+10 : off+10+n]
+		off += 10 + n
+		answers = append(answers, rr)
+	}
+	return int(h.flags & 0xf), answers
+}
+
+func TestLookup(t *testing.T) {
+	s := testServer(t)
+	tests := []struct {
+		name  string
+		qtype uint16
+		rcode int
+		want  []string
+	}{
+		{"fir.example.com", typeA, rcodeSuccess, []string{"135.104.9.1"}},
+		{"FIR.example.com.", typeAAAA, rcodeSuccess, []string{"2001:db8::1"}},
+		{"oak.example.com", typeAAAA, rcodeSuccess, nil},
+		{"www.example.com", typeA, rcodeSuccess, []string{"fir.example.com", "135.104.9.1"}},
+		{"2.9.104.135.in-addr.arpa", typePTR, rcodeSuccess, []string{"oak.example.com"}},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", typePTR, rcodeSuccess, []string{"fir.example.com"}},
+		{"elm.example.com", typeA, rcodeNameError, nil},
+	}
+	for _, tt := range tests {
+		rcode, answers := parseAnswers(t, s.respond(query(t, tt.name, tt.qtype), maxUDPLen))
+		if rcode != tt.rcode {
+			t.Errorf("%s: got rcode %d, wanted %d", tt.name, rcode, tt.rcode)
+		}
+		var got []string
+		for _, rr := range answers {
+			switch rr.rtype {
+			case typeA, typeAAAA:
+				got = append(got, net.IP(rr.data).String())
+			case typeCNAME, typePTR:
+				name, _, _ := readName(rr.data, 0)
+				got = append(got, name)
+			}
+			if rr.ttl != DefaultTTL {
+				t.Errorf("%s: got ttl %d, wanted %d", tt.name, rr.ttl, DefaultTTL)
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: got %v, wanted %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServePacket(t *testing.T) {
+	s := testServer(t)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+	go s.ServePacket(pc)
+
+	c, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write(query(t, "oak.example.com", typeA)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxUDPLen)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, answers := parseAnswers(t, buf[:n])
+	if len(answers) != 1 || net.IP(answers[0].data).String() != "135.104.9.2" {
+		t.Errorf("got %v, wanted 135.104.9.2", answers)
+	}
+}
@@ -0,0 +1,39 @@
+package ndb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDNSRecords(t *testing.T) {
+	db, err := OpenReader(strings.NewReader(`sys=fir dom=fir.example.com ip=10.0.0.1 ip=2001:db8::1
+dom=www.example.com cname=fir.example.com
+dom=example.com
+	mx=mail.example.com pref=10
+	mx=backup.example.com. pref=20
+	mx=last.example.com
+sys=oak ip=10.0.0.2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwd := []DNSRecord{
+		{"fir.example.com.", "A", "10.0.0.1", 0},
+		{"fir.example.com.", "AAAA", "2001:db8::1", 0},
+		{"www.example.com.", "CNAME", "fir.example.com.", 0},
+		{"example.com.", "MX", "mail.example.com.", 10},
+		{"example.com.", "MX", "backup.example.com.", 20},
+		{"example.com.", "MX", "last.example.com.", 0},
+	}
+	if got := db.ForwardRecords(); fmt.Sprint(got) != fmt.Sprint(fwd) {
+		t.Errorf("Got %v, wanted %v", got, fwd)
+	}
+	rev := []DNSRecord{
+		{"1.0.0.10.in-addr.arpa.", "PTR", "fir.example.com.", 0},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", "PTR", "fir.example.com.", 0},
+	}
+	if got := db.ReverseRecords(); fmt.Sprint(got) != fmt.Sprint(rev) {
+		t.Errorf("Got %v, wanted %v", got, rev)
+	}
+}
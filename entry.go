@@ -0,0 +1,166 @@
+package ndb
+
+// A Pair is a single attr=value tuple.
+type Pair struct {
+	Attr, Val string
+}
+
+// An Entry is an ordered list of tuples that make up a single
+// logical ndb entry. The same attribute may appear more than once.
+// Ranging over an Entry visits its tuples in the order they appeared
+// in the input.
+type Entry []Pair
+
+// A RawEntry holds the verbatim text of an entry, including any
+// continuation lines. A struct field of type RawEntry or []byte tagged
+// `ndb:",raw"` receives the text of the entry it was decoded from.
+// Encoding a RawEntry writes it unmodified.
+type RawEntry []byte
+
+// MarshalNDB returns r.
+func (r RawEntry) MarshalNDB() ([]byte, error) {
+	return r, nil
+}
+
+func (p pair) export() Pair {
+	return Pair{Attr: string(p.attr), Val: string(p.val)}
+}
+
+func newEntry(pairs []pair) Entry {
+	e := make(Entry, len(pairs))
+	for i, p := range pairs {
+		e[i] = p.export()
+	}
+	return e
+}
+
+// ParseLine parses the tuples of a single entry held in line, such as
+// a message payload or command line argument, without a Decoder. The
+// entry may include continuation lines and comments, and a trailing
+// new line is ignored. If line has a syntax error, ParseLine returns a
+// *SyntaxError.
+func ParseLine(line []byte) ([]Pair, error) {
+	return AppendPairs(nil, line)
+}
+
+// AppendPairs is like ParseLine, but appends the tuples to dst and
+// returns the extended slice, so that a buffer can be reused. On
+// error, dst is returned unmodified.
+func AppendPairs(dst []Pair, line []byte) ([]Pair, error) {
+	var d Decoder
+	d.nocount = true
+	pairs, err := d.parseLine(line)
+	if err != nil {
+		return dst, err
+	}
+	for _, p := range pairs {
+		dst = append(dst, p.export())
+	}
+	return dst, nil
+}
+
+// Get returns the value of the first tuple in e with the given
+// attribute, or the empty string if there is none.
+func (e Entry) Get(attr string) string {
+	v, _ := e.first(attr)
+	return v
+}
+
+// GetAll returns the values of every tuple in e with the given
+// attribute, in order.
+func (e Entry) GetAll(attr string) []string {
+	var v []string
+	for _, p := range e {
+		if p.Attr == attr {
+			v = append(v, p.Val)
+		}
+	}
+	return v
+}
+
+// FindAttr searches e for a tuple with the given attribute, starting
+// at the tuple at index from and wrapping around to the start of e,
+// as Plan 9's ndbfindattr does. It returns the tuples of e rotated to
+// begin with the tuple found, so that the tuples following it come
+// next, or nil if there is none. An index out of range starts the
+// search at the first tuple.
+func (e Entry) FindAttr(attr string, from int) Entry {
+	if from < 0 || from >= len(e) {
+		from = 0
+	}
+	for i := range e {
+		j := (from + i) % len(e)
+		if e[j].Attr == attr {
+			found := make(Entry, 0, len(e))
+			found = append(found, e[j:]...)
+			return append(found, e[:j]...)
+		}
+	}
+	return nil
+}
+
+// Add appends the tuple attr=val to e.
+func (e *Entry) Add(attr, val string) {
+	*e = append(*e, Pair{attr, val})
+}
+
+// Del removes every tuple in e with the given attribute, preserving
+// the order of the remaining tuples.
+func (e *Entry) Del(attr string) {
+	keep := (*e)[:0]
+	for _, p := range *e {
+		if p.Attr != attr {
+			keep = append(keep, p)
+		}
+	}
+	*e = keep
+}
+
+func (e Entry) first(attr string) (string, bool) {
+	for _, p := range e {
+		if p.Attr == attr {
+			return p.Val, true
+		}
+	}
+	return "", false
+}
+
+// has reports whether e contains the tuple attr=val.
+func (e Entry) has(attr, val string) bool {
+	for _, p := range e {
+		if p.Attr == attr && p.Val == val {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of tuples in e with the given attribute.
+func (e Entry) Count(attr string) int {
+	n := 0
+	for _, p := range e {
+		if p.Attr == attr {
+			n++
+		}
+	}
+	return n
+}
+
+// HasMulti reports whether any attribute appears more than once in e.
+func (e Entry) HasMulti() bool {
+	return len(e.Multi()) > 0
+}
+
+// Multi returns the attributes that appear more than once in e,
+// in the order in which they are first repeated.
+func (e Entry) Multi() []string {
+	var multi []string
+	seen := make(map[string]int, len(e))
+	for _, p := range e {
+		seen[p.Attr]++
+		if seen[p.Attr] == 2 {
+			multi = append(multi, p.Attr)
+		}
+	}
+	return multi
+}
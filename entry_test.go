@@ -0,0 +1,401 @@
+package ndb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEntryMulti(t *testing.T) {
+	e := Entry{{"sys", "oak"}, {"ip", "10.0.0.1"}, {"ip", "10.0.0.2"}, {"dom", "oak"}}
+	if n := e.Count("ip"); n != 2 {
+		t.Errorf("Count(ip) = %d, wanted 2", n)
+	}
+	if n := e.Count("ether"); n != 0 {
+		t.Errorf("Count(ether) = %d, wanted 0", n)
+	}
+	if !e.HasMulti() {
+		t.Error("HasMulti() = false, wanted true")
+	}
+	if m := e.Multi(); fmt.Sprint(m) != "[ip]" {
+		t.Errorf("Multi() = %v, wanted [ip]", m)
+	}
+	if e[:2].HasMulti() {
+		t.Errorf("HasMulti() = true for %v", e[:2])
+	}
+}
+
+func TestEntryEdit(t *testing.T) {
+	var e Entry
+	e.Add("sys", "oak")
+	e.Add("ip", "10.0.0.1")
+	e.Add("dom", "oak.example.com")
+	e.Add("ip", "10.0.0.2")
+	if v := e.Get("ip"); v != "10.0.0.1" {
+		t.Errorf("Get(ip) = %q, wanted 10.0.0.1", v)
+	}
+	if v := e.Get("ether"); v != "" {
+		t.Errorf("Get(ether) = %q, wanted empty string", v)
+	}
+	if v := e.GetAll("ip"); fmt.Sprint(v) != "[10.0.0.1 10.0.0.2]" {
+		t.Errorf("GetAll(ip) = %v", v)
+	}
+	e.Del("ip")
+	if fmt.Sprint(e) != "[{sys oak} {dom oak.example.com}]" {
+		t.Errorf("Got %v after Del(ip)", e)
+	}
+}
+
+func TestDecodeEntry(t *testing.T) {
+	d := NewDecoder(strings.NewReader("sys=fir ip=10.0.0.1\nsys=oak\n"))
+	for _, want := range []string{"[{sys fir} {ip 10.0.0.1}]", "[{sys oak}]"} {
+		e, err := d.DecodeEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(e) != want {
+			t.Errorf("Got %v, wanted %s", e, want)
+		}
+	}
+	if _, err := d.DecodeEntry(); err != io.EOF {
+		t.Errorf("Got %v at end of input, wanted io.EOF", err)
+	}
+}
+
+func TestDecodeLines(t *testing.T) {
+	in := "sys=fir ip=10.0.0.1\r\n\tdom=fir.example.com\r\n\t# comment\r\n  ether=0080c74b2d1a bootf=/386/9pc\r\nsys=oak\n"
+	d := NewDecoder(strings.NewReader(in))
+	lines, err := d.DecodeLines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[[{sys fir} {ip 10.0.0.1}] [{dom fir.example.com}] [{ether 0080c74b2d1a} {bootf /386/9pc}]]"
+	if fmt.Sprint(lines) != want {
+		t.Errorf("Got %v, wanted %s", lines, want)
+	}
+	if lines, err = d.DecodeLines(); err != nil {
+		t.Fatal(err)
+	} else if fmt.Sprint(lines) != "[[{sys oak}]]" {
+		t.Errorf("Got %v, wanted [[{sys oak}]]", lines)
+	}
+}
+
+func TestLongLine(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	d := NewDecoder(strings.NewReader("sys=fir\n\tkey=" + long + "\nsys=oak"))
+	e, err := d.DecodeEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e) != 2 || e.Get("key") != long {
+		t.Errorf("Got %d tuples, with key of length %d", len(e), len(e.Get("key")))
+	}
+	if e, err = d.DecodeEntry(); err != nil || e.Get("sys") != "oak" {
+		t.Errorf("Got %v, %v; wanted sys=oak", e, err)
+	}
+}
+
+func TestDecoderEntries(t *testing.T) {
+	d := NewDecoder(strings.NewReader("sys=fir\nsys=oak\n\tip=10.0.0.3\n"))
+	var got []string
+	for e, err := range d.Entries() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprint(e))
+	}
+	want := []string{"[{sys fir}]", "[{sys oak} {ip 10.0.0.3}]"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Got %v, wanted %v", got, want)
+	}
+}
+
+func TestDecoderMore(t *testing.T) {
+	d := NewDecoder(strings.NewReader("# hosts\nsys=fir\n\n\tip=10.0.0.2\nsys=oak\n\n# end\n"))
+	var got []string
+	for d.More() {
+		if !d.More() {
+			t.Fatal("More changed its answer")
+		}
+		e, err := d.DecodeEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprint(e))
+	}
+	want := []string{"[{sys fir}]", "[{ip 10.0.0.2}]", "[{sys oak}]"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Got %v, wanted %v", got, want)
+	}
+	if _, err := d.DecodeEntry(); err != io.EOF {
+		t.Errorf("Got %v, wanted io.EOF", err)
+	}
+}
+
+func TestSkipEntry(t *testing.T) {
+	d := NewDecoder(strings.NewReader("sys=fir\n\tip='bad\nsys=oak\n"))
+	if err := d.SkipEntry(); err != nil {
+		t.Fatal(err)
+	}
+	e, err := d.DecodeEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Get("sys") != "oak" {
+		t.Errorf("Got %v, wanted sys=oak", e)
+	}
+	if err := d.SkipEntry(); err != io.EOF {
+		t.Errorf("Got %v, wanted io.EOF", err)
+	}
+}
+
+func TestSyntaxErrorPosition(t *testing.T) {
+	input := "# hosts\nsys=fir\n\nsys=oak\n# skipped\n\tip='10.0.0.3\n"
+	d := NewDecoder(strings.NewReader(input))
+	if _, err := d.DecodeEntry(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := d.DecodeEntry()
+	e, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("Got %v, wanted a *SyntaxError", err)
+	}
+	if e.Line != 6 {
+		t.Errorf("Got line %d, wanted 6", e.Line)
+	}
+	if want := int64(strings.Index(input, ".3\n") + 2); e.InputOffset != want {
+		t.Errorf("Got input offset %d, wanted %d", e.InputOffset, want)
+	}
+	if string(e.Data) != "sys=oak\n\tip='10.0.0.3" {
+		t.Errorf("Got data %q", e.Data)
+	}
+	d.DecodeEntry()
+	if string(e.Data) != "sys=oak\n\tip='10.0.0.3" {
+		t.Errorf("Data changed to %q after next read", e.Data)
+	}
+	if !strings.HasPrefix(e.Error(), "line 6: ") {
+		t.Errorf("Got %q, wanted line number in message", e.Error())
+	}
+}
+
+func TestSyntaxErrorKinds(t *testing.T) {
+	tests := []struct {
+		in   string
+		kind error
+	}{
+		{"=x", ErrBadAttribute},
+		{"sys='fir", ErrUnterminatedQuote},
+		{"sys=\xff", ErrInvalidUTF8},
+		{"sys='fir'ip=x", ErrMissingSpace},
+	}
+	for _, tt := range tests {
+		_, err := NewDecoder(strings.NewReader(tt.in)).DecodeEntry()
+		if !errors.Is(err, tt.kind) {
+			t.Errorf("%q: Got %v, wanted %v", tt.in, err, tt.kind)
+		}
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("%q: Got %T, wanted *SyntaxError", tt.in, err)
+		}
+	}
+	if _, err := AppendEntry(nil, Entry{{"a b", "c"}}); !errors.Is(err, ErrBadAttribute) {
+		t.Errorf("Got %v, wanted %v", err, ErrBadAttribute)
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	d := NewDecoder(strings.NewReader("sys=fir\nsys=oak\n"))
+	if !d.More() {
+		t.Fatal("More returned false")
+	}
+	d.Reset(strings.NewReader("sys=pine\n\tip='bad\n"))
+	e, err := d.DecodeEntry()
+	if err == nil {
+		t.Fatalf("Got %v, wanted syntax error", e)
+	}
+	if se, ok := err.(*SyntaxError); !ok || se.Line != 2 {
+		t.Errorf("Got %v, wanted error on line 2", err)
+	}
+	d.Reset(strings.NewReader("sys=elm"))
+	if e, err := d.DecodeEntry(); err != nil || e.Get("sys") != "elm" {
+		t.Errorf("Got %v, %v, wanted sys=elm", e, err)
+	}
+	if _, err := d.DecodeEntry(); err != io.EOF {
+		t.Errorf("Got %v, wanted io.EOF", err)
+	}
+}
+
+func TestDecoderSeek(t *testing.T) {
+	const input = "# hosts\nsys=fir\n\tip=10.0.0.1\n\nsys=oak\n# end\n"
+	d := NewDecoder(strings.NewReader(input))
+	var starts []int64
+	for d.More() {
+		if _, err := d.DecodeEntry(); err != nil {
+			t.Fatal(err)
+		}
+		start, end := d.EntryOffsets()
+		if end != d.InputOffset() {
+			t.Errorf("Got end %d, wanted InputOffset %d", end, d.InputOffset())
+		}
+		starts = append(starts, start)
+	}
+	if fmt.Sprint(starts) != "[8 30]" {
+		t.Errorf("Got starts %v, wanted [8 30]", starts)
+	}
+	if err := d.SeekEntry(30); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := d.DecodeEntry(); err != nil || e.Get("sys") != "oak" {
+		t.Errorf("Got %v, %v, wanted sys=oak", e, err)
+	}
+	if start, end := d.EntryOffsets(); start != 30 || end != 44 {
+		t.Errorf("Got offsets %d, %d, wanted 30, 44", start, end)
+	}
+	if err := d.SeekEntry(8); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := d.DecodeEntry(); err != nil || e.Get("ip") != "10.0.0.1" {
+		t.Errorf("Got %v, %v, wanted sys=fir", e, err)
+	}
+	d = NewDecoder(io.MultiReader(strings.NewReader(input)))
+	if err := d.SeekEntry(8); err == nil {
+		t.Error("SeekEntry succeeded on an io.Reader")
+	}
+}
+
+func TestDecodeFunc(t *testing.T) {
+	d := NewDecoder(strings.NewReader("sys=fir ip=10.0.0.2 ip=10.0.0.3\nsys=oak\n"))
+	var got []string
+	err := d.DecodeFunc(func(attr, val []byte) error {
+		got = append(got, string(attr)+"="+string(val))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[sys=fir ip=10.0.0.2 ip=10.0.0.3]"; fmt.Sprint(got) != want {
+		t.Errorf("Got %v, wanted %s", got, want)
+	}
+	stop := errors.New("stop")
+	n := 0
+	err = d.DecodeFunc(func(attr, val []byte) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("Got %v after %d calls, wanted stop after 1", err, n)
+	}
+	if err := d.DecodeFunc(nil); err != io.EOF {
+		t.Errorf("Got %v, wanted io.EOF", err)
+	}
+
+	d.Reset(strings.NewReader("sys=fir ip=10.0.0.2 ip=10.0.0.3 dom=fir.example.com\n"))
+	allocs := testing.AllocsPerRun(10, func() {
+		d.Reset(strings.NewReader("sys=fir ip=10.0.0.2 ip=10.0.0.3 dom=fir.example.com\n"))
+		d.DecodeFunc(func(attr, val []byte) error { return nil })
+	})
+	if allocs > 1 {
+		t.Errorf("Got %v allocations per DecodeFunc", allocs)
+	}
+}
+
+func TestDecoderLimits(t *testing.T) {
+	long := "sys=" + strings.Repeat("x", 8192)
+	comment := "# " + strings.Repeat("c", 8192)
+	input := comment + "\nsys=a\n" + long + "\n\tip=1\n#comment\n\tip=2\n" + comment + "\nsys=b\n"
+	d := NewDecoder(strings.NewReader(input))
+	d.SetMaxLineBytes(100)
+	var got []string
+	var errs []error
+	for {
+		e, err := d.DecodeEntry()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		got = append(got, e.Get("sys"))
+	}
+	if len(errs) != 1 || errs[0] != ErrLineTooLong {
+		t.Errorf("Got errors %v, wanted [%v]", errs, ErrLineTooLong)
+	}
+	if fmt.Sprint(got) != "[a b]" {
+		t.Errorf("Got %v, wanted [a b]", got)
+	}
+
+	d = NewDecoder(strings.NewReader("a=1 b=2 c=3\n"))
+	d.SetMaxTuples(2)
+	if _, err := d.DecodeEntry(); !errors.Is(err, ErrTooManyTuples) {
+		t.Errorf("Got %v, wanted %v", err, ErrTooManyTuples)
+	} else if e := err.(*SyntaxError); e.Offset != 8 {
+		t.Errorf("Got offset %d, wanted 8", e.Offset)
+	}
+
+	d = NewDecoder(strings.NewReader("a=1 b=12345\n"))
+	d.SetMaxValueBytes(4)
+	if _, err := d.DecodeEntry(); !errors.Is(err, ErrValueTooLong) {
+		t.Errorf("Got %v, wanted %v", err, ErrValueTooLong)
+	}
+}
+
+func TestEntryValues(t *testing.T) {
+	e := Entry{{"sys", "fir"}, {"ip", "10.0.0.1"}, {"ip", "10.0.0.2"}}
+	v := e.Values()
+	if v.Encode() != "ip=10.0.0.1&ip=10.0.0.2&sys=fir" {
+		t.Errorf("Got %s", v.Encode())
+	}
+	q, err := url.ParseQuery("sys=fir&ip=10.0.0.1&ip=10.0.0.2&dom=fir+example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := EntryFromValues(q)
+	want := Entry{{"dom", "fir example"}, {"ip", "10.0.0.1"}, {"ip", "10.0.0.2"}, {"sys", "fir"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Got %v, wanted %v", got, want)
+	}
+}
+
+func TestAppendPairs(t *testing.T) {
+	pairs, err := ParseLine([]byte("sys=fir dom='fir example' # comment\n\tip=10.0.0.1 trusted\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pair{{"sys", "fir"}, {"dom", "fir example"}, {"ip", "10.0.0.1"}, {"trusted", ""}}
+	if fmt.Sprint(pairs) != fmt.Sprint(want) {
+		t.Errorf("Got %v, wanted %v", pairs, want)
+	}
+
+	buf := []Pair{{"a", "1"}}
+	buf, err = AppendPairs(buf, []byte("b=2"))
+	if err != nil || len(buf) != 2 || buf[1] != (Pair{"b", "2"}) {
+		t.Errorf("Got %v, %v", buf, err)
+	}
+	buf, err = AppendPairs(buf, []byte("c='3"))
+	if !errors.Is(err, ErrUnterminatedQuote) || len(buf) != 2 {
+		t.Errorf("Got %v, %v, wanted unterminated quote", buf, err)
+	}
+}
+
+func TestFindAttr(t *testing.T) {
+	e := Entry{{"sys", "fir"}, {"ip", "10.0.0.1"}, {"dom", "fir"}, {"ip", "10.0.0.2"}}
+	tests := []struct {
+		attr string
+		from int
+		want string
+	}{
+		{"ip", 0, "[{ip 10.0.0.1} {dom fir} {ip 10.0.0.2} {sys fir}]"},
+		{"ip", 2, "[{ip 10.0.0.2} {sys fir} {ip 10.0.0.1} {dom fir}]"},
+		{"sys", 1, "[{sys fir} {ip 10.0.0.1} {dom fir} {ip 10.0.0.2}]"},
+		{"dom", 9, "[{dom fir} {ip 10.0.0.2} {sys fir} {ip 10.0.0.1}]"},
+		{"ether", 0, "[]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(e.FindAttr(tt.attr, tt.from)); got != tt.want {
+			t.Errorf("FindAttr(%q, %d): Got %s, wanted %s", tt.attr, tt.from, got, tt.want)
+		}
+	}
+}
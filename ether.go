@@ -0,0 +1,77 @@
+package ndb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+)
+
+// An EtherHost holds the addressing information recorded for a
+// host's Ethernet address.
+type EtherHost struct {
+	Ether net.HardwareAddr
+	Sys   string
+	IP    []net.IP
+	Entry Entry
+}
+
+// ParseEther parses an Ethernet address in either the Plan 9 form
+// of 12 hexadecimal digits, such as 0080c74b2d1a, or any of the
+// forms accepted by net.ParseMAC.
+func ParseEther(s string) (net.HardwareAddr, error) {
+	if len(s) == 12 {
+		if b, err := hex.DecodeString(s); err == nil {
+			return net.HardwareAddr(b), nil
+		}
+	}
+	return net.ParseMAC(s)
+}
+
+// FindByEther returns the first entry with an ether= attribute equal
+// to mac. Values that are not valid Ethernet addresses are ignored.
+func (db *Database) FindByEther(mac net.HardwareAddr) (Entry, bool) {
+	db.rlock()
+	defer db.mu.RUnlock()
+	return db.findByEther(mac)
+}
+
+func (db *Database) findByEther(mac net.HardwareAddr) (Entry, bool) {
+	for _, e := range db.entries {
+		for _, v := range e.GetAll("ether") {
+			if hw, err := ParseEther(v); err == nil && bytes.Equal(hw, mac) {
+				return e, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Ethers returns a table of every Ethernet address in the database,
+// keyed by the address in the form returned by net.HardwareAddr's
+// String method. When the same address appears in several entries,
+// the first is used.
+func (db *Database) Ethers() map[string]EtherHost {
+	db.rlock()
+	defer db.mu.RUnlock()
+	table := make(map[string]EtherHost)
+	for _, e := range db.entries {
+		for _, v := range e.GetAll("ether") {
+			hw, err := ParseEther(v)
+			if err != nil {
+				continue
+			}
+			if _, ok := table[hw.String()]; ok {
+				continue
+			}
+			host := EtherHost{Ether: hw, Entry: e}
+			host.Sys = e.Get("sys")
+			for _, s := range e.GetAll("ip") {
+				if ip := net.ParseIP(s); ip != nil {
+					host.IP = append(host.IP, ip)
+				}
+			}
+			table[hw.String()] = host
+		}
+	}
+	return table
+}
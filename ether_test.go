@@ -0,0 +1,34 @@
+package ndb
+
+import (
+	"strings"
+	"testing"
+)
+
+const testEthers = `sys=fir ether=0080c74b2d1a ip=10.0.0.1
+sys=oak ether=00:80:c7:4b:2d:1b ip=10.0.0.2 ip=10.0.1.2
+sys=elm ether=bogus
+`
+
+func TestFindByEther(t *testing.T) {
+	db, err := OpenReader(strings.NewReader(testEthers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, err := ParseEther("00-80-c7-4b-2d-1a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := db.FindByEther(mac); !ok || !e.has("sys", "fir") {
+		t.Errorf("FindByEther(%s) = %v, %v; wanted sys=fir", mac, e, ok)
+	}
+
+	table := db.Ethers()
+	if len(table) != 2 {
+		t.Errorf("Got %d ethers, wanted 2: %v", len(table), table)
+	}
+	host := table["00:80:c7:4b:2d:1b"]
+	if host.Sys != "oak" || len(host.IP) != 2 || host.IP[1].String() != "10.0.1.2" {
+		t.Errorf("Got %+v for 00:80:c7:4b:2d:1b, wanted sys=oak", host)
+	}
+}
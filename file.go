@@ -0,0 +1,330 @@
+package ndb
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// A File is a lossless parse of ndb text, for tools that edit
+// configuration files by hand-written rules. Unlike a Database, a
+// File keeps the comments, blank lines, white space and quoting of
+// its input, and writing a File reproduces every entry and tuple that
+// was not changed byte for byte.
+type File struct {
+	Entries []*EntryNode
+	Trailer []byte // blank lines and comments following the last entry
+}
+
+// An EntryNode is an entry in a File.
+type EntryNode struct {
+	Leading  []byte // blank lines and comments preceding the entry
+	Tuples   []*TupleNode
+	Trailing []byte // text after the last tuple, including the new line
+}
+
+// A TupleNode is a tuple in an EntryNode. The Attr and Val fields
+// may be modified; a changed tuple is written in the form chosen by
+// AppendEntry.
+type TupleNode struct {
+	Space []byte // text preceding the tuple within its entry
+	Attr  string
+	Val   string
+
+	raw     []byte // text of the tuple in the input
+	rawAttr string // Attr and Val as parsed from raw
+	rawVal  string
+}
+
+// ParseFile reads and parses the ndb file at path.
+func ParseFile(path string) (*File, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(src)
+}
+
+// Parse parses the ndb text src into a File. The File refers to src,
+// which must not be modified while the File is in use. If src has a
+// syntax error, Parse returns a *SyntaxError locating it.
+func Parse(src []byte) (*File, error) {
+	var d Decoder
+	d.nocount = true
+
+	f := new(File)
+	lead, lineno := 0, 1
+	for pos := 0; pos < len(src); {
+		end := physLineEnd(src, pos)
+		if isBlank(src[pos:end]) {
+			pos = end
+			continue
+		}
+		start := pos
+		for pos = end; pos < len(src); {
+			// Comment lines are part of the entry only if a
+			// continuation line follows them.
+			next := pos
+			for next < len(src) && src[next] == '#' {
+				next = physLineEnd(src, next)
+			}
+			if next == len(src) || src[next] != ' ' && src[next] != '\t' {
+				break
+			}
+			pos = physLineEnd(src, next)
+		}
+		lineno += bytes.Count(src[lead:start], []byte{'\n'})
+		text := src[start:pos:pos]
+		e, err := parseEntryNode(&d, text)
+		if err != nil {
+			if serr, ok := err.(*SyntaxError); ok {
+				serr.Line = lineno + bytes.Count(text[:serr.Offset], []byte{'\n'})
+				serr.Column = int(serr.Offset) - bytes.LastIndexByte(text[:serr.Offset], '\n')
+				serr.InputOffset = int64(start) + serr.Offset
+			}
+			return nil, err
+		}
+		e.Leading = src[lead:start:start]
+		f.Entries = append(f.Entries, e)
+		lineno += bytes.Count(text, []byte{'\n'})
+		lead = pos
+	}
+	f.Trailer = src[lead:]
+	return f, nil
+}
+
+// physLineEnd returns the offset following the physical line of src
+// that begins at pos.
+func physLineEnd(src []byte, pos int) int {
+	if i := bytes.IndexByte(src[pos:], '\n'); i != -1 {
+		return pos + i + 1
+	}
+	return len(src)
+}
+
+// parseEntryNode parses the text of a single entry, including its
+// continuation lines and final new line.
+func parseEntryNode(d *Decoder, text []byte) (*EntryNode, error) {
+	d.pairbuf = d.pairbuf[:0]
+	pairs, err := d.parseLine(text)
+	if err != nil {
+		return nil, err
+	}
+	e := &EntryNode{Tuples: make([]*TupleNode, len(pairs))}
+	prev := 0
+	for i, p := range pairs {
+		// p.attr is a subslice of text
+		start := cap(text) - cap(p.attr)
+		end := tupleEnd(text, start+len(p.attr))
+		attr, val := string(p.attr), string(p.val)
+		e.Tuples[i] = &TupleNode{
+			Space:   text[prev:start:start],
+			Attr:    attr,
+			Val:     val,
+			raw:     text[start:end:end],
+			rawAttr: attr,
+			rawVal:  val,
+		}
+		prev = end
+	}
+	e.Trailing = text[prev:]
+	return e, nil
+}
+
+// tupleEnd returns the offset following the value of the tuple
+// whose attribute ends at i, following the quoting rules of
+// parseLine.
+func tupleEnd(text []byte, i int) int {
+	if i == len(text) || text[i] != '=' {
+		return i
+	}
+	i++
+	if i < len(text) && text[i] == '\'' && (i+1 == len(text) || text[i+1] != '\'') {
+		for i++; i < len(text); i++ {
+			if text[i] == '\'' {
+				if i+1 < len(text) && text[i+1] == '\'' {
+					i++
+					continue
+				}
+				return i + 1
+			}
+		}
+		return i
+	}
+	for i < len(text) && !isSpace(rune(text[i])) {
+		i++
+	}
+	return i
+}
+
+// WriteTo writes the text of f to w. Entries and tuples that were not
+// changed since f was parsed are written as they appeared in the
+// input. WriteTo returns an error, and writes nothing, if a changed
+// tuple has an invalid attribute or value.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	buf, err := f.appendText(nil)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+func (f *File) appendText(dst []byte) ([]byte, error) {
+	var err error
+	for _, e := range f.Entries {
+		if n := len(dst); n > 0 && dst[n-1] != '\n' {
+			dst = append(dst, '\n')
+		}
+		dst = append(dst, e.Leading...)
+		if dst, err = e.appendText(dst); err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, f.Trailer...), nil
+}
+
+func (e *EntryNode) appendText(dst []byte) ([]byte, error) {
+	for i, t := range e.Tuples {
+		if t.Space != nil {
+			dst = append(dst, t.Space...)
+		} else if i > 0 {
+			dst = append(dst, ' ')
+		}
+		if t.raw != nil && t.Attr == t.rawAttr && t.Val == t.rawVal {
+			dst = append(dst, t.raw...)
+			continue
+		}
+		var err error
+		if dst, err = AppendEntry(dst, Entry{{t.Attr, t.Val}}); err != nil {
+			return nil, err
+		}
+	}
+	if e.Trailing == nil {
+		return append(dst, '\n'), nil
+	}
+	return append(dst, e.Trailing...), nil
+}
+
+// Entry returns the tuples of e.
+func (e *EntryNode) Entry() Entry {
+	entry := make(Entry, len(e.Tuples))
+	for i, t := range e.Tuples {
+		entry[i] = Pair{t.Attr, t.Val}
+	}
+	return entry
+}
+
+// Set sets the value of the first tuple in e with the given attribute
+// to val, or adds the tuple attr=val to the end of e if there is none.
+func (e *EntryNode) Set(attr, val string) {
+	for _, t := range e.Tuples {
+		if t.Attr == attr {
+			t.Val = val
+			return
+		}
+	}
+	e.Add(attr, val)
+}
+
+// Add appends the tuple attr=val to e, on the entry's last line.
+func (e *EntryNode) Add(attr, val string) {
+	e.Tuples = append(e.Tuples, &TupleNode{Attr: attr, Val: val})
+}
+
+// Del removes every tuple in e with the given attribute. A removed
+// tuple that began a line passes the text preceding it on to the
+// tuple following it, so that the remaining tuples keep their lines.
+func (e *EntryNode) Del(attr string) {
+	keep := e.Tuples[:0]
+	var space []byte
+	for i, t := range e.Tuples {
+		begins := i == 0 || bytes.IndexByte(t.Space, '\n') != -1
+		if t.Attr == attr {
+			if begins && space == nil {
+				space = t.Space
+			}
+			continue
+		}
+		if space != nil && !begins {
+			t.Space = space
+		}
+		space = nil
+		keep = append(keep, t)
+	}
+	e.Tuples = keep
+}
+
+// A PatchOp is the kind of edit made by a Patch.
+type PatchOp int
+
+const (
+	// SetValue sets the value of the first tuple with the
+	// attribute Attr to Val, adding the tuple if there is none.
+	SetValue PatchOp = iota
+	// AddTuple adds the tuple Attr=Val to the end of the entry.
+	AddTuple
+	// DeleteEntry removes the entry.
+	DeleteEntry
+)
+
+// A Patch is an edit to every entry in a File that contains the
+// tuple Match.Attr=Match.Val.
+type Patch struct {
+	Op        PatchOp
+	Match     Pair
+	Attr, Val string
+}
+
+// Apply makes the edits ps to f, in order, and returns the number of
+// entries changed. Only the tuples that are set or added, and the
+// lines of deleted entries, are rewritten when f is written; the
+// comments and blank lines preceding a deleted entry are kept.
+func (f *File) Apply(ps ...Patch) int {
+	var n int
+	for _, p := range ps {
+		keep := f.Entries[:0]
+		var lead []byte
+		for _, e := range f.Entries {
+			if lead != nil {
+				e.Leading = append(lead, e.Leading...)
+				lead = nil
+			}
+			if !e.has(p.Match) {
+				keep = append(keep, e)
+				continue
+			}
+			switch p.Op {
+			case SetValue:
+				if v, ok := e.Entry().first(p.Attr); ok && v == p.Val {
+					break
+				}
+				e.Set(p.Attr, p.Val)
+				n++
+			case AddTuple:
+				e.Add(p.Attr, p.Val)
+				n++
+			case DeleteEntry:
+				lead = append([]byte(nil), e.Leading...)
+				n++
+				continue
+			}
+			keep = append(keep, e)
+		}
+		if lead != nil {
+			f.Trailer = append(lead, f.Trailer...)
+		}
+		clear(f.Entries[len(keep):])
+		f.Entries = keep
+	}
+	return n
+}
+
+func (e *EntryNode) has(p Pair) bool {
+	for _, t := range e.Tuples {
+		if t.Attr == p.Attr && t.Val == p.Val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package ndb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+const testFile = `# local hosts
+database=
+	file=/lib/ndb/local
+
+sys=fir ip=135.104.9.1   # the file server
+	dom='fir example'	bootf=/386/9pxeload
+# between lines
+	ether=0011aabbccdd
+
+sys=oak ip=135.104.9.2
+# trailing comment
+`
+
+func writeFile(t *testing.T, f *File) string {
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	for _, src := range []string{testFile, "", "\n\n", "sys=fir", "a b=''x c='it''s' d=''\r\n\te=1\r\n"} {
+		f, err := Parse([]byte(src))
+		if err != nil {
+			t.Errorf("Parse(%q): %v", src, err)
+			continue
+		}
+		if got := writeFile(t, f); got != src {
+			t.Errorf("Got %q, wanted %q", got, src)
+		}
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	f, err := Parse([]byte(testFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Entries) != 3 {
+		t.Fatalf("Got %d entries, wanted 3", len(f.Entries))
+	}
+	e := f.Entries[1].Entry()
+	if e.Get("dom") != "fir example" || e.Get("ether") != "0011aabbccdd" {
+		t.Errorf("Got %v", e)
+	}
+	if string(f.Entries[2].Leading) != "\n" || string(f.Trailer) != "# trailing comment\n" {
+		t.Errorf("Got leading %q and trailer %q", f.Entries[2].Leading, f.Trailer)
+	}
+}
+
+func TestFileEdit(t *testing.T) {
+	f, err := Parse([]byte(testFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fir := f.Entries[1]
+	fir.Set("ip", "135.104.9.10")
+	fir.Set("dom", "fir")
+	fir.Del("bootf")
+	fir.Add("proto", "il tcp")
+	f.Entries[2].Del("sys")
+	f.Entries = append(f.Entries, new(EntryNode))
+	f.Entries[3].Add("sys", "elm")
+
+	want := `# local hosts
+database=
+	file=/lib/ndb/local
+
+sys=fir ip=135.104.9.10   # the file server
+	dom=fir
+# between lines
+	ether=0011aabbccdd proto='il tcp'
+
+ip=135.104.9.2
+sys=elm
+# trailing comment
+`
+	if got := writeFile(t, f); got != want {
+		t.Errorf("Got\n%s\nwanted\n%s", got, want)
+	}
+
+	fir.Set("dom", "a\nb")
+	if _, err := f.WriteTo(new(bytes.Buffer)); err == nil {
+		t.Error("Got nil, wanted error for invalid value")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := Parse([]byte("sys=fir\n\nsys=oak\n\tdom='oak\n"))
+	var serr *SyntaxError
+	if !errors.As(err, &serr) || !errors.Is(err, ErrUnterminatedQuote) {
+		t.Fatalf("Got %v, wanted unterminated quote", err)
+	}
+	if serr.Line != 4 {
+		t.Errorf("Got line %d, wanted 4", serr.Line)
+	}
+}
+
+func TestFileApply(t *testing.T) {
+	f, err := Parse([]byte(testFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := f.Apply(
+		Patch{Op: SetValue, Match: Pair{"sys", "fir"}, Attr: "ip", Val: "135.104.9.1"},
+		Patch{Op: SetValue, Match: Pair{"sys", "fir"}, Attr: "ether", Val: "0011aabbccee"},
+		Patch{Op: AddTuple, Match: Pair{"sys", "fir"}, Attr: "ip", Val: "135.104.9.11"},
+		Patch{Op: DeleteEntry, Match: Pair{"sys", "oak"}},
+		Patch{Op: DeleteEntry, Match: Pair{"sys", "elm"}},
+	)
+	if n != 3 {
+		t.Errorf("Got %d entries changed, wanted 3", n)
+	}
+	want := `# local hosts
+database=
+	file=/lib/ndb/local
+
+sys=fir ip=135.104.9.1   # the file server
+	dom='fir example'	bootf=/386/9pxeload
+# between lines
+	ether=0011aabbccee ip=135.104.9.11
+
+# trailing comment
+`
+	if got := writeFile(t, f); got != want {
+		t.Errorf("Got\n%s\nwanted\n%s", got, want)
+	}
+}
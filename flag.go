@@ -0,0 +1,29 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import "flag"
+
+// SetFlags sets the flags in fs named by the attributes of e to their
+// values, so that an ndb entry can provide the defaults for a
+// program's command line. Flags that were already set, such as by a
+// call to fs.Parse, are left alone, letting the command line override
+// the entry. Attributes that do not name a flag are ignored. A
+// repeated attribute sets its flag once for each value, in order.
+// SetFlags returns a *FieldError for the first value rejected by its
+// flag.
+func SetFlags(fs *flag.FlagSet, e Entry) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, p := range e {
+		if set[p.Attr] || fs.Lookup(p.Attr) == nil {
+			continue
+		}
+		if err := fs.Set(p.Attr, p.Val); err != nil {
+			return &FieldError{Attr: p.Attr, Value: p.Val, Err: err}
+		}
+	}
+	return nil
+}
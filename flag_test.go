@@ -0,0 +1,51 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+type flagList []string
+
+func (l *flagList) String() string     { return strings.Join(*l, ",") }
+func (l *flagList) Set(s string) error { *l = append(*l, s); return nil }
+
+func TestSetFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	addr := fs.String("addr", ":80", "")
+	verbose := fs.Bool("v", false, "")
+	timeout := fs.Duration("timeout", time.Second, "")
+	var peers flagList
+	fs.Var(&peers, "peer", "")
+
+	if err := fs.Parse([]string{"-addr", ":8080"}); err != nil {
+		t.Fatal(err)
+	}
+	e := Entry{{"addr", ":9090"}, {"v", "true"}, {"timeout", "5s"},
+		{"peer", "a"}, {"peer", "b"}, {"unknown", "x"}}
+	if err := SetFlags(fs, e); err != nil {
+		t.Fatal(err)
+	}
+	if *addr != ":8080" {
+		t.Errorf("Got addr %q, wanted command line value :8080", *addr)
+	}
+	if !*verbose || *timeout != 5*time.Second {
+		t.Errorf("Got v=%v timeout=%v, wanted true 5s", *verbose, *timeout)
+	}
+	if peers.String() != "a,b" {
+		t.Errorf("Got peers %v, wanted [a b]", peers)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Duration("timeout", time.Second, "")
+	err := SetFlags(fs, Entry{{"timeout", "soon"}})
+	var ferr *FieldError
+	if !errors.As(err, &ferr) || ferr.Attr != "timeout" {
+		t.Errorf("Got %v, wanted *FieldError for timeout", err)
+	}
+}
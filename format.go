@@ -0,0 +1,214 @@
+package ndb
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// AppendEntry appends the ndb encoding of e to dst and returns the
+// extended buffer. No trailing newline is added. Unlike Marshal,
+// AppendEntry does not use reflection, and is available in builds
+// using the ndbnoreflect tag.
+func AppendEntry(dst []byte, e Entry) ([]byte, error) {
+	for i, p := range e {
+		if !validAttr([]byte(p.Attr)) {
+			return dst, &SyntaxError{Message: "Invalid attribute " + p.Attr, Err: ErrBadAttribute}
+		}
+		if !validVal([]byte(p.Val)) {
+			return dst, &SyntaxError{Message: "Invalid value " + p.Val}
+		}
+		if i > 0 {
+			dst = append(dst, ' ')
+		}
+		dst = appendTuple(dst, p.Attr, p.Val)
+	}
+	return dst, nil
+}
+
+// appendTuple appends attr=val to dst, quoting val if necessary.
+// The attribute and value must already be valid.
+func appendTuple(dst []byte, attr, val string) []byte {
+	dst = append(dst, attr...)
+	dst = append(dst, '=')
+	return AppendQuote(dst, val)
+}
+
+// Quote returns val in the form it takes as the value of a tuple,
+// quoted and with its single quotes doubled as needed, so that it
+// decodes to val. Quote does not check that val is valid; a value may
+// not contain a new line, and a value that begins with a single quote
+// and contains white space cannot be represented.
+func Quote(val string) string {
+	return string(AppendQuote(nil, val))
+}
+
+// AppendQuote appends val, as quoted by Quote, to dst and returns the
+// extended buffer.
+func AppendQuote(dst []byte, val string) []byte {
+	space := strings.IndexFunc(val, unicode.IsSpace) != -1
+	quote := strings.IndexByte(val, '\'') != -1
+	switch {
+	case !space && !quote:
+		return append(dst, val...)
+	case !space && val[0] == '\'':
+		// A value beginning with a doubled quote is unquoted
+		// and has its doubled quotes undone.
+		return appendDoubled(dst, val)
+	}
+	dst = append(dst, '\'')
+	dst = appendDoubled(dst, val)
+	return append(dst, '\'')
+}
+
+func appendDoubled(dst []byte, val string) []byte {
+	for i := 0; i < len(val); i++ {
+		if val[i] == '\'' {
+			dst = append(dst, '\'')
+		}
+		dst = append(dst, val[i])
+	}
+	return dst
+}
+
+// Unquote interprets s as the value of a tuple, as it would appear
+// after the '=', and returns the value it decodes to. If s is not a
+// single valid value, Unquote returns a *SyntaxError.
+func Unquote(s string) (string, error) {
+	text := []byte("v=" + s)
+	var d Decoder
+	d.nocount = true
+	pairs, err := d.parseLine(text)
+	if e, ok := err.(*SyntaxError); ok {
+		e.Data, e.Offset = text[2:], e.Offset-2
+		return "", e
+	} else if err != nil {
+		return "", err
+	}
+	if end := tupleEnd(text, 1); len(pairs) != 1 || end != len(text) {
+		return "", &SyntaxError{Data: text[2:], Offset: int64(end - 2), Message: "Invalid value " + s}
+	}
+	return string(pairs[0].val), nil
+}
+
+// validAttr reports whether attr is an attribute the Decoder accepts
+// by default: printable characters other than '=' and white space,
+// not beginning a comment.
+func validAttr(attr []byte) bool {
+	if !utf8.Valid(attr) || len(attr) > 0 && attr[0] == '#' {
+		return false
+	}
+	x := bytes.IndexFunc(attr, func(r rune) bool {
+		return r == '=' || unicode.IsSpace(r) || !unicode.IsPrint(r)
+	})
+	return x == -1
+}
+
+// validVal reports whether val is valid UTF-8 that can be written as
+// a value: it holds no new line, and, as Quote cannot represent it,
+// does not both begin with a single quote and contain white space.
+func validVal(val []byte) bool {
+	if !utf8.Valid(val) || bytes.IndexByte(val, '\n') != -1 {
+		return false
+	}
+	return len(val) == 0 || val[0] != '\'' || bytes.IndexFunc(val, unicode.IsSpace) == -1
+}
+
+// validEntry reports whether entry is valid UTF-8 in which every new
+// line starts a continuation line.
+func validEntry(entry []byte) bool {
+	if !utf8.Valid(entry) {
+		return false
+	}
+	for i, c := range entry {
+		if c == '\n' && (i+1 == len(entry) || entry[i+1] != ' ' && entry[i+1] != '\t') {
+			return false
+		}
+	}
+	return true
+}
+
+// Format returns the canonical formatting of the ndb text src, as a
+// gofmt for ndb files. Tuples are separated by a single space and
+// values are quoted only where necessary. Continuation lines are
+// indented by a single tab, and keep the tuples they held. Comments
+// are kept, with surrounding white space removed; a comment at the
+// end of a line follows its last tuple after a single space, and a
+// comment line within an entry is indented like a continuation line.
+// Runs of blank lines between entries are reduced to one, and blank
+// lines at the start and end of src are removed. If src has a syntax
+// error, Format returns a *SyntaxError.
+func Format(src []byte) ([]byte, error) {
+	f, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	var dst []byte
+	for _, e := range f.Entries {
+		dst = appendBetween(dst, e.Leading)
+		for i, t := range e.Tuples {
+			if i > 0 {
+				dst = appendSpace(dst, t.Space, false)
+			}
+			dst = append(dst, t.Attr...)
+			if bytes.IndexByte(t.raw, '=') != -1 {
+				dst = append(dst, '=')
+				dst = AppendQuote(dst, t.Val)
+			}
+		}
+		dst = appendSpace(dst, e.Trailing, true)
+	}
+	dst = appendBetween(dst, f.Trailer)
+	for bytes.HasSuffix(dst, []byte("\n\n")) {
+		dst = dst[:len(dst)-1]
+	}
+	return dst, nil
+}
+
+// appendSpace appends the canonical form of space, the text between
+// two tuples of an entry or, if end is set, following its last tuple.
+func appendSpace(dst, space []byte, end bool) []byte {
+	lines := bytes.Split(space, []byte{'\n'})
+	if len(lines) == 1 && !end {
+		return append(dst, ' ')
+	}
+	if c := bytes.TrimSpace(lines[0]); len(c) > 0 {
+		dst = append(dst, ' ')
+		dst = append(dst, c...)
+	}
+	// The last entry of src may end without a new line
+	for _, line := range lines[1:max(len(lines)-1, 1)] {
+		if c := bytes.TrimSpace(line); len(c) > 0 {
+			dst = append(dst, "\n\t"...)
+			dst = append(dst, c...)
+		}
+	}
+	if end {
+		return append(dst, '\n')
+	}
+	return append(dst, "\n\t"...)
+}
+
+// appendBetween appends the canonical form of text, the blank lines
+// and comments between entries.
+func appendBetween(dst, text []byte) []byte {
+	blank := false
+	for _, line := range bytes.SplitAfter(text, []byte{'\n'}) {
+		c := bytes.TrimSpace(line)
+		if len(c) == 0 {
+			blank = blank || len(line) > 0
+			continue
+		}
+		if blank && len(dst) > 0 {
+			dst = append(dst, '\n')
+		}
+		blank = false
+		dst = append(dst, c...)
+		dst = append(dst, '\n')
+	}
+	if blank && len(dst) > 0 {
+		dst = append(dst, '\n')
+	}
+	return dst
+}
@@ -0,0 +1,137 @@
+package ndb
+
+import "testing"
+
+var appendEntryTests = []struct {
+	in  Entry
+	out string
+}{
+	{
+		Entry{{"sys", "fir"}, {"ip", "10.0.0.1"}},
+		"sys=fir ip=10.0.0.1",
+	},
+	{
+		Entry{{"title", "Dave's pasta"}, {"cost", "$$"}},
+		"title='Dave''s pasta' cost=$$",
+	},
+	{
+		Entry{{"key", ""}, {"esc", "can't"}},
+		"key= esc='can''t'",
+	},
+}
+
+func TestAppendEntry(t *testing.T) {
+	for _, tt := range appendEntryTests {
+		b, err := AppendEntry([]byte("prefix "), tt.in)
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != "prefix "+tt.out {
+			t.Errorf("Wanted %s, got %s", "prefix "+tt.out, b)
+		}
+	}
+	if _, err := AppendEntry(nil, Entry{{"bad attr", "x"}}); err == nil {
+		t.Error("AppendEntry accepted an attribute with white space")
+	}
+	if _, err := AppendEntry(nil, Entry{{"attr", "a\nb"}}); err == nil {
+		t.Error("AppendEntry accepted a value with a new line")
+	}
+	if _, err := AppendEntry(nil, Entry{{"attr", "'a b"}}); err == nil {
+		t.Error("AppendEntry accepted a value it cannot quote")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	src := `
+
+# local hosts
+
+
+sys=fir   ip=135.104.9.1	# the file server   
+    dom='fir example'  bootf='/386/9pxeload'
+# between lines
+  ether=0011aabbccdd
+	# the end of fir
+sys=oak trusted proto=''il''
+
+
+  # trailing comment  
+
+`
+	want := `# local hosts
+
+sys=fir ip=135.104.9.1 # the file server
+	dom='fir example' bootf=/386/9pxeload
+	# between lines
+	ether=0011aabbccdd
+	# the end of fir
+sys=oak trusted proto=''il''
+
+# trailing comment
+`
+	got, err := Format([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("Got\n%s\nwanted\n%s", got, want)
+	}
+	again, err := Format(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(got) {
+		t.Errorf("Format is not idempotent:\n%s", again)
+	}
+	if _, err := Format([]byte("sys='fir")); err == nil {
+		t.Error("Got nil, wanted syntax error")
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, src := range []string{
+		"sys='it''s'",
+		"sys=''fir",
+		"sys='''' dom='a '' b'",
+		"eq=a=b expr='x = y'",
+		"tag=#1 note='# not a comment' # a comment",
+		"key= empty='' bare",
+		"sys=fir\n\tnote='can''t boot' key=\n",
+	} {
+		out, err := Format([]byte(src))
+		if err != nil {
+			t.Errorf("Format(%q): %v", src, err)
+			continue
+		}
+		if eq, err := Equal([]byte(src), out); err != nil || !eq {
+			t.Errorf("Format(%q) = %q, which holds different tuples (%v)", src, out, err)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, tt := range []struct{ val, quoted string }{
+		{"fir", "fir"},
+		{"", ""},
+		{"fir example", "'fir example'"},
+		{"can't", "'can''t'"},
+		{"'bradley", "''bradley"},
+		{"'", "''"},
+		{"it's a 'test'", "'it''s a ''test'''"},
+	} {
+		if got := Quote(tt.val); got != tt.quoted {
+			t.Errorf("Quote(%q): Got %q, wanted %q", tt.val, got, tt.quoted)
+		}
+		got, err := Unquote(tt.quoted)
+		if err != nil || got != tt.val {
+			t.Errorf("Unquote(%q): Got %q, %v, wanted %q", tt.quoted, got, err, tt.val)
+		}
+	}
+	if b := AppendQuote([]byte("dom="), "a b"); string(b) != "dom='a b'" {
+		t.Errorf("Got %s, wanted dom='a b'", b)
+	}
+	for _, s := range []string{"'fir", "a b", "a #c", "'a'b"} {
+		if _, err := Unquote(s); err == nil {
+			t.Errorf("Unquote(%q): Got nil, wanted error", s)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"bytes"
+	"io"
+	"iter"
+	"reflect"
+)
+
+// UnmarshalAs decodes the first entry in data into a new value of
+// type T, following the rules of Unmarshal.
+func UnmarshalAs[T any](data []byte) (T, error) {
+	var v T
+	err := Unmarshal(data, &v)
+	return v, err
+}
+
+// UnmarshalMap decodes every entry in data into a value of type T,
+// following the rules of Unmarshal, and returns them indexed by their
+// values for keyAttr, which is typically the attribute of one of T's
+// fields. An entry with several values for keyAttr is indexed under
+// each; entries without keyAttr are skipped. If two entries have the
+// same value for keyAttr, UnmarshalMap returns an error.
+func UnmarshalMap[T any](data []byte, keyAttr string) (map[string]T, error) {
+	m := make(map[string]T)
+	d := NewDecoder(bytes.NewReader(data))
+	for {
+		p, err := d.getPairs()
+		if err == io.EOF {
+			return m, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var v T
+		if err := d.decodeEntry(p, reflect.ValueOf(&v)); err != nil {
+			return nil, err
+		}
+		added := make(map[string]bool)
+		for _, t := range p {
+			if string(t.attr) != keyAttr {
+				continue
+			}
+			key := string(t.val)
+			if _, ok := m[key]; ok && !added[key] {
+				return nil, errDuplicateKey(keyAttr, key)
+			}
+			m[key] = v
+			added[key] = true
+		}
+	}
+}
+
+// MarshalValues encodes each element of vs as an ndb entry, following
+// the rules of Marshal. Entries are separated by new lines.
+func MarshalValues[T any](vs []T) ([]byte, error) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for i := range vs {
+		if err := e.Encode(&vs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Lines returns an iterator that decodes each entry read from r into
+// a value of type T, following the rules of Unmarshal. Iteration stops
+// at the end of the input, or after yielding the first error.
+func Lines[T any](r io.Reader) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		d := NewDecoder(r)
+		for {
+			var v T
+			err := d.Decode(&v)
+			if err == io.EOF {
+				return
+			}
+			if !yield(v, err) || err != nil {
+				return
+			}
+		}
+	}
+}
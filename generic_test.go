@@ -0,0 +1,81 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalAs(t *testing.T) {
+	h, err := UnmarshalAs[iface]([]byte("ether=0011aabbccdd ip=10.0.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (iface{"0011aabbccdd", "10.0.0.2"}); h != want {
+		t.Errorf("Got %+v, wanted %+v", h, want)
+	}
+	m, err := UnmarshalAs[map[string]string]([]byte("sys=fir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[string]string{"sys": "fir"}) {
+		t.Errorf("Got %v", m)
+	}
+}
+
+func TestMarshalValues(t *testing.T) {
+	vs := []iface{{"0011aabbccdd", "10.0.0.2"}, {"0011aabbccde", "10.0.0.3"}}
+	b, err := MarshalValues(vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ether=0011aabbccdd ip=10.0.0.2\nether=0011aabbccde ip=10.0.0.3"
+	if string(b) != want {
+		t.Errorf("Wanted %q, got %q", want, b)
+	}
+}
+
+func TestLines(t *testing.T) {
+	data := "ether=0011aabbccdd ip=10.0.0.2\n\nether=0011aabbccde ip=10.0.0.3\n"
+	var got []iface
+	for v, err := range Lines[iface](strings.NewReader(data)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	want := []iface{{"0011aabbccdd", "10.0.0.2"}, {"0011aabbccde", "10.0.0.3"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v, wanted %+v", got, want)
+	}
+
+	var errs int
+	for _, err := range Lines[iface](strings.NewReader("ip='unterminated\nip=x")) {
+		if err == nil {
+			t.Error("Got nil, wanted syntax error")
+		}
+		errs++
+	}
+	if errs != 1 {
+		t.Errorf("Got %d errors, wanted iteration to stop after 1", errs)
+	}
+}
+
+func TestUnmarshalMap(t *testing.T) {
+	type host struct {
+		Sys string   `ndb:"sys"`
+		IP  []string `ndb:"ip"`
+	}
+	m, err := UnmarshalMap[host]([]byte(testDB), "sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 || !reflect.DeepEqual(m["oak"].IP, []string{"135.104.9.2", "135.104.9.3"}) {
+		t.Errorf("Got %+v", m)
+	}
+	if _, err := UnmarshalMap[host]([]byte("sys=fir\nsys=fir\n"), "sys"); err == nil {
+		t.Error("Got nil, wanted error for duplicate key")
+	}
+}
@@ -0,0 +1,215 @@
+package ndb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// Hash files are laid out as in Plan 9's ndb/mkhash: an 8 byte header
+// holding the modification time of the database and the number of
+// slots in the table, both little-endian, followed by the table of
+// 3 byte pointers. A pointer either holds the offset of an entry in
+// the database, or, with the chain bit set, the offset within the
+// table of a pair of pointers continuing the chain.
+const (
+	hashHeaderLen = 8
+	hashPtrLen    = 3
+	hashChain     = 1 << 23
+	hashNone      = 0xffffff
+)
+
+var errHashTooBig = errors.New("ndb: database too large for hash file")
+
+// ndbhash is Plan 9's ndbhash function.
+func ndbhash(val string, hlen uint32) uint32 {
+	var h uint32
+	for i := 0; i < len(val); i++ {
+		h = h*13 + uint32(val[i]) - 'a'
+	}
+	return h % hlen
+}
+
+func getPtr(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putPtr(b []byte, p uint32) {
+	b[0], b[1], b[2] = byte(p), byte(p>>8), byte(p>>16)
+}
+
+// A hashFile is an in-memory copy of a hash file.
+type hashFile struct {
+	hlen uint32
+	tab  []byte
+}
+
+// readHashFile reads the hash file at path, returning an error if it
+// is malformed or was not built for a database modified at mtime.
+func readHashFile(path string, mtime uint32) (*hashFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < hashHeaderLen {
+		return nil, errors.New("ndb: short hash file " + path)
+	}
+	if binary.LittleEndian.Uint32(b) != mtime {
+		return nil, errors.New("ndb: out of date hash file " + path)
+	}
+	h := &hashFile{hlen: binary.LittleEndian.Uint32(b[4:]), tab: b[hashHeaderLen:]}
+	if h.hlen == 0 || uint64(h.hlen)*hashPtrLen > uint64(len(h.tab)) {
+		return nil, errors.New("ndb: corrupt hash file " + path)
+	}
+	return h, nil
+}
+
+// lookup returns the database offsets of the entries that may
+// contain a tuple with the value val.
+func (h *hashFile) lookup(val string) []int64 {
+	var offs []int64
+	p := getPtr(h.tab[ndbhash(val, h.hlen)*hashPtrLen:])
+	for n := 0; p != hashNone && n <= len(h.tab); n++ {
+		if p&hashChain == 0 {
+			return append(offs, int64(p))
+		}
+		c := int(p &^ hashChain)
+		if c+2*hashPtrLen > len(h.tab) {
+			break
+		}
+		if first := getPtr(h.tab[c:]); first != hashNone {
+			offs = append(offs, int64(first))
+		}
+		p = getPtr(h.tab[c+hashPtrLen:])
+	}
+	return offs
+}
+
+// MkHash creates a hash file for the attribute attr of the ndb file
+// at path, in the format produced by Plan 9's ndb/mkhash. The hash
+// file is named path.attr. Databases opened with Open use up to date
+// hash files to answer Search without scanning every entry.
+func MkHash(path, attr string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) >= hashChain {
+		return errHashTooBig
+	}
+	type tuple struct {
+		val string
+		off uint32
+	}
+	var tuples []tuple
+	d := NewDecoder(bytes.NewReader(data))
+	for {
+		e, err := d.DecodeEntry()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		for _, v := range e.GetAll(attr) {
+			tuples = append(tuples, tuple{v, uint32(d.start)})
+		}
+	}
+
+	hlen := uint32(2*len(tuples) + 1)
+	tab := make([]byte, hlen*hashPtrLen, hlen*hashPtrLen*3)
+	for i := 0; i < len(tab); i += hashPtrLen {
+		putPtr(tab[i:], hashNone)
+	}
+	for _, t := range tuples {
+		if uint32(len(tab)) >= hashChain {
+			return errHashTooBig
+		}
+		// As in mkhash's enter: an empty slot holds the entry
+		// offset directly, otherwise the offset is appended to
+		// the end of the slot's chain.
+		last := ndbhash(t.val, hlen) * hashPtrLen
+		p := getPtr(tab[last:])
+		if p == hashNone {
+			putPtr(tab[last:], t.off)
+			continue
+		}
+		for p&hashChain != 0 {
+			last = (p &^ hashChain) + hashPtrLen
+			p = getPtr(tab[last:])
+		}
+		next := uint32(len(tab))
+		if p == hashNone {
+			putPtr(tab[last:], t.off)
+			continue
+		}
+		putPtr(tab[last:], next|hashChain)
+		tab = append(tab, make([]byte, 2*hashPtrLen)...)
+		putPtr(tab[next:], p)
+		putPtr(tab[next+hashPtrLen:], t.off)
+	}
+
+	out := make([]byte, hashHeaderLen, hashHeaderLen+len(tab))
+	binary.LittleEndian.PutUint32(out, uint32(fi.ModTime().Unix()))
+	binary.LittleEndian.PutUint32(out[4:], hlen)
+	return os.WriteFile(path+"."+attr, append(out, tab...), 0664)
+}
+
+// hash returns the hash file for attr, or nil if there is no up to
+// date hash file. Results are cached.
+func (db *Database) hash(attr string) *hashFile {
+	if db.path == "" || db.offsets == nil {
+		return nil
+	}
+	db.hmu.Lock()
+	defer db.hmu.Unlock()
+	if h, ok := db.hashes[attr]; ok {
+		return h
+	}
+	h, err := readHashFile(db.path+"."+attr, db.mtime)
+	if err != nil {
+		h = nil
+	}
+	if db.hashes == nil {
+		db.hashes = make(map[string]*hashFile)
+	}
+	db.hashes[attr] = h
+	return h
+}
+
+// searchHash returns the entries containing attr=val, using the
+// hash file h to locate them.
+func (db *Database) searchHash(h *hashFile, attr, val string) ([]Entry, error) {
+	var idx []int
+	for _, off := range h.lookup(val) {
+		// Plan 9 records the offset following the previous entry,
+		// which may precede blank lines and comments.
+		i := sort.Search(len(db.offsets), func(i int) bool {
+			return db.offsets[i] >= off
+		})
+		if i < len(db.offsets) {
+			idx = append(idx, i)
+		}
+	}
+	sort.Ints(idx)
+	var found []Entry
+	for j, i := range idx {
+		if j > 0 && idx[j-1] == i {
+			continue
+		}
+		e, err := db.at(i)
+		if err != nil {
+			return nil, err
+		}
+		if e.has(attr, val) {
+			found = append(found, e)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,106 @@
+package ndb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "ndb")
+	if err := os.WriteFile(path, []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMkHashFormat(t *testing.T) {
+	path := writeTestFile(t, "sys=a\n")
+	if err := MkHash(path, "sys"); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 8)
+	binary.LittleEndian.PutUint32(want, uint32(fi.ModTime().Unix()))
+	binary.LittleEndian.PutUint32(want[4:], 3)
+	want = append(want, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+
+	got, err := os.ReadFile(path + ".sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Got %x, wanted %x", got, want)
+	}
+}
+
+func TestHashSearch(t *testing.T) {
+	var buf strings.Builder
+	buf.WriteString("# generated\n\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&buf, "sys=host%d ip=10.0.%d.%d\n", i, i/50, i%50)
+		if i%3 == 0 {
+			fmt.Fprintf(&buf, "\tdom=host%d.example.com sys=alias%d\n", i, i%7)
+		}
+		if i%10 == 0 {
+			buf.WriteString("\n# spacer\n")
+		}
+	}
+	path := writeTestFile(t, buf.String())
+	if err := MkHash(path, "sys"); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.hash("sys") == nil {
+		t.Fatal("hash file not used")
+	}
+	for _, val := range []string{"host0", "host57", "host199", "alias3", "alias6", "host200", ""} {
+		got, _ := db.Search("sys", val)
+		var want []Entry
+		for _, e := range db.Entries() {
+			if e.has("sys", val) {
+				want = append(want, e)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Search(sys, %s): Got %v, wanted %v", val, got, want)
+		}
+	}
+}
+
+func TestHashStale(t *testing.T) {
+	path := writeTestFile(t, "sys=a\nsys=b\n")
+	if err := MkHash(path, "sys"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("sys=b\nsys=a\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.hash("sys") != nil {
+		t.Error("out of date hash file was used")
+	}
+	got, _ := db.Search("sys", "a")
+	if len(got) != 1 || got[0].Get("sys") != "a" {
+		t.Errorf("Got %v, wanted [[sys=a]]", got)
+	}
+}
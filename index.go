@@ -0,0 +1,87 @@
+package ndb
+
+// An index maps each value of an attribute to the positions, in
+// ascending order, of the entries containing it.
+type index map[string][]int
+
+// Index builds in-memory indexes over the given attributes, so that
+// Search, and the methods using it, find the entries containing a
+// tuple with one of them without examining every entry. Indexes take
+// precedence over hash files. They are rebuilt whenever the entries
+// change, as by Reload, Watch or SortBy, and are kept by Clone.
+// Indexing an attribute again has no effect.
+func (db *Database) Index(attrs ...string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.load()
+	var add []string
+	for _, attr := range attrs {
+		if _, ok := db.indexes[attr]; !ok {
+			add = append(add, attr)
+		}
+	}
+	if db.indexes == nil {
+		db.indexes = make(map[string]index, len(add))
+	}
+	for attr, ix := range buildIndexes(db.entries, add) {
+		db.indexes[attr] = ix
+	}
+}
+
+// buildIndexes returns an index of entries for each attribute in
+// attrs.
+func buildIndexes(entries []Entry, attrs []string) map[string]index {
+	if len(attrs) == 0 {
+		return nil
+	}
+	indexes := make(map[string]index, len(attrs))
+	for _, attr := range attrs {
+		indexes[attr] = make(index)
+	}
+	for i, e := range entries {
+		for _, p := range e {
+			ix, ok := indexes[p.Attr]
+			if !ok {
+				continue
+			}
+			pos := ix[p.Val]
+			if len(pos) == 0 || pos[len(pos)-1] != i {
+				ix[p.Val] = append(pos, i)
+			}
+		}
+	}
+	return indexes
+}
+
+// reindex rebuilds the Database's indexes after its entries change.
+// The caller must hold db.mu for writing.
+func (db *Database) reindex() {
+	if len(db.indexes) == 0 {
+		return
+	}
+	db.load()
+	db.indexes = buildIndexes(db.entries, db.indexAttrs())
+}
+
+// indexAttrs returns the attributes the Database is indexed by.
+func (db *Database) indexAttrs() []string {
+	attrs := make([]string, 0, len(db.indexes))
+	for attr := range db.indexes {
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+// searchIndex returns the entries containing attr=val, using the
+// index ix to locate them.
+func (db *Database) searchIndex(ix index, val string) []Entry {
+	pos := ix[val]
+	if len(pos) == 0 {
+		return nil
+	}
+	found := make([]Entry, len(pos))
+	for j, i := range pos {
+		found[j] = db.entries[i]
+	}
+	return found
+}
@@ -0,0 +1,86 @@
+package ndb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local")
+	if err := os.WriteFile(path, []byte(testDB), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Index("ip", "sys")
+	db.Index("ip")
+	for _, tt := range []struct{ attr, val, want string }{
+		{"ip", "135.104.9.3", "[sys=oak]"},
+		{"ip", "135.104.9.9", "[]"},
+		{"sys", "fir", "[sys=fir]"},
+		{"dom", "oak.example.com", "[sys=oak]"},
+	} {
+		found, err := db.Search(tt.attr, tt.val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range found {
+			got = append(got, "sys="+e.Get("sys"))
+		}
+		if s := fmt.Sprint(got); s != tt.want {
+			t.Errorf("Search(%s, %s) = %s, wanted %s", tt.attr, tt.val, s, tt.want)
+		}
+	}
+
+	c := db.Clone()
+	if err := os.WriteFile(path, []byte("sys=elm ip=135.104.9.3\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := db.Search("ip", "135.104.9.3"); len(found) != 1 || found[0].Get("sys") != "elm" {
+		t.Errorf("Got %v after reload, wanted sys=elm", found)
+	}
+	if found, _ := db.Search("sys", "oak"); len(found) != 0 {
+		t.Errorf("Got %v after reload, wanted no entries", found)
+	}
+	if found, _ := c.Search("ip", "135.104.9.3"); len(found) != 1 || found[0].Get("sys") != "oak" {
+		t.Errorf("Got %v from clone, wanted sys=oak", found)
+	}
+}
+
+func TestIndexSort(t *testing.T) {
+	db, err := OpenReader(strings.NewReader("sys=b ip=2\nsys=a ip=1\nsys=c ip=1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Index("ip")
+	db.SortBy("sys", false)
+	found, _ := db.Search("ip", "1")
+	if len(found) != 2 || found[0].Get("sys") != "a" || found[1].Get("sys") != "c" {
+		t.Errorf("Got %v after sort, wanted sys=a and sys=c", found)
+	}
+}
+
+func BenchmarkSearchIndex(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&sb, "sys=host%d ip=10.%d.%d.%d\n", i, i>>16, i>>8&0xff, i&0xff)
+	}
+	db, err := OpenReader(strings.NewReader(sb.String()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	db.Index("ip")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.Search("ip", "10.1.134.160")
+	}
+}
@@ -0,0 +1,130 @@
+package ndb
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+)
+
+var errBadIP = errors.New("ndb: invalid IP address")
+
+// A network is an ipnet= entry along with the addresses it covers.
+type network struct {
+	net   *net.IPNet
+	entry Entry
+}
+
+// networks returns the ipnet= entries containing ip, ordered from
+// the most to the least specific.
+func (db *Database) networks(ip net.IP) []network {
+	var nets []network
+	for _, e := range db.entries {
+		if _, ok := e.first("ipnet"); !ok {
+			continue
+		}
+		n := ipNetOf(e)
+		if n != nil && n.Contains(ip) {
+			nets = append(nets, network{n, e})
+		}
+	}
+	sort.SliceStable(nets, func(i, j int) bool {
+		a, _ := nets[i].net.Mask.Size()
+		b, _ := nets[j].net.Mask.Size()
+		return a > b
+	})
+	return nets
+}
+
+// ipNetOf returns the network described by an entry's ip= and
+// ipmask= attributes. If there is no ipmask=, the classful mask of
+// an IPv4 address, or a /64 mask for IPv6, is used, as Plan 9 does.
+func ipNetOf(e Entry) *net.IPNet {
+	ip := net.ParseIP(e.Get("ip"))
+	if ip == nil {
+		return nil
+	}
+	var mask net.IPMask
+	if s, ok := e.first("ipmask"); ok {
+		m := net.ParseIP(s)
+		if m == nil {
+			return nil
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, mask = ip4, net.IPMask(m.To4())
+		} else {
+			mask = net.IPMask(m.To16())
+		}
+		if mask == nil {
+			return nil
+		}
+	} else if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		switch {
+		case ip4[0] < 128:
+			mask = net.CIDRMask(8, 32)
+		case ip4[0] < 192:
+			mask = net.CIDRMask(16, 32)
+		default:
+			mask = net.CIDRMask(24, 32)
+		}
+	} else {
+		mask = net.CIDRMask(64, 128)
+	}
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// Ipinfo resolves the given attributes for the IP address ip, in
+// the manner of Plan 9's ndbipinfo. Each attribute is looked up first
+// in the entries for the host with ip=ip, then in the ipnet= entries
+// of the networks containing ip, from the most specific subnet to the
+// least. All values of an attribute are taken from the first entry
+// that has it. The returned tuples are in the order of attrs;
+// attributes that could not be resolved are omitted.
+func (db *Database) Ipinfo(ip string, attrs ...string) ([]Pair, error) {
+	db.rlock()
+	defer db.mu.RUnlock()
+	return db.ipinfo(ip, attrs...)
+}
+
+func (db *Database) ipinfo(ip string, attrs ...string) ([]Pair, error) {
+	key := "ipinfo\x00" + ip + "\x00" + strings.Join(attrs, "\x00")
+	return cachedQuery(db.cache, db.metrics, key, func() ([]Pair, error) {
+		return db.lookupIpinfo(ip, attrs)
+	})
+}
+
+func (db *Database) lookupIpinfo(ip string, attrs []string) ([]Pair, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, errBadIP
+	}
+	var chain []Entry
+	for _, e := range db.entries {
+		if _, ok := e.first("ipnet"); ok {
+			continue
+		}
+		for _, v := range e.GetAll("ip") {
+			if addr.Equal(net.ParseIP(v)) {
+				chain = append(chain, e)
+				break
+			}
+		}
+	}
+	for _, n := range db.networks(addr) {
+		chain = append(chain, n.entry)
+	}
+
+	var result []Pair
+	for _, attr := range attrs {
+		for _, e := range chain {
+			if v := e.GetAll(attr); len(v) > 0 {
+				for _, val := range v {
+					result = append(result, Pair{attr, val})
+				}
+				break
+			}
+		}
+	}
+	return result, nil
+}
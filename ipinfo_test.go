@@ -0,0 +1,51 @@
+package ndb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testNetworks = `ipnet=mh-net ip=135.104.0.0 ipmask=255.255.0.0
+	dns=135.104.1.1 dns=135.104.1.2
+	auth=auth.mh.example.com
+	fs=fs.mh.example.com
+ipnet=unix-room ip=135.104.117.0 ipmask=255.255.255.0
+	ipgw=135.104.117.1
+	fs=fs.unix.example.com
+sys=anna ip=135.104.117.5 dom=anna.example.com
+	fs=anna-fs.example.com
+sys=bob ip=135.104.9.9
+ipnet=classful ip=10.0.0.0
+	ntp=10.0.0.1
+`
+
+func TestIpinfo(t *testing.T) {
+	db, err := OpenReader(strings.NewReader(testNetworks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip    string
+		attrs []string
+		want  string
+	}{
+		{"135.104.117.5", []string{"fs", "ipgw", "dns", "auth"},
+			"[{fs anna-fs.example.com} {ipgw 135.104.117.1} {dns 135.104.1.1} {dns 135.104.1.2} {auth auth.mh.example.com}]"},
+		{"135.104.117.99", []string{"fs", "sys"}, "[{fs fs.unix.example.com}]"},
+		{"135.104.9.9", []string{"sys", "fs", "ipgw"}, "[{sys bob} {fs fs.mh.example.com}]"},
+		{"10.1.2.3", []string{"ntp"}, "[{ntp 10.0.0.1}]"},
+		{"192.168.1.1", []string{"fs"}, "[]"},
+	}
+	for _, tt := range tests {
+		got, err := db.Ipinfo(tt.ip, tt.attrs...)
+		if err != nil {
+			t.Error(err)
+		} else if fmt.Sprint(got) != tt.want {
+			t.Errorf("Ipinfo(%s, %v) = %v, wanted %s", tt.ip, tt.attrs, got, tt.want)
+		}
+	}
+	if _, err := db.Ipinfo("bogus", "fs"); err == nil {
+		t.Error("Ipinfo accepted an invalid address")
+	}
+}
@@ -0,0 +1,112 @@
+package ndb
+
+import "sort"
+
+// A JoinMode determines how Join treats entries that have more
+// than one value for the join attribute.
+type JoinMode int
+
+const (
+	// JoinFirst matches entries using only the first value of
+	// the join attribute.
+	JoinFirst JoinMode = iota
+	// JoinAll matches entries on any value of the join attribute.
+	// Entries sharing several values are still joined only once.
+	JoinAll
+)
+
+// Join performs an inner join of a and b on the attribute attr.
+// For every pair of entries from a and b that share a value of attr,
+// the result contains an entry made of the tuples from a followed
+// by the tuples from b, minus b's attr tuples. Entries are returned
+// in the order of a, then b.
+func Join(a, b *Database, attr string, mode JoinMode) *Database {
+	keys := func(e Entry) []string {
+		v := e.GetAll(attr)
+		if mode == JoinFirst && len(v) > 1 {
+			v = v[:1]
+		}
+		return v
+	}
+	// Neither lock is held while taking the other, so that Joins
+	// of a and b in opposite orders cannot deadlock
+	ae, be := a.Entries(), b.Entries()
+	index := make(map[string][]int)
+	for i, e := range be {
+		for _, v := range keys(e) {
+			index[v] = append(index[v], i)
+		}
+	}
+
+	j := new(Database)
+	for _, ea := range ae {
+		seen := make(map[int]struct{})
+		var match []int
+		for _, v := range keys(ea) {
+			for _, i := range index[v] {
+				if _, ok := seen[i]; !ok {
+					seen[i] = struct{}{}
+					match = append(match, i)
+				}
+			}
+		}
+		sort.Ints(match)
+		for _, i := range match {
+			add := append(Entry(nil), ea...)
+			for _, p := range be[i] {
+				if p.Attr != attr {
+					add = append(add, p)
+				}
+			}
+			j.entries = append(j.entries, add)
+		}
+	}
+	return j
+}
+
+// Merge layers the Databases dbs, such as site, host and override
+// files, into one, following ndb(6): an entry is identified by its
+// first tuple, and entries from several Databases with the same first
+// tuple are combined, with earlier Databases taking precedence. The
+// combined entry holds the tuples of the earliest entry, followed by
+// the tuples of later entries whose attributes it does not already
+// have. Entries are returned in the order they first appear.
+func Merge(dbs ...*Database) *Database {
+	return merge(false, dbs)
+}
+
+// MergeUnion is like Merge, but combines entries by taking the union
+// of their tuples, so that multi-valued attributes such as ip collect
+// the values from every Database.
+func MergeUnion(dbs ...*Database) *Database {
+	return merge(true, dbs)
+}
+
+func merge(union bool, dbs []*Database) *Database {
+	m := new(Database)
+	index := make(map[Pair]int)
+	for _, db := range dbs {
+		for _, e := range db.Entries() {
+			if len(e) == 0 {
+				continue
+			}
+			i, ok := index[e[0]]
+			if !ok {
+				index[e[0]] = len(m.entries)
+				m.entries = append(m.entries, append(Entry(nil), e...))
+				continue
+			}
+			have := m.entries[i]
+			for _, p := range e {
+				if union && have.has(p.Attr, p.Val) {
+					continue
+				}
+				if _, ok := have.first(p.Attr); ok && !union {
+					continue
+				}
+				m.entries[i] = append(m.entries[i], p)
+			}
+		}
+	}
+	return m
+}
@@ -0,0 +1,164 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	errJSONShape = errors.New("ndb: JSON input must be an array of objects")
+	errJSONValue = errors.New("ndb: JSON values must be strings, numbers, booleans, null, or arrays of them")
+)
+
+// ToJSON converts the ndb text data to a JSON array with an object
+// for each entry, so that JSON tools can consume ndb data. Attributes
+// appear in the order they first occur in the entry. The value of an
+// attribute that appears once is a string, and the values of a
+// repeated attribute are an array of strings.
+func ToJSON(data []byte) ([]byte, error) {
+	db, err := OpenReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	dst := []byte{'['}
+	for i, e := range db.entries {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONEntry(dst, e)
+	}
+	return append(dst, ']'), nil
+}
+
+func appendJSONEntry(dst []byte, e Entry) []byte {
+	dst = append(dst, '{')
+	seen := make(map[string]bool, len(e))
+	for _, p := range e {
+		if seen[p.Attr] {
+			continue
+		}
+		if len(seen) > 0 {
+			dst = append(dst, ',')
+		}
+		seen[p.Attr] = true
+		dst = appendJSONString(dst, p.Attr)
+		dst = append(dst, ':')
+		vals := e.GetAll(p.Attr)
+		if len(vals) == 1 {
+			dst = appendJSONString(dst, vals[0])
+			continue
+		}
+		dst = append(dst, '[')
+		for i, v := range vals {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendJSONString(dst, v)
+		}
+		dst = append(dst, ']')
+	}
+	return append(dst, '}')
+}
+
+func appendJSONString(dst []byte, s string) []byte {
+	b, _ := json.Marshal(s)
+	return append(dst, b...)
+}
+
+// FromJSON converts a JSON array of objects, in the form produced by
+// ToJSON, to ndb text with an entry on each line. Attributes are
+// written in the order they appear in each object, and an array value
+// becomes a tuple for each of its elements. Numbers and booleans are
+// written as they appear in the JSON input, and null as an empty
+// value.
+func FromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := expectDelim(dec, '['); err != nil {
+		return nil, err
+	}
+	var dst []byte
+	for dec.More() {
+		if err := expectDelim(dec, '{'); err != nil {
+			return nil, err
+		}
+		var e Entry
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			attr := tok.(string)
+			if tok, err = dec.Token(); err != nil {
+				return nil, err
+			}
+			if tok != json.Delim('[') {
+				val, ok := jsonScalar(tok)
+				if !ok {
+					return nil, errJSONValue
+				}
+				e.Add(attr, val)
+				continue
+			}
+			for dec.More() {
+				if tok, err = dec.Token(); err != nil {
+					return nil, err
+				}
+				val, ok := jsonScalar(tok)
+				if !ok {
+					return nil, errJSONValue
+				}
+				e.Add(attr, val)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		var err error
+		if dst, err = AppendEntry(dst, e); err != nil {
+			return nil, err
+		}
+		dst = append(dst, '\n')
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// expectDelim reads the delimiter d from dec.
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != d {
+		return errJSONShape
+	}
+	return nil
+}
+
+// jsonScalar returns the text of a JSON string, number, boolean or
+// null token.
+func jsonScalar(tok json.Token) (string, bool) {
+	switch v := tok.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		if v {
+			return "true", true
+		}
+		return "false", true
+	case nil:
+		return "", true
+	}
+	return "", false
+}
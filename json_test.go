@@ -0,0 +1,33 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import "testing"
+
+func TestToJSON(t *testing.T) {
+	b, err := ToJSON([]byte("sys=fir ip=1 ip=2 dom='fir example'\nsys=oak\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"sys":"fir","ip":["1","2"],"dom":"fir example"},{"sys":"oak"}]`
+	if string(b) != want {
+		t.Errorf("Got %s, wanted %s", b, want)
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	b, err := FromJSON([]byte(`[{"sys": "fir", "ip": ["1", "2"], "mtu": 1500, "trusted": true, "note": null},
+		{"dom": "fir example"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "sys=fir ip=1 ip=2 mtu=1500 trusted=true note=\ndom='fir example'\n"
+	if string(b) != want {
+		t.Errorf("Got %q, wanted %q", b, want)
+	}
+	for _, bad := range []string{`{"sys": "fir"}`, `[{"sys": {"a": 1}}]`, `[{"sys": [[1]]}]`, `[{"sys": "fir"`, `[1]`} {
+		if _, err := FromJSON([]byte(bad)); err == nil {
+			t.Errorf("FromJSON(%s): Got nil, wanted error", bad)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// A LogHandler is a slog.Handler that writes each log record as an
+// ndb entry on a single line, such as
+//
+//	time=2006-01-02T15:04:05.999Z level=INFO msg=listening addr=:80
+//
+// so that logs can be read back with a Decoder, even one given
+// StrictAttrs. Attribute keys are changed to valid ndb attributes by
+// replacing any character other than a letter, digit or '-' with '-'
+// and removing any leading '-', and the keys of attributes in a group
+// are prefixed with the group name and a '-'. A key with no letters
+// or digits becomes attr. New lines in values are replaced with
+// spaces.
+type LogHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	prefix string // prefix for the keys of added attributes
+	attrs  []byte // tuples added by WithAttrs, each preceded by a space
+}
+
+// NewLogHandler returns a LogHandler that writes to w. It handles
+// records at the slog.LevelInfo level and above.
+func NewLogHandler(w io.Writer) *LogHandler {
+	return &LogHandler{mu: new(sync.Mutex), w: w, level: slog.LevelInfo}
+}
+
+// SetLevel sets the minimum level of the records handled by h. It
+// must be called before h is used.
+func (h *LogHandler) SetLevel(l slog.Leveler) {
+	h.level = l
+}
+
+// Enabled reports whether h handles records at level l.
+func (h *LogHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+// Handle writes r as a line of ndb.
+func (h *LogHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf []byte
+	if !r.Time.IsZero() {
+		buf = appendTuple(buf, "time", r.Time.Format(time.RFC3339Nano))
+		buf = append(buf, ' ')
+	}
+	buf = appendTuple(buf, "level", r.Level.String())
+	buf = append(buf, ' ')
+	buf = appendLogTuple(buf, "msg", r.Message)
+	buf = append(buf, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		buf = appendLogAttr(buf, h.prefix, a)
+		return true
+	})
+	buf = append(buf, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf)
+	return err
+}
+
+// WithAttrs returns a LogHandler that adds attrs to every record.
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.attrs = append([]byte(nil), h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = appendLogAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+// WithGroup returns a LogHandler that prefixes the keys of the
+// attributes added later with name.
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + logKey(name) + "-"
+	return &h2
+}
+
+func appendLogAttr(dst []byte, prefix string, a slog.Attr) []byte {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return dst
+	}
+	var val string
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		if a.Key != "" {
+			prefix += logKey(a.Key) + "-"
+		}
+		for _, ga := range a.Value.Group() {
+			dst = appendLogAttr(dst, prefix, ga)
+		}
+		return dst
+	case slog.KindTime:
+		val = a.Value.Time().Format(time.RFC3339Nano)
+	default:
+		val = a.Value.String()
+	}
+	if a.Key == "" {
+		return dst
+	}
+	dst = append(dst, ' ')
+	return appendLogTuple(dst, prefix+logKey(a.Key), val)
+}
+
+// logKey returns key with any rune that may not appear in an
+// attribute replaced with '-', and without the leading dashes that
+// StrictAttrs rejects.
+func logKey(key string) string {
+	key = strings.TrimLeft(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || r == '-' {
+			return r
+		}
+		return '-'
+	}, key), "-")
+	if key == "" {
+		return "attr"
+	}
+	return key
+}
+
+// appendLogTuple appends attr=val to dst, making val a valid value.
+func appendLogTuple(dst []byte, attr, val string) []byte {
+	val = strings.ToValidUTF8(val, "\uFFFD")
+	val = strings.ReplaceAll(val, "\n", " ")
+	return appendTuple(dst, attr, val)
+}
@@ -0,0 +1,66 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewLogHandler(&buf)
+	log := slog.New(h).With("user_id", 7).WithGroup("req")
+	log.Info("hello\nworld", "path", "/x y", slog.Group("g", "a", 1, "b", time.Second))
+	log.Debug("dropped")
+	log.Warn("it's", "err", errors.New("failed"), slog.Group("empty"))
+
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Fatalf("Got %d lines, wanted 2:\n%s", n, buf.String())
+	}
+	d := NewDecoder(&buf)
+	e, err := d.DecodeEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, e.Get("time")); err != nil {
+		t.Errorf("Got time %q: %v", e.Get("time"), err)
+	}
+	want := Entry{{"level", "INFO"}, {"msg", "hello world"}, {"user-id", "7"},
+		{"req-path", "/x y"}, {"req-g-a", "1"}, {"req-g-b", "1s"}}
+	if got := e[1:]; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Got %v, wanted %v", got, want)
+	}
+
+	e, err = d.DecodeEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Entry{{"level", "WARN"}, {"msg", "it's"}, {"user-id", "7"}, {"req-err", "failed"}}
+	if got := e[1:]; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Got %v, wanted %v", got, want)
+	}
+
+	buf.Reset()
+	slog.New(h).With("_x", 0).WithGroup("_g").Info("keys", "_id", 1, "__", 2, "a_b", 3)
+	e, err = NewDecoderWith(&buf, StrictAttrs()).DecodeEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Entry{{"level", "INFO"}, {"msg", "keys"}, {"x", "0"}, {"g-id", "1"}, {"g-attr", "2"}, {"g-a-b", "3"}}
+	if got := e[1:]; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Got %v, wanted %v", got, want)
+	}
+
+	buf.Reset()
+	h.SetLevel(slog.LevelDebug)
+	slog.New(h).Debug("kept")
+	if !strings.Contains(buf.String(), "msg=kept") {
+		t.Errorf("Got %q, wanted debug record", buf.String())
+	}
+}
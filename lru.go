@@ -0,0 +1,146 @@
+package ndb
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CacheStats describes the use of a Database's result cache.
+type CacheStats struct {
+	Hits   uint64 // queries answered from the cache
+	Misses uint64 // queries answered from the entries
+	Len    int    // results held in the cache
+}
+
+// HitRate returns the fraction of queries answered from the cache,
+// or 0 if there have been none.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// CacheResults makes the Database keep the results of up to size of
+// the most recently used Ipinfo queries and Resolver lookups, so that
+// queries repeated in quick succession, as by a server handling many
+// connections from the same hosts, need not examine every entry. A
+// result is discarded after ttl, if ttl is positive, and every result
+// is discarded when the entries of the Database change. A size of
+// zero or less disables the cache. Calling CacheResults replaces any
+// existing cache, and resets its statistics.
+func (db *Database) CacheResults(size int, ttl time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if size <= 0 {
+		db.cache = nil
+		return
+	}
+	db.cache = &resultCache{
+		size:  size,
+		ttl:   ttl,
+		now:   time.Now,
+		items: make(map[string]*list.Element),
+	}
+}
+
+// CacheStats returns statistics on the use of the cache enabled by
+// CacheResults.
+func (db *Database) CacheStats() CacheStats {
+	db.mu.RLock()
+	c := db.cache
+	db.mu.RUnlock()
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{c.hits, c.misses, c.lru.Len()}
+}
+
+// A resultCache holds the results of queries, each of which expires
+// after a time to live, discarding the least recently used when it
+// is full.
+type resultCache struct {
+	mu           sync.Mutex
+	size         int
+	ttl          time.Duration
+	now          func() time.Time
+	items        map[string]*list.Element
+	lru          list.List // of *cacheItem, most recently used first
+	hits, misses uint64
+}
+
+type cacheItem struct {
+	key     string
+	val     any
+	expires time.Time
+}
+
+func (c *resultCache) get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if ok && c.ttl > 0 && !c.now().Before(el.Value.(*cacheItem).expires) {
+		c.lru.Remove(el)
+		delete(c.items, key)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheItem).val, true
+}
+
+func (c *resultCache) put(key string, val any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item := &cacheItem{key: key, val: val}
+	if c.ttl > 0 {
+		item.expires = c.now().Add(c.ttl)
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value = item
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(item)
+	if c.lru.Len() > c.size {
+		old := c.lru.Remove(c.lru.Back()).(*cacheItem)
+		delete(c.items, old.key)
+	}
+}
+
+// clear discards every result, keeping the statistics.
+func (c *resultCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.items)
+	c.lru.Init()
+}
+
+// cachedQuery returns the result of query, from c if it holds a
+// result for key, and reports whether it did to m. Errors are not
+// cached. Results are copied, so that callers may modify them. A nil
+// cache runs every query.
+func cachedQuery[T any](c *resultCache, m Metrics, key string, query func() ([]T, error)) ([]T, error) {
+	if c == nil {
+		return query()
+	}
+	if v, ok := c.get(key); ok {
+		count(m, MetricCacheHits, 1)
+		return slices.Clone(v.([]T)), nil
+	}
+	count(m, MetricCacheMisses, 1)
+	v, err := query()
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, slices.Clone(v))
+	return v, nil
+}
@@ -0,0 +1,79 @@
+package ndb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local")
+	if err := os.WriteFile(path, []byte(testDB), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.CacheResults(2, time.Minute)
+	now := time.Now()
+	db.cache.now = func() time.Time { return now }
+
+	info, err := db.Ipinfo("135.104.9.1", "ipmask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info[0].Val = "modified"
+	if info, _ := db.Ipinfo("135.104.9.1", "ipmask"); len(info) != 1 || info[0].Val != "255.255.0.0" {
+		t.Errorf("Got %v from cache, wanted ipmask=255.255.0.0", info)
+	}
+	r := &Resolver{DB: db}
+	if addrs, err := r.LookupHost(context.Background(), "oak"); err != nil || len(addrs) != 2 {
+		t.Errorf("LookupHost(oak) = %v, %v", addrs, err)
+	}
+	if s := db.CacheStats(); s.Hits != 1 || s.Misses != 2 || s.Len != 2 {
+		t.Errorf("Got %+v, wanted 1 hit, 2 misses, 2 results", s)
+	}
+
+	// The least recently used result is discarded when full
+	r.LookupAddr(context.Background(), "135.104.9.2")
+	r.LookupHost(context.Background(), "oak")
+	if s := db.CacheStats(); s.Hits != 2 || s.Len != 2 {
+		t.Errorf("Got %+v, wanted 2 hits, 2 results", s)
+	}
+	db.Ipinfo("135.104.9.1", "ipmask")
+	if s := db.CacheStats(); s.Hits != 2 || s.Misses != 4 {
+		t.Errorf("Got %+v, wanted Ipinfo to miss after eviction", s)
+	}
+
+	// Results expire after the time to live
+	now = now.Add(time.Hour)
+	r.LookupHost(context.Background(), "oak")
+	if s := db.CacheStats(); s.Hits != 2 || s.Misses != 5 {
+		t.Errorf("Got %+v, wanted expired result to miss", s)
+	}
+	if rate := db.CacheStats().HitRate(); rate != 2.0/7 {
+		t.Errorf("Got hit rate %v, wanted %v", rate, 2.0/7)
+	}
+
+	// Results are discarded when the entries change
+	if err := os.WriteFile(path, []byte("sys=oak ip=10.0.0.1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if s := db.CacheStats(); s.Len != 0 {
+		t.Errorf("Got %d results after reload, wanted 0", s.Len)
+	}
+	if addrs, _ := r.LookupHost(context.Background(), "oak"); len(addrs) != 1 || addrs[0] != "10.0.0.1" {
+		t.Errorf("Got %v after reload, wanted [10.0.0.1]", addrs)
+	}
+
+	db.CacheResults(0, 0)
+	if s := db.CacheStats(); s != (CacheStats{}) {
+		t.Errorf("Got %+v from disabled cache, wanted zero", s)
+	}
+}
@@ -0,0 +1,43 @@
+package ndb
+
+// Metrics receives counts of the work done by Decoders and
+// Databases, so that a program may monitor the health of its
+// configuration, for instance by publishing them with expvar or
+// Prometheus. Add is called with one of the Metric names below and
+// the amount to add to that count. An *expvar.Map satisfies Metrics.
+// Add may be called from several goroutines at once.
+type Metrics interface {
+	Add(name string, delta int64)
+}
+
+// The names of the counts reported to Metrics.
+const (
+	MetricEntries      = "entries"       // entries parsed
+	MetricSyntaxErrors = "syntax_errors" // entries with syntax errors
+	MetricSearches     = "searches"      // calls to Database.Search
+	MetricCacheHits    = "cache_hits"    // queries answered by the result cache
+	MetricCacheMisses  = "cache_misses"  // queries the result cache could not answer
+)
+
+// ReportMetrics makes a Decoder, or a Database opened with Open,
+// report its counts to m.
+func ReportMetrics(m Metrics) Option {
+	return func(c *config) {
+		c.metrics = m
+	}
+}
+
+// SetMetrics makes the Database report its counts to m, including
+// the entries parsed when it is reloaded. A nil m stops reporting.
+func (db *Database) SetMetrics(m Metrics) {
+	db.mu.Lock()
+	db.metrics = m
+	db.mu.Unlock()
+}
+
+// count adds n to the count name, if m is not nil.
+func count(m Metrics, name string, n int64) {
+	if m != nil {
+		m.Add(name, n)
+	}
+}
@@ -0,0 +1,48 @@
+package ndb
+
+import (
+	"expvar"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := new(expvar.Map)
+	d := NewDecoderWith(strings.NewReader("sys=fir\nsys='oak\nsys=elm\n"), ReportMetrics(m))
+	for d.More() {
+		d.DecodeEntry()
+	}
+	if got := m.String(); got != `{"entries": 2, "syntax_errors": 1}` {
+		t.Errorf("Got %s from Decoder", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "local")
+	if err := os.WriteFile(path, []byte(testDB), 0666); err != nil {
+		t.Fatal(err)
+	}
+	m = new(expvar.Map)
+	db, err := Open(path, ReportMetrics(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.CacheResults(10, 0)
+	db.Search("sys", "fir")
+	db.Value("sys", "oak", "ip")
+	db.Ipinfo("135.104.9.1", "ipmask")
+	db.Ipinfo("135.104.9.1", "ipmask")
+	if err := db.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"cache_hits": 1, "cache_misses": 1, "entries": 6, "searches": 2}`
+	if got := m.String(); got != want {
+		t.Errorf("Got %s from Database, wanted %s", got, want)
+	}
+
+	db.SetMetrics(nil)
+	db.Search("sys", "fir")
+	if got := m.String(); got != want {
+		t.Errorf("Got %s after SetMetrics(nil), wanted %s", got, want)
+	}
+}
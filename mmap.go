@@ -0,0 +1,218 @@
+package ndb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// MapFile makes Open memory-map the file, rather than reading and
+// parsing it in full. Only the boundaries of entries are found when
+// the file is opened; each entry is parsed when a search first
+// examines it, so a program making a handful of lookups in a large
+// database, particularly with hash files or values that appear in
+// few entries, parses little of it. Methods that visit every entry,
+// such as Entries, Ipinfo or Index, parse the rest of the file on
+// first use and release the mapping; entries with syntax errors are
+// then skipped, while Search reports them, and WriteFile and Save
+// return the first such error rather than drop them. The file must
+// not be truncated while it is mapped; WriteFile replaces a file
+// without modifying it. Where memory mapping is unavailable, the file
+// is read into memory instead, and still parsed lazily.
+func MapFile() Option {
+	return func(c *config) {
+		c.mmap = true
+	}
+}
+
+var errFileTooBig = errors.New("ndb: file too large to map")
+
+// A lazyFile holds the text of a Database opened with MapFile, and
+// the entries parsed from it so far. Its fields are guarded by mu,
+// which may be locked while holding the Database's read lock.
+type lazyFile struct {
+	mu     sync.Mutex
+	data   []byte
+	lines  []int   // line number of each entry
+	parsed []Entry // by position, nil until parsed
+	unmap  func() error
+}
+
+// openMapped opens the ndb file at path with MapFile, reporting to
+// m, and returns the contents of the file.
+func openMapped(path string, m Metrics) (*Database, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, unmap, err := mapFile(f, fi.Size())
+	if err != nil {
+		return nil, nil, err
+	}
+	lazy := &lazyFile{data: data, unmap: unmap}
+	// Unmap the file if the lazyFile is not released
+	runtime.SetFinalizer(lazy, (*lazyFile).release)
+	db := &Database{
+		path:    path,
+		mtime:   uint32(fi.ModTime().Unix()),
+		file:    newFileState(fi, data),
+		origins: make(map[*Pair]Position),
+		mapped:  true,
+		lazy:    lazy,
+		metrics: m,
+	}
+	db.offsets, lazy.lines = entryBounds(data)
+	lazy.parsed = make([]Entry, len(db.offsets))
+	return db, data, nil
+}
+
+// entryBounds returns the offset and line number of the start of
+// each entry in data, following the rules of Decoder.readLine
+// without parsing any tuples.
+func entryBounds(data []byte) (offsets []int64, lines []int) {
+	in := false
+	for off, lineno := 0, 1; off < len(data); lineno++ {
+		line := data[off:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i+1]
+		}
+		start := off
+		off += len(line)
+		if c := line[0]; in && (c == ' ' || c == '\t' || c == '#') {
+			continue
+		}
+		in = false
+		if isBlank(line) {
+			continue
+		}
+		offsets = append(offsets, int64(start))
+		lines = append(lines, lineno)
+		in = true
+	}
+	return offsets, lines
+}
+
+// release unmaps the file. The lazyFile must not be used afterward.
+func (l *lazyFile) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.unmap != nil {
+		runtime.SetFinalizer(l, nil)
+		l.unmap()
+		l.unmap = nil
+	}
+	l.data = nil
+}
+
+// entry returns the i'th entry of a lazily opened Database, parsing
+// it if it has not been already. The caller must hold db.mu for
+// reading.
+func (db *Database) entry(i int) (Entry, error) {
+	l := db.lazy
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e := l.parsed[i]; e != nil {
+		return e, nil
+	}
+	end := int64(len(l.data))
+	if i+1 < len(db.offsets) {
+		end = db.offsets[i+1]
+	}
+	d := NewDecoderWith(bytes.NewReader(l.data[db.offsets[i]:end]), ReportMetrics(db.metrics))
+	d.offset, d.lineno = db.offsets[i], l.lines[i]-1
+	p, err := d.getPairs()
+	if err == io.EOF {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := newEntry(p)
+	if len(e) > 0 {
+		db.origins[&e[0]] = Position{Line: l.lines[i], Offset: db.offsets[i]}
+	}
+	l.parsed[i] = e
+	return e, nil
+}
+
+// at returns the i'th entry of the Database. The caller must hold
+// db.mu for reading.
+func (db *Database) at(i int) (Entry, error) {
+	if db.lazy != nil {
+		return db.entry(i)
+	}
+	return db.entries[i], nil
+}
+
+// searchLazy returns the entries containing attr=val in a lazily
+// opened Database. The text of each entry is checked for val before
+// it is parsed. The caller must hold db.mu for reading.
+func (db *Database) searchLazy(attr, val string) ([]Entry, error) {
+	// A value with a quote or new line is written differently
+	quoted := strings.ContainsAny(val, "'\n")
+	var found []Entry
+	for i, off := range db.offsets {
+		end := len(db.lazy.data)
+		if i+1 < len(db.offsets) {
+			end = int(db.offsets[i+1])
+		}
+		if !quoted && !bytes.Contains(db.lazy.data[off:end], []byte(val)) {
+			continue
+		}
+		e, err := db.entry(i)
+		if err != nil {
+			return nil, err
+		}
+		if e.has(attr, val) {
+			found = append(found, e)
+		}
+	}
+	return found, nil
+}
+
+// load parses every entry of a lazily opened Database, skipping any
+// with syntax errors, and releases its file. The first error is kept
+// in db.skipped. The caller must hold db.mu for writing.
+func (db *Database) load() {
+	l := db.lazy
+	if l == nil {
+		return
+	}
+	entries := make([]Entry, 0, len(db.offsets))
+	offsets := make([]int64, 0, len(db.offsets))
+	for i, off := range db.offsets {
+		e, err := db.entry(i)
+		if err != nil && db.skipped == nil {
+			db.skipped = err
+		}
+		if err != nil || len(e) == 0 {
+			continue
+		}
+		entries = append(entries, e)
+		offsets = append(offsets, off)
+	}
+	db.entries, db.offsets, db.lazy = entries, offsets, nil
+	l.release()
+}
+
+// rlock locks db for reading, first parsing every entry if the
+// Database was opened with MapFile.
+func (db *Database) rlock() {
+	db.mu.RLock()
+	for db.lazy != nil {
+		db.mu.RUnlock()
+		db.mu.Lock()
+		db.load()
+		db.mu.Unlock()
+		db.mu.RLock()
+	}
+}
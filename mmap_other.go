@@ -0,0 +1,18 @@
+//go:build !unix
+
+package ndb
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the first size bytes of f into memory, where memory
+// mapping is unavailable.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	b, err := io.ReadAll(io.LimitReader(f, size))
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, func() error { return nil }, nil
+}
@@ -0,0 +1,133 @@
+package ndb
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const mapTestDB = `# hosts
+sys=fir ip=135.104.9.1
+	dom=fir.example.com
+  # a comment within an entry
+	ether=0000f86a2b1c
+
+sys=oak ip=135.104.9.2
+sys='elm
+sys=ash ip=135.104.9.4 note='it''s here'
+`
+
+// parsedCount returns the number of entries of a lazily opened
+// Database that have been parsed.
+func parsedCount(db *Database) int {
+	n := 0
+	for _, e := range db.lazy.parsed {
+		if e != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestMapFile(t *testing.T) {
+	path := writeTestFile(t, mapTestDB)
+	db, err := Open(path, MapFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.lazy == nil {
+		t.Fatal("Database was parsed when opened")
+	}
+	if want := []int64{8, 103, 126, 135}; !reflect.DeepEqual(db.offsets, want) {
+		t.Errorf("Got offsets %v, wanted %v", db.offsets, want)
+	}
+	found, err := db.Search("ip", "135.104.9.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Get("sys") != "oak" {
+		t.Errorf("Search(ip, 135.104.9.2) = %v, wanted sys=oak", found)
+	}
+	if n := parsedCount(db); n != 1 {
+		t.Errorf("Parsed %d entries, wanted 1", n)
+	}
+	if pos, _ := db.Position(found[0]); pos.Line != 7 || pos.Offset != 103 {
+		t.Errorf("Got position %+v, wanted line 7, offset 103", pos)
+	}
+	if found, err := db.Search("note", "it's here"); err == nil || len(found) != 0 {
+		t.Errorf("Got %v, %v, wanted syntax error from sys='elm", found, err)
+	}
+	var serr *SyntaxError
+	if _, err := db.Search("sys", "elm"); !errors.As(err, &serr) || serr.Line != 8 {
+		t.Errorf("Got %v, wanted syntax error on line 8", err)
+	}
+	if v, err := db.Value("sys", "fir", "ether"); err != nil || v != "0000f86a2b1c" {
+		t.Errorf("Got %q, %v, wanted 0000f86a2b1c", v, err)
+	}
+
+	entries := db.Entries()
+	if db.lazy != nil {
+		t.Fatal("Database was not parsed by Entries")
+	}
+	var sys []string
+	for _, e := range entries {
+		sys = append(sys, e.Get("sys"))
+	}
+	if want := []string{"fir", "oak", "ash"}; !reflect.DeepEqual(sys, want) {
+		t.Errorf("Got entries %v, wanted %v", sys, want)
+	}
+	if e, _ := db.Search("sys", "ash"); len(e) != 1 || e[0].Get("note") != "it's here" {
+		t.Errorf("Got %v, wanted sys=ash", e)
+	}
+	if err := db.Save(); !errors.As(err, &serr) || serr.Line != 8 {
+		t.Errorf("Save returned %v, wanted syntax error on line 8", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != mapTestDB {
+		t.Errorf("Save modified the file: %q", b)
+	}
+
+	if err := os.WriteFile(path, []byte("sys=elm\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if db.lazy == nil {
+		t.Error("Database was parsed when reloaded")
+	}
+	if e, _ := db.Search("sys", "elm"); len(e) != 1 {
+		t.Errorf("Got %v after reload, wanted sys=elm", e)
+	}
+}
+
+func TestMapFileHash(t *testing.T) {
+	path := writeTestFile(t, "sys=a ip=1\nsys=b ip=2\nsys=c ip=3\n")
+	if err := MkHash(path, "ip"); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path, MapFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := db.Search("ip", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Get("sys") != "b" {
+		t.Errorf("Search(ip, 2) = %v, wanted sys=b", found)
+	}
+	if n := parsedCount(db); n != 1 {
+		t.Errorf("Parsed %d entries, wanted 1", n)
+	}
+}
+
+func TestMapFileEmpty(t *testing.T) {
+	db, err := Open(writeTestFile(t, ""), MapFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := db.Entries(); len(e) != 0 {
+		t.Errorf("Got %v, wanted no entries", e)
+	}
+}
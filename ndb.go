@@ -14,42 +14,47 @@
 // 	* {"example2": "Escape ' marks by doubling like this: ''"}
 // 	  example2='Escape '' marks by doubling like this: '''''
 // 	* {"example3": "can't"}
-// 	  example3=can''t
+// 	  example3='can''t'
 //
-// Tuples must be separated by at least one whitespace character. The same
-// attribute may appear multiple times in an ndb string. When decoding an
-// ndb string with repeated attributes, the destination type must be a slice.
+// Tuples must be separated by at least one whitespace character. A '#'
+// where a tuple would begin starts a comment, which runs to the end of
+// the line. Lines beginning with white space continue the entry on the
+// preceding line. The same attribute may appear multiple times in an
+// ndb string. When decoding an ndb string with repeated attributes, the
+// destination type must be a slice. The UseProfile option selects the
+// dialect of Plan 9's own tools instead, which quotes values with
+// double quotes and has no escaping.
+//
+// Building with the ndbnoreflect tag omits the reflection-based Marshal
+// and Unmarshal family of functions, leaving the tokenizer, the Entry
+// and Database types, and AppendEntry, for constrained targets such as
+// TinyGo that only need to emit and parse a few tuples.
 package ndb
 
 import (
 	"bufio"
-	"bytes"
-	"fmt"
+	"errors"
 	"io"
-	"net/textproto"
-	"reflect"
+	"iter"
+	"strconv"
 	"unicode/utf8"
 )
 
 // A SyntaxError occurs when malformed input, such as an unterminated
-// quoted string, is received. It contains the UTF-8 encoded line that
-// was being read and the position of the first byte that caused the
-// syntax error. Data may only be valid until the next call to the
-// Decode() method
+// quoted string, is received. It contains a copy of the UTF-8 encoded
+// entry that was being read and the position within it of the first
+// byte that caused the syntax error. When the error comes from a
+// Decoder, Line, Column and InputOffset locate that byte in the whole
+// input; otherwise they are zero. Err holds the kind of error, such as
+// ErrUnterminatedQuote, if known.
 type SyntaxError struct {
-	Data    []byte
-	Offset  int64
-	Message string
-}
-
-// A TypeError occurs when a Go value is incompatible with the ndb
-// string it must store or create.
-type TypeError struct {
-	Type reflect.Type
-}
-
-func (e *TypeError) Error() string {
-	return fmt.Sprintf("Invalid type %s or nil pointer", e.Type.String())
+	Data        []byte
+	Offset      int64
+	Line        int   // 1-based line number in the input
+	Column      int   // 1-based byte offset within the line
+	InputOffset int64 // byte offset in the input
+	Message     string
+	Err         error
 }
 
 func min(a, b int64) int64 {
@@ -60,163 +65,373 @@ func min(a, b int64) int64 {
 }
 
 func (e *SyntaxError) Error() string {
-	start := e.Offset
+	msg := e.Message
+	if e.Line > 0 {
+		msg = "line " + strconv.Itoa(e.Line) + ": " + msg
+	}
+	start := min(e.Offset, int64(len(e.Data)))
 	end := min(e.Offset+10, int64(len(e.Data)))
 
 	if e.Data != nil {
 		// Make sure we're on utf8 boundaries
-		for !utf8.RuneStart(e.Data[start]) && start > 0 {
+		for start < end && !utf8.RuneStart(e.Data[start]) && start > 0 {
 			start--
 		}
 		for !utf8.Valid(e.Data[start:end]) && end < int64(len(e.Data)) {
 			end++
 		}
-		return fmt.Sprintf("%s\n\tat `%s'", e.Message, e.Data[start:end])
+		return msg + "\n\tat `" + string(e.Data[start:end]) + "'"
 	}
-	return e.Message
+	return msg
+}
+
+// Unwrap returns e.Err.
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// Marshaler is the interface implemented by types that can encode
+// themselves as ndb. When an Encoder encodes a value implementing
+// Marshaler, it uses the returned bytes rather than the default
+// representation. A struct field or map value's MarshalNDB method
+// must return a single value; it will be quoted as needed. The
+// MarshalNDB method of a value passed directly to Encode must return
+// a complete entry.
+type Marshaler interface {
+	MarshalNDB() ([]byte, error)
+}
+
+// Unmarshaler is the interface implemented by types that can decode
+// an ndb representation of themselves. A struct field or map value's
+// UnmarshalNDB method receives the unquoted value of its tuple. The
+// UnmarshalNDB method of a value passed directly to Decode receives
+// the complete entry. UnmarshalNDB must copy the data if it wishes
+// to retain it after returning.
+type Unmarshaler interface {
+	UnmarshalNDB([]byte) error
 }
 
 // An Encoder wraps an io.Writer and serializes Go values
 // into ndb strings. Successive calls to the Encode() method
 // append lines to the io.Writer.
 type Encoder struct {
-	start bool
-	out   io.Writer
+	config
+	start  bool
+	col    int // column of the next byte written
+	width  int
+	nested bool
+	wrote  bool // an entry has been written
+	eol    string
+	buf    []byte
+	valbuf []byte
+	tuple  []byte
+	out    io.Writer
 }
 
 // A decoder wraps an io.Reader and decodes successive ndb strings
 // into Go values using the Decode() function.
 type Decoder struct {
-	src       *textproto.Reader
+	config
+	rd        io.Reader // underlying src
+	src       *bufio.Reader
+	linebuf   []byte
+	line      []byte
+	spare     []byte // buffer for the line More reads ahead
+	peeked    bool   // linebuf holds the next line, read by More
+	peekErr   error
+	offset    int64 // bytes consumed from src
+	start     int64 // offset of the last entry read
+	end       int64 // offset following the last entry read
+	lineno    int   // lines consumed from src
+	spans     []span
+	scratch   []byte
 	pairbuf   []pair
-	finfo     map[string][]int
 	havemulti bool
-	attrs     map[string]struct{}
-	multi     map[string]struct{}
-}
-
-// The Unmarshal function reads an entire ndb string and unmarshals it
-// into the Go value v. Value v must be a pointer. Unmarshal will behave
-// differently depending on the type of value v points to.
-//
-// If v is a slice, Unmarshal will decode all lines from the ndb input
-// into slice elements. Otherwise, Unmarshal will decode only the first
-// line.
-//
-// If v is a map, Unmarshal will populate v with key/value pairs, where
-// value is decoded according to the concrete types of the map.
-//
-// If v is a struct, Unmarshal will populate struct fields whose names
-// match the ndb attribute. Struct fields may be annotated with a tag
-// of the form `ndb:"name"`, where name matches the attribute string
-// in the ndb input.
-//
-// Struct fields or map keys that do not match the ndb input are left
-// unmodified. Ndb attributes that do not match any struct fields are
-// silently dropped. If an ndb string cannot be converted to the
-// destination value or a syntax error occurs, an error is returned
-// and v is left unmodified. Unmarshal can only store to exported (capitalized)
-// fields of a struct.
-func Unmarshal(data []byte, v interface{}) error {
-	d := NewDecoder(bytes.NewReader(data))
-	return d.Decode(v)
+	counts    map[string]int
+	nocount   bool
+	state     scanState
+	contErr   bool
+	errs      []error
+	tokbuf    []pair
+	tokpos    int
+	tokval    bool
+	maxLine   int
+	maxTuples int
+	maxValue  int
+	hook      decodeHook
+	plines    []int       // line of each tuple, if attributes are mapped
+	structs   structInfos // struct fields, by mapped attribute
 }
 
 // NewDecoder returns a Decoder with its input pulled from an io.Reader
 func NewDecoder(r io.Reader) *Decoder {
 	d := new(Decoder)
-	d.src = textproto.NewReader(bufio.NewReader(r))
-	d.attrs = make(map[string]struct{}, 8)
-	d.multi = make(map[string]struct{}, 8)
-	d.finfo = make(map[string][]int, 8)
+	d.rd = r
+	d.src = bufio.NewReader(r)
+	d.counts = make(map[string]int, 8)
+	d.state = make(scanState, 0, 3)
+	d.config = newConfig(nil)
 	return d
 }
 
-// The Decode method follows the same parsing rules as Unmarshal(), but
-// reads its input from the Decoder's input stream.
-func (d *Decoder) Decode(v interface{}) error {
-	val := reflect.ValueOf(v)
-	typ := reflect.TypeOf(v)
+// Reset discards any state held by the Decoder, including buffered
+// input, and makes it read from r. Its options and internal buffers
+// are kept, so a Decoder may be reused rather than allocating a new
+// one with NewDecoder.
+func (d *Decoder) Reset(r io.Reader) {
+	d.rd = r
+	d.src.Reset(r)
+	d.reset()
+	d.linebuf, d.line, d.scratch = d.linebuf[:0], nil, d.scratch[:0]
+	d.peeked, d.peekErr = false, nil
+	d.offset, d.start, d.end, d.lineno = 0, 0, 0, 0
+	d.spans = d.spans[:0]
+	d.errs = d.errs[:0]
+	d.tokbuf, d.tokpos, d.tokval = nil, 0, false
+}
 
-	if typ.Kind() != reflect.Ptr {
-		return &TypeError{typ}
-	}
+// InputOffset returns the number of bytes of input the Decoder has
+// consumed: the offset following the last entry read, including an
+// entry read ahead by More, and any comments within it.
+func (d *Decoder) InputOffset() int64 {
+	return d.offset
+}
 
-	if typ.Elem().Kind() == reflect.Slice {
-		return d.decodeSlice(val)
+// EntryOffsets returns the offsets in the input of the start of the
+// last entry read, and of the byte following it, so that a program
+// may record where each entry lies and later return to it with
+// SeekEntry. Blank lines and comments preceding the entry are
+// excluded; comment lines directly following it, which the Decoder
+// reads as part of the entry, are included.
+func (d *Decoder) EntryOffsets() (start, end int64) {
+	return d.start, d.end
+}
+
+// SeekEntry discards any buffered input and positions the Decoder
+// to read from offset in its input, which must implement io.Seeker,
+// such as an *os.File. The offset should be the start of an entry,
+// as returned by EntryOffsets. Line numbers reported afterward count
+// from the line at offset, as line 1.
+func (d *Decoder) SeekEntry(offset int64) error {
+	s, ok := d.rd.(io.Seeker)
+	if !ok {
+		return errNoSeek
 	}
+	if _, err := s.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	d.Reset(d.rd)
+	d.offset, d.start, d.end = offset, offset, offset
+	return nil
+}
+
+// DecodeEntry reads the next entry from the Decoder's input and
+// returns its tuples, without decoding them into a Go value. At the
+// end of the input, DecodeEntry returns io.EOF.
+func (d *Decoder) DecodeEntry() (Entry, error) {
 	p, err := d.getPairs()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return newEntry(p), nil
+}
+
+// More reports whether there is another entry in the Decoder's input,
+// so that entries may be read with a loop such as
+//
+//	for d.More() {
+//		if err := d.Decode(&v); err != nil {
+//			return err
+//		}
+//	}
+//
+// More returns true if reading the input fails for any reason other
+// than io.EOF, so that the error is returned by the next call to
+// Decode or DecodeEntry.
+func (d *Decoder) More() bool {
+	if !d.peeked {
+		// The line is read into another buffer, so that values
+		// decoded from the last one with ZeroCopy remain valid.
+		d.linebuf, d.spare = d.spare, d.linebuf
+		_, d.peekErr = d.readLine()
+		d.peeked = true
+	}
+	return d.peekErr != io.EOF
+}
+
+// SkipEntry discards the next entry in the Decoder's input without
+// parsing its tuples. Syntax errors in the skipped entry are not
+// reported. At the end of the input, SkipEntry returns io.EOF.
+func (d *Decoder) SkipEntry() error {
+	_, err := d.readLine()
+	return err
+}
 
-	switch typ.Elem().Kind() {
-	default:
-		return &TypeError{val.Type()}
-	case reflect.Map:
-		if val.Elem().IsNil() {
-			val.Elem().Set(reflect.MakeMap(typ.Elem()))
+// Entries returns an iterator over the remaining entries in the
+// Decoder's input, as read by DecodeEntry. Iteration stops at the end
+// of the input, or after yielding the first error.
+func (d *Decoder) Entries() iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		for {
+			e, err := d.DecodeEntry()
+			if err == io.EOF {
+				return
+			}
+			if !yield(e, err) || err != nil {
+				return
+			}
 		}
-		return d.saveMap(p, val.Elem())
-	case reflect.Struct:
-		if val.IsNil() {
-			return &TypeError{nil}
+	}
+}
+
+// DecodeFunc reads the next entry from the Decoder's input and calls
+// fn with the attribute and value of each of its tuples, in order,
+// stopping at the first error returned by fn. DecodeFunc does not use
+// reflection or allocate for each tuple, and attr and val are only
+// valid until fn returns. Entries read with DecodeFunc are not counted
+// by Count and HasMulti. At the end of the input, DecodeFunc returns
+// io.EOF.
+func (d *Decoder) DecodeFunc(fn func(attr, val []byte) error) error {
+	d.nocount = true
+	p, err := d.getPairs()
+	d.nocount = false
+	if err != nil {
+		return err
+	}
+	for _, t := range p {
+		if err := fn(t.attr, t.val); err != nil {
+			return err
 		}
-		return d.saveStruct(p, val.Elem())
 	}
 	return nil
 }
 
-// Marshal encodes a value into an ndb string. Marshal will use the String
-// method of each struct field or map entry to produce ndb output.
-// If v is a slice or array, multiple ndb lines will be output, one
-// for each element. For structs, attribute names will be the name of
-// the struct field, or the fields ndb annotation if it exists.
-// Ndb attributes may not contain white space. Ndb values may contain
-// white space but may not contain new lines. If Marshal cannot produce
-// valid ndb strings, an error is returned. No guarantee is made about
-// the order of the tuples.
-func Marshal(v interface{}) ([]byte, error) {
-	var buf bytes.Buffer
-	e := NewEncoder(&buf)
-	if err := e.Encode(v); err != nil {
+// DecodeLines is like DecodeEntry, but groups the tuples of the
+// entry by the physical line they appeared on. The first Entry holds
+// the tuples from the entry's first line, and each following Entry
+// holds the tuples from one of its continuation lines. Lines without
+// any tuples, such as indented comments, are omitted.
+func (d *Decoder) DecodeLines() ([]Entry, error) {
+	p, err := d.getPairs()
+	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
-}
-
-// The Encode method will write the ndb encoding of the Go value v
-// to its backend io.Writer. Unlike Decode(), slice or array values
-// are valid, and will cause multiple ndb lines to be written.
-// If the value cannot be fully encoded, an error is returned and
-// no data will be written to the io.Writer.
-func (e *Encoder) Encode(v interface{}) error {
-	val := reflect.ValueOf(v)
-	// Drill down to the concrete value
-	for val.Kind() == reflect.Ptr {
-		if val.IsNil() {
-			return &TypeError{nil}
-		} else {
-			val = val.Elem()
+	var lines []Entry
+	last := -1
+	for i, t := range p {
+		if n := d.lineAt(p, i); n != last || lines == nil {
+			lines = append(lines, nil)
+			last = n
 		}
+		lines[len(lines)-1] = append(lines[len(lines)-1], t.export())
 	}
-	defer func() {
-		e.start = false
-	}()
-	switch val.Kind() {
-	case reflect.Slice:
-		return e.encodeSlice(val)
-	case reflect.Struct:
-		return e.encodeStruct(val)
-	case reflect.Map:
-		return e.encodeMap(val)
-	default:
-		return &TypeError{val.Type()}
-	}
+	return lines, nil
+}
+
+// Count returns the number of times attr appeared in the entry
+// most recently read by the Decoder.
+func (d *Decoder) Count(attr string) int {
+	return d.counts[attr]
+}
+
+// HasMulti reports whether any attribute appeared more than once
+// in the entry most recently read by the Decoder.
+func (d *Decoder) HasMulti() bool {
+	return d.havemulti
 }
 
 // NewEncoder returns an Encoder that writes ndb output to an
 // io.Writer
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{out: w}
+	return &Encoder{config: newConfig(nil), eol: "\n", out: w}
+}
+
+// Reset discards any state held by the Encoder and directs
+// subsequent output to w. It allows an Encoder to be reused
+// rather than allocating a new one with NewEncoder.
+func (e *Encoder) Reset(w io.Writer) {
+	e.start = false
+	e.wrote = false
+	e.col = 0
+	e.out = w
+}
+
+var errBadTerminator = errors.New(`ndb: line terminator must be "\n" or "\r\n"`)
+
+// SetLineTerminator sets the line terminator written between
+// entries and before continuation lines, which must be "\n", the
+// default, or "\r\n"; the Decoder accepts no others. For any other
+// terminator, SetLineTerminator returns an error and leaves the
+// Encoder unchanged.
+func (e *Encoder) SetLineTerminator(eol string) error {
+	if eol != "\n" && eol != "\r\n" {
+		return errBadTerminator
+	}
+	e.eol = eol
+	return nil
+}
+
+// tabWidth is the number of columns assumed for the tab that
+// indents continuation lines.
+const tabWidth = 8
+
+// SetMaxWidth makes the Encoder wrap entries longer than n columns
+// onto continuation lines indented with a tab. A tuple wider than n
+// is written on a line of its own. If n is zero or less, entries are
+// never wrapped, which is the default.
+func (e *Encoder) SetMaxWidth(n int) {
+	e.width = n
+}
+
+// SetMaxLineBytes limits the length of a logical line, including
+// its continuation lines, to n bytes. Decoding an entry longer than
+// n returns ErrLineTooLong, and the entry is skipped, so that the
+// next call to Decode reads the entry following it. If n is zero or
+// less, lines are unlimited, which is the default.
+func (d *Decoder) SetMaxLineBytes(n int) {
+	d.maxLine = n
+}
+
+// SetMaxTuples limits the number of tuples in an entry to n.
+// Decoding an entry with more tuples returns a *SyntaxError wrapping
+// ErrTooManyTuples. If n is zero or less, the number of tuples is
+// unlimited, which is the default.
+func (d *Decoder) SetMaxTuples(n int) {
+	d.maxTuples = n
+}
+
+// SetMaxValueBytes limits the length of a value to n bytes. Decoding
+// an entry with a longer value returns a *SyntaxError wrapping
+// ErrValueTooLong. If n is zero or less, values are unlimited, which
+// is the default.
+func (d *Decoder) SetMaxValueBytes(n int) {
+	d.maxValue = n
+}
+
+// SetAttrMapper makes the Decoder replace the attribute of each tuple
+// it reads with the name returned by fn, as the AttrMapper option
+// does. The names of struct fields are mapped the same way before they
+// are matched, so fn can normalize names, such as by folding their
+// case, without tagging every field. If fn is nil, attributes are used
+// as written, which is the default.
+func (d *Decoder) SetAttrMapper(fn func(string) string) {
+	d.mapAttr = fn
+	d.structs = nil
+}
+
+// GroupBy reads the remaining entries from the Decoder's input
+// and buckets them by their values for attr, following the same
+// rules as Database.GroupBy. Entries are not retained other than
+// in the returned map.
+func (d *Decoder) GroupBy(attr string) (map[string][]Entry, error) {
+	groups := make(map[string][]Entry)
+	for {
+		p, err := d.getPairs()
+		if err == io.EOF {
+			return groups, nil
+		} else if err != nil {
+			return nil, err
+		}
+		groupEntry(groups, newEntry(p), attr)
+	}
 }
@@ -0,0 +1,127 @@
+// Package ndbtypes defines structs for the entries commonly found in
+// a Plan 9 network database, such as /lib/ndb/local, annotated so that
+// they may be decoded and encoded with the ndb package.
+//
+// Each struct describes one kind of entry, identified by the attribute
+// of its first field: sys= for hosts, ipnet= for networks, auth= for
+// authentication servers, and dom= for DNS domains. Attributes that
+// may appear more than once, or not at all, are held in slices, so
+// that an entry round-trips without gaining empty tuples. Attributes
+// not named by a struct are ignored when decoding.
+package ndbtypes
+
+import (
+	"encoding/hex"
+	"net"
+)
+
+// A Sys is a host entry, such as
+//
+//	sys=fir dom=fir.example.com ip=10.0.0.1 ether=0000f86a2b1c
+//		bootf=/386/9pxeload
+type Sys struct {
+	Sys   string   `ndb:"sys"`
+	Dom   []string `ndb:"dom"`
+	IP    []string `ndb:"ip"`
+	Ether []string `ndb:"ether"`
+	Bootf []string `ndb:"bootf"`
+	Proto []string `ndb:"proto"`
+	Auth  []string `ndb:"auth"`
+	FS    []string `ndb:"fs"`
+	DNS   []string `ndb:"dns"`
+	Ipgw  []string `ndb:"ipgw"`
+}
+
+// NewSys returns the entry for the host name, with the address ip
+// and Ethernet address mac. A nil ip or mac is omitted. The Ethernet
+// address is written in the Plan 9 form of 12 hexadecimal digits.
+func NewSys(name string, ip net.IP, mac net.HardwareAddr) Sys {
+	s := Sys{Sys: name}
+	if ip != nil {
+		s.IP = []string{ip.String()}
+	}
+	if mac != nil {
+		s.Ether = []string{hex.EncodeToString(mac)}
+	}
+	return s
+}
+
+// An Ipnet is a network entry, such as
+//
+//	ipnet=office ip=10.0.0.0 ipmask=255.255.255.0
+//		ipgw=10.0.0.254 dns=10.0.0.1 auth=fir fs=oak
+//
+// Hosts within the network inherit the attributes of its entry, as
+// with the Database's Ipinfo method.
+type Ipnet struct {
+	Ipnet  string   `ndb:"ipnet"`
+	IP     string   `ndb:"ip"`
+	Ipmask []string `ndb:"ipmask"`
+	Ipgw   []string `ndb:"ipgw"`
+	DNS    []string `ndb:"dns"`
+	Auth   []string `ndb:"auth"`
+	FS     []string `ndb:"fs"`
+	NTP    []string `ndb:"ntp"`
+	SMTP   []string `ndb:"smtp"`
+	Dom    []string `ndb:"dom"`
+}
+
+// NewIpnet returns the entry for the network name covering n.
+func NewIpnet(name string, n *net.IPNet) Ipnet {
+	ip := n.IP
+	if ip4 := ip.To4(); ip4 != nil && len(n.Mask) == net.IPv4len {
+		ip = ip4
+	}
+	return Ipnet{
+		Ipnet:  name,
+		IP:     ip.Mask(n.Mask).String(),
+		Ipmask: []string{net.IP(n.Mask).String()},
+	}
+}
+
+// An Auth is an authentication server entry, naming the
+// authentication domains the server is responsible for, such as
+//
+//	auth=fir authdom=example.com
+type Auth struct {
+	Auth    string   `ndb:"auth"`
+	Authdom []string `ndb:"authdom"`
+}
+
+// NewAuth returns the entry for the authentication server host,
+// serving the authentication domains authdom.
+func NewAuth(host string, authdom ...string) Auth {
+	return Auth{Auth: host, Authdom: authdom}
+}
+
+// A Dom is a DNS domain entry, such as
+//
+//	dom=example.com soa=
+//		refresh=3600 ttl=3600
+//		ns=ns1.example.com ns=ns2.example.com
+//		mb=hostmaster@example.com
+//
+// An empty Soa marks the domain as one served by this database, as
+// Plan 9's ndb/dns expects.
+type Dom struct {
+	Dom     string   `ndb:"dom"`
+	Soa     []string `ndb:"soa"`
+	Refresh []string `ndb:"refresh"`
+	TTL     []string `ndb:"ttl"`
+	NS      []string `ndb:"ns"`
+	MB      []string `ndb:"mb"`
+	MX      []string `ndb:"mx"`
+	IP      []string `ndb:"ip"`
+	Cname   []string `ndb:"cname"`
+}
+
+// NewDom returns the entry for the domain name, with the name
+// servers ns. If ns is not empty, the domain is marked as
+// authoritative with an empty soa= attribute.
+func NewDom(name string, ns ...string) Dom {
+	d := Dom{Dom: name, NS: ns}
+	if len(ns) > 0 {
+		d.Soa = []string{""}
+	}
+	return d
+}
@@ -0,0 +1,86 @@
+//go:build !ndbnoreflect
+
+package ndbtypes
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"aqwari.net/encoding/ndb"
+)
+
+func TestSysRoundTrip(t *testing.T) {
+	input := "sys=fir dom=fir.example.com ip=10.0.0.1 ip=2001:db8::1 ether=0000f86a2b1c bootf=/386/9pxeload"
+	var s Sys
+	if err := ndb.UnmarshalString(input, &s); err != nil {
+		t.Fatal(err)
+	}
+	want := Sys{
+		Sys:   "fir",
+		Dom:   []string{"fir.example.com"},
+		IP:    []string{"10.0.0.1", "2001:db8::1"},
+		Ether: []string{"0000f86a2b1c"},
+		Bootf: []string{"/386/9pxeload"},
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("Got %+v, wanted %+v", s, want)
+	}
+	b, err := ndb.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != input {
+		t.Errorf("Got %q, wanted %q", b, input)
+	}
+}
+
+func TestConstructors(t *testing.T) {
+	mac, _ := net.ParseMAC("00:00:f8:6a:2b:1c")
+	_, n, _ := net.ParseCIDR("10.0.0.17/24")
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{NewSys("fir", net.ParseIP("10.0.0.1"), mac), "sys=fir ip=10.0.0.1 ether=0000f86a2b1c"},
+		{NewSys("oak", nil, nil), "sys=oak"},
+		{NewIpnet("office", n), "ipnet=office ip=10.0.0.0 ipmask=255.255.255.0"},
+		{NewAuth("fir", "example.com"), "auth=fir authdom=example.com"},
+		{NewDom("example.com", "ns1.example.com"), "dom=example.com soa= ns=ns1.example.com"},
+		{NewDom("www.example.com"), "dom=www.example.com"},
+	}
+	for _, tt := range tests {
+		b, err := ndb.Marshal(tt.v)
+		if err != nil {
+			t.Errorf("%v: %v", tt.v, err)
+			continue
+		}
+		if string(b) != tt.want {
+			t.Errorf("Got %q, wanted %q", b, tt.want)
+		}
+	}
+}
+
+func TestIpinfo(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.0/24")
+	office := NewIpnet("office", n)
+	office.Auth = []string{"fir"}
+	var entries []interface{}
+	entries = append(entries, office, NewSys("oak", net.ParseIP("10.0.0.2"), nil))
+	b, err := ndb.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := ndb.OpenReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := db.Ipinfo("10.0.0.2", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ndb.Entry(info).Get("auth"); got != "fir" {
+		t.Errorf("Got %q, wanted %q", got, "fir")
+	}
+}
@@ -0,0 +1,83 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"net/netip"
+	"reflect"
+	"unsafe"
+)
+
+var (
+	addrType     = reflect.TypeOf(netip.Addr{})
+	prefixType   = reflect.TypeOf(netip.Prefix{})
+	addrPortType = reflect.TypeOf(netip.AddrPort{})
+)
+
+// storeNetip decodes src into dst if dst is a netip.Addr, netip.Prefix
+// or netip.AddrPort, reporting whether it was one of those types. The
+// values are parsed directly, rather than through their UnmarshalText
+// methods, so that decoding them does not allocate.
+func storeNetip(dst reflect.Value, src []byte) (bool, error) {
+	switch dst.Type() {
+	case addrType:
+		return true, storeParsed(dst, src, netip.ParseAddr)
+	case prefixType:
+		return true, storeParsed(dst, src, netip.ParsePrefix)
+	case addrPortType:
+		return true, storeParsed(dst, src, netip.ParseAddrPort)
+	}
+	return false, nil
+}
+
+// storeParsed stores the result of parse(src) in dst. The parse
+// functions of netip keep no reference to their input, except in
+// their errors, so src is parsed in place and copied only if it is
+// invalid.
+func storeParsed[T any](dst reflect.Value, src []byte, parse func(string) (T, error)) error {
+	var s string
+	if len(src) > 0 {
+		s = unsafe.String(&src[0], len(src))
+	}
+	v, err := parse(s)
+	if err != nil {
+		_, err = parse(string(src))
+		return err
+	}
+	setValue(dst, v)
+	return nil
+}
+
+// setValue stores v in dst. Addressable values are stored through a
+// pointer, which avoids boxing v.
+func setValue[T any](dst reflect.Value, v T) {
+	if dst.CanAddr() {
+		*dst.Addr().Interface().(*T) = v
+		return
+	}
+	dst.Set(reflect.ValueOf(v))
+}
+
+// valueOf returns the T held by v. Addressable values are loaded
+// through a pointer, which avoids boxing them.
+func valueOf[T any](v reflect.Value) T {
+	if v.CanAddr() {
+		return *v.Addr().Interface().(*T)
+	}
+	return v.Interface().(T)
+}
+
+// appendNetip appends the text of v to dst if v is a netip.Addr,
+// netip.Prefix or netip.AddrPort, reporting whether it was one of
+// those types.
+func appendNetip(dst []byte, v reflect.Value) ([]byte, bool) {
+	switch v.Type() {
+	case addrType:
+		return valueOf[netip.Addr](v).AppendTo(dst), true
+	case prefixType:
+		return valueOf[netip.Prefix](v).AppendTo(dst), true
+	case addrPortType:
+		return valueOf[netip.AddrPort](v).AppendTo(dst), true
+	}
+	return dst, false
+}
@@ -0,0 +1,72 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestNetip(t *testing.T) {
+	type host struct {
+		Sys  string         `ndb:"sys"`
+		IP   []netip.Addr   `ndb:"ip"`
+		Net  netip.Prefix   `ndb:"ipnet"`
+		Addr netip.AddrPort `ndb:"addr"`
+		Gw   *netip.Addr    `ndb:"ipgw"`
+	}
+	input := "sys=fir ip=10.0.0.1 ip=2001:db8::1 ipnet=10.0.0.0/24 addr=[2001:db8::1]:564 ipgw=10.0.0.254"
+	var h host
+	if err := UnmarshalString(input, &h); err != nil {
+		t.Fatal(err)
+	}
+	gw := netip.MustParseAddr("10.0.0.254")
+	want := host{
+		Sys:  "fir",
+		IP:   []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("2001:db8::1")},
+		Net:  netip.MustParsePrefix("10.0.0.0/24"),
+		Addr: netip.MustParseAddrPort("[2001:db8::1]:564"),
+		Gw:   &gw,
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("Got %+v, wanted %+v", h, want)
+	}
+	b, err := Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != input {
+		t.Errorf("Got %q, wanted %q", b, input)
+	}
+	if err := UnmarshalString("ip=10.0.0.300", &h); err == nil {
+		t.Error("Invalid address decoded without error")
+	}
+
+	m := map[string]netip.Addr{}
+	if err := UnmarshalString("ip=10.0.0.1", &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["ip"] != want.IP[0] {
+		t.Errorf("Got %v, wanted %v", m["ip"], want.IP[0])
+	}
+}
+
+func TestNetipAllocs(t *testing.T) {
+	var a netip.Addr
+	dst := reflect.ValueOf(&a).Elem()
+	src := []byte("2001:db8::1")
+	allocs := testing.AllocsPerRun(100, func() {
+		storeNetip(dst, src)
+	})
+	if allocs != 0 {
+		t.Errorf("Got %v allocations decoding, wanted 0", allocs)
+	}
+	buf := make([]byte, 0, 64)
+	allocs = testing.AllocsPerRun(100, func() {
+		appendNetip(buf[:0], dst)
+	})
+	if allocs != 0 {
+		t.Errorf("Got %v allocations encoding, wanted 0", allocs)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build ndbnoreflect
+
+package ndb
+
+// decodeHook stands in for the DecodeHook set with
+// Decoder.SetDecodeHook, which is only available with reflection.
+type decodeHook func()
+
+// structInfos stands in for the struct fields cached by a Decoder with
+// an attribute mapper.
+type structInfos = map[struct{}]struct{}
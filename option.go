@@ -0,0 +1,137 @@
+package ndb
+
+import "io"
+
+// An Option configures the behavior of a Decoder or Encoder. Options
+// that do not apply to one or the other are ignored.
+type Option func(*config)
+
+// config holds the settings shared by Decoders and Encoders.
+type config struct {
+	tag         string
+	less        func(a, b string) bool
+	zeroCopy    bool
+	weak        bool
+	lock        bool
+	mapAttr     func(string) string
+	strictAttrs bool
+	profile     Profile
+	paragraphs  bool // entries are separated by blank lines
+	mmap        bool
+	workers     int
+	metrics     Metrics
+}
+
+func newConfig(opts []Option) config {
+	c := config{tag: "ndb"}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// TagName sets the struct tag key consulted for attribute names.
+// The default is "ndb".
+func TagName(name string) Option {
+	return func(c *config) {
+		c.tag = name
+	}
+}
+
+// NewDecoderWith returns a Decoder reading from r, configured with
+// the given options.
+func NewDecoderWith(r io.Reader, opts ...Option) *Decoder {
+	d := NewDecoder(r)
+	d.config = newConfig(opts)
+	return d
+}
+
+// NewEncoderWith returns an Encoder writing to w, configured with
+// the given options.
+func NewEncoderWith(w io.Writer, opts ...Option) *Encoder {
+	e := NewEncoder(w)
+	e.config = newConfig(opts)
+	return e
+}
+
+// AttrOrder sets the order in which an Encoder writes the attributes
+// of a struct or map; less reports whether attribute a should be
+// written before attribute b. Attributes that compare equal keep their
+// default order. By default, struct fields are written in the order
+// they are declared and map entries are sorted by attribute name.
+func AttrOrder(less func(a, b string) bool) Option {
+	return func(c *config) {
+		c.less = less
+	}
+}
+
+// Alphabetical orders attributes by name. It can be passed to
+// AttrOrder to write struct fields in alphabetical order.
+func Alphabetical(a, b string) bool {
+	return a < b
+}
+
+// ZeroCopy makes a Decoder store string values without copying them.
+// Decoded strings share memory with the Decoder's internal buffer, and
+// their contents change when the Decoder reads its next entry, so
+// they must not be used after the next call to Decode, DecodeEntry,
+// DecodeFunc, Token, SkipEntry, Reset or SeekEntry, and must be
+// copied, with strings.Clone, to be retained. More, which reads ahead,
+// leaves them intact. This avoids an allocation per string for
+// programs that process each entry before reading the next. Map keys
+// are always copied, as they outlive any single entry.
+func ZeroCopy() Option {
+	return func(c *config) {
+		c.zeroCopy = true
+	}
+}
+
+// AttrMapper makes an Encoder write, and a Decoder read, each
+// attribute name as fn returns it. An Encoder passes fn the names of
+// struct fields, as given by their tags or Go names, and map keys. A
+// Decoder passes fn both the attributes of its input and the names of
+// struct fields, and matches the results, so fn may normalize names,
+// such as with strings.ToLower, without tagging every field. The same
+// fn should be given to an Encoder and the Decoder reading its output.
+func AttrMapper(fn func(string) string) Option {
+	return func(c *config) {
+		c.mapAttr = fn
+	}
+}
+
+// StrictAttrs makes a Decoder reject attributes holding anything but
+// letters, digits and '-', or beginning with '-', as earlier versions
+// of this package did. By default, an attribute may hold any printable
+// character other than '=', as in ndb(6), so that Plan 9 databases
+// with attributes such as auth.dom or ip_addr can be read.
+func StrictAttrs() Option {
+	return func(c *config) {
+		c.strictAttrs = true
+	}
+}
+
+// BlankLineEntries makes a Decoder separate entries by blank lines,
+// rather than by lines that do not begin with white space, for input
+// that groups the lines of an entry into paragraphs. Every line up to
+// the next blank line is part of the entry, and Decode stores all of
+// their tuples; lines that begin with white space are still
+// continuation lines, which may be decoded into nested structs. An
+// Encoder given BlankLineEntries writes a blank line between entries.
+func BlankLineEntries() Option {
+	return func(c *config) {
+		c.paragraphs = true
+	}
+}
+
+// WeaklyTypedInput makes a Decoder tolerant of sloppy, hand-written
+// input. Bool fields also accept y and n, and every spelling of true
+// and false in any case. Number fields accept bools as 1 or 0, and
+// whole floats, such as 1e3, for integers. An empty value, as in
+// dhcp=, stores the zero value of its field, though a bare attribute
+// still sets a bool to true. Numbers are already decoded into string
+// fields as written.
+func WeaklyTypedInput() Option {
+	return func(c *config) {
+		c.weak = true
+	}
+}
@@ -0,0 +1,53 @@
+package ndb
+
+import (
+	"strings"
+	"unicode"
+)
+
+// A Profile is a dialect of ndb that a Decoder reads, or an Encoder
+// writes.
+type Profile int
+
+const (
+	// Extended is the dialect described in the package
+	// documentation, in which a quote within a quoted value is
+	// escaped by doubling it. It is the default.
+	Extended Profile = iota
+	// Plan9Strict is the dialect read and written by Plan 9's
+	// ndb tools. Values are quoted with double quotes, and a
+	// quoted value runs to the next double quote, with no
+	// escaping, so a value cannot hold both white space and a
+	// double quote, or begin with one; single quotes have no
+	// special meaning. Entries are separated by blank lines, as
+	// with the BlankLineEntries option.
+	Plan9Strict
+)
+
+// UseProfile makes a Decoder read, or an Encoder write, the dialect
+// p. It sets the options that p implies, which later options may
+// override.
+func UseProfile(p Profile) Option {
+	return func(c *config) {
+		c.profile = p
+		c.paragraphs = p == Plan9Strict
+	}
+}
+
+// appendPlan9Tuple appends attr=val to dst in the Plan9Strict
+// dialect. It returns false if val cannot be written in it.
+func appendPlan9Tuple(dst []byte, attr, val string) ([]byte, bool) {
+	space := strings.IndexFunc(val, unicode.IsSpace) != -1
+	quote := strings.IndexByte(val, '"') != -1
+	if quote && (space || val[0] == '"') {
+		return dst, false
+	}
+	dst = append(dst, attr...)
+	dst = append(dst, '=')
+	if space {
+		dst = append(dst, '"')
+		dst = append(dst, val...)
+		return append(dst, '"'), true
+	}
+	return append(dst, val...), true
+}
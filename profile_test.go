@@ -0,0 +1,52 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPlan9Strict(t *testing.T) {
+	input := "sys=fir dom=\"fir example\"\nip=10.0.0.1\n# comment\nether=0011aabbccdd\n\nsys=oak s=\"\" t='a\n\tq=it's\n  \nsys=elm\n"
+	d := NewDecoderWith(strings.NewReader(input), UseProfile(Plan9Strict))
+	var got []Entry
+	for e, err := range d.Entries() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	want := []Entry{
+		{{"sys", "fir"}, {"dom", "fir example"}, {"ip", "10.0.0.1"}, {"ether", "0011aabbccdd"}},
+		{{"sys", "oak"}, {"s", ""}, {"t", "'a"}, {"q", "it's"}},
+		{{"sys", "elm"}},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Got %v, wanted %v", got, want)
+	}
+
+	d = NewDecoderWith(strings.NewReader(`x="a""b"`), UseProfile(Plan9Strict))
+	if _, err := d.DecodeEntry(); !errors.Is(err, ErrMissingSpace) {
+		t.Errorf("Got %v, wanted %v", err, ErrMissingSpace)
+	}
+
+	type host struct {
+		Sys string `ndb:"sys"`
+		Dom string `ndb:"dom"`
+	}
+	b, err := MarshalWith([]host{{"fir", "fir example"}, {"oak", "it's"}, {"elm", "it's here"}}, UseProfile(Plan9Strict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "sys=fir dom=\"fir example\"\n\nsys=oak dom=it's\n\nsys=elm dom=\"it's here\"" {
+		t.Errorf("Got %q", b)
+	}
+	for _, dom := range []string{`say "hi"`, `"oak`} {
+		if _, err := MarshalWith(host{"oak", dom}, UseProfile(Plan9Strict)); err == nil {
+			t.Errorf("%q: Got nil, wanted error", dom)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race && !ndbnoreflect
+
+package ndb
+
+func init() {
+	raceEnabled = true
+}
@@ -1,101 +1,490 @@
+//go:build !ndbnoreflect
+
 package ndb
 
 import (
 	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"net/textproto"
+	"math"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
-	"unicode"
+	"sync"
+	"time"
+	"unsafe"
 )
 
-type scanner struct {
-	src *textproto.Reader
+// A TypeError occurs when a Go value is incompatible with the ndb
+// string it must store or create.
+type TypeError struct {
+	Type reflect.Type
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("Invalid type %s or nil pointer", e.Type.String())
+}
+
+// A MissingError is returned when attributes required by the
+// destination struct, through the required tag option, are absent
+// from the ndb input.
+type MissingError struct {
+	Attrs []string
+}
+
+func (e *MissingError) Error() string {
+	return "Missing required attribute " + strings.Join(e.Attrs, ", ")
+}
+
+// A FieldError is returned when the value of a tuple cannot be
+// converted to the type of the struct field or map value it is
+// decoded into. Err is the error from the conversion.
+type FieldError struct {
+	Attr  string
+	Value string
+	Field string // name of the struct field, if any
+	Err   error
 }
 
-type pair struct {
-	attr, val []byte
+func (e *FieldError) Error() string {
+	msg := "Cannot decode " + e.Attr + "=" + e.Value
+	if e.Field != "" {
+		msg += " into field " + e.Field
+	}
+	return msg + ": " + e.Err.Error()
 }
 
-func (p pair) String() string {
-	return fmt.Sprintf("%s => %s", string(p.attr), string(p.val))
+// Unwrap returns e.Err.
+func (e *FieldError) Unwrap() error {
+	return e.Err
 }
 
-func errBadAttr(line []byte, offset int64) error {
-	return &SyntaxError{line, offset, "Invalid attribute name"}
+func fieldError(p pair, name string, err error) error {
+	return &FieldError{Attr: string(p.attr), Value: string(p.val), Field: name, Err: err}
 }
-func errUnterminated(line []byte, offset int64) error {
-	return &SyntaxError{line, offset, "Unterminated quoted string"}
+
+// The Unmarshal function reads an entire ndb string and unmarshals it
+// into the Go value v. Value v must be a pointer. Unmarshal will behave
+// differently depending on the type of value v points to.
+//
+// If v is a slice, Unmarshal will decode all lines from the ndb input
+// into slice elements, allocating a new element for each entry if the
+// slice holds pointers, as a []*T does. Otherwise, Unmarshal will
+// decode only the first line.
+//
+// If v is a map, Unmarshal will populate v with key/value pairs, where
+// value is decoded according to the concrete types of the map. Values
+// stored in an interface{} are given the type they look like: int,
+// float64, bool (true, false, or a bare attribute), or string; a
+// number with leading zeros, such as 02134, is kept as a string.
+// Repeated attributes are stored as a []interface{}. If v is a pointer
+// to an interface{}, it is set to such a map[string]interface{}.
+//
+// If v is a struct, Unmarshal will populate struct fields whose names
+// match the ndb attribute. Struct fields may be annotated with a tag
+// of the form `ndb:"name"`, where name matches the attribute string
+// in the ndb input. A tag may list other names the attribute is known
+// by, separated by spaces, as in `ndb:"host-name hostname sys"`; the
+// field is decoded from any of them, and encoded with the first.
+// Fields tagged `ndb:"-"` are never decoded or encoded. If a field's
+// tag has the required option, as in `ndb:"sys,required"`, and its
+// attribute is absent, a *MissingError naming every such attribute is
+// returned. A slice field, or a pointer to one, receives an element
+// for each tuple with its attribute, and may not hold slices other
+// than []byte. A time.Time field may give its
+// layout, as used by time.Parse, with a format option, as in
+// `ndb:"expires,format=2006-01-02"`; the format option must come
+// last. Without it, times use RFC 3339. A time.Duration field is
+// decoded with time.ParseDuration, and encoded in the same form, such
+// as 2h45m0s. A netip.Addr, netip.Prefix or netip.AddrPort field is
+// decoded with netip.ParseAddr, ParsePrefix or ParseAddrPort, and
+// encoded in the same form; neither direction allocates. An attribute
+// without a value, such as trusted or bootf=, sets a bool field to
+// true and a string field to the empty string. Integers may be
+// written in hexadecimal, octal or binary with a 0x, 0o or 0b prefix;
+// a leading zero alone does not make a number octal. Bool values may
+// be spelled as strconv.ParseBool accepts, or as yes/no, on/off or
+// enable/disable; a field with the bool option, as in
+// `ndb:"dhcp,bool=up|down"`, also accepts the spellings it gives.
+// Continuation lines may be decoded into nested struct fields, as
+// described for Marshal. A []byte field with the hex or base64
+// option, as in `ndb:"key,hex"`, is decoded from that encoding. A
+// field of type RawEntry or []byte tagged `ndb:",raw"` receives a
+// copy of the text of the entry. A slice field with the comma or sep
+// option, as described for Marshal, is decoded from a list of
+// elements in a single value; repeated tuples add to the list. The
+// min and max options, as in `ndb:"port,min=1,max=65535"`, bound the
+// value of a number or duration field, or the length in characters of
+// a string field, and the match option, as in
+// `ndb:"sys,match=^[a-z]+$"`, gives a regular expression the value
+// must contain a match for; like format, match must come last. A
+// value outside its bounds gives a *FieldError wrapping ErrBelowMin,
+// ErrAboveMax or ErrNoMatch. Each element of a slice is checked.
+//
+// Struct fields or map keys that do not match the ndb input are left
+// unmodified. Ndb attributes that do not match any struct fields are
+// silently dropped. If an ndb string cannot be converted to the
+// destination value, a *FieldError is returned; if a syntax error
+// occurs, a *SyntaxError is returned. In either case v is left
+// unmodified. Unmarshal can only store to exported (capitalized)
+// fields of a struct. Values implementing Unmarshaler or
+// encoding.TextUnmarshaler are decoded using those methods instead.
+func Unmarshal(data []byte, v interface{}) error {
+	d := NewDecoder(bytes.NewReader(data))
+	return d.Decode(v)
 }
-func errBadUnicode(line []byte, offset int64) error {
-	return &SyntaxError{line, offset, "Invalid UTF8 input"}
+
+// UnmarshalString is like Unmarshal, but reads its input from a
+// string, avoiding the copy needed to convert it to a []byte. Each
+// line is still copied into the Decoder's buffer as it is read, and
+// decoded strings are copied from there; to avoid the latter, use a
+// Decoder with the ZeroCopy option, whose strings share memory with
+// its buffer rather than with the input string.
+func UnmarshalString(data string, v interface{}) error {
+	d := NewDecoder(strings.NewReader(data))
+	return d.Decode(v)
 }
-func errMissingSpace(line []byte, offset int64) error {
-	return &SyntaxError{line, offset, "Missing white space between tuples"}
+
+// UnmarshalWith is like Unmarshal, but decodes data using a
+// Decoder configured with the given options.
+func UnmarshalWith(data []byte, v interface{}, opts ...Option) error {
+	d := NewDecoderWith(bytes.NewReader(data), opts...)
+	return d.Decode(v)
 }
 
-func (d *Decoder) getPairs() ([]pair, error) {
-	line, err := d.src.ReadContinuedLineBytes()
+// The Decode method follows the same parsing rules as Unmarshal(), but
+// reads its input from the Decoder's input stream.
+func (d *Decoder) Decode(v interface{}) error {
+	val := reflect.ValueOf(v)
+	typ := reflect.TypeOf(v)
+
+	if typ.Kind() != reflect.Ptr {
+		return &TypeError{typ}
+	}
+	if u, ok := v.(Unmarshaler); ok && !val.IsNil() {
+		line, err := d.readLine()
+		if err != nil {
+			return err
+		}
+		return u.UnmarshalNDB(line)
+	}
+
+	if typ.Elem().Kind() == reflect.Slice {
+		return d.decodeSlice(val)
+	}
+	p, err := d.getPairs()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	d.reset()
-	return d.parseLine(line)
+	return d.decodeEntry(p, val)
+}
+
+// decodeEntry stores the tuples p of an entry in the value val points
+// to, joining the errors recorded if the Decoder continues on errors.
+func (d *Decoder) decodeEntry(p []pair, val reflect.Value) error {
+	d.errs = d.errs[:0]
+	err := d.decodePairs(p, val)
+	if err == nil && len(d.errs) > 0 {
+		err = errors.Join(d.errs...)
+	}
+	return err
+}
+
+// ContinueOnError sets whether Decode keeps going after a value cannot
+// be converted or a required attribute is missing. If on is true,
+// Decode stores every value it can, and returns the errors for the
+// rest joined together with errors.Join. By default, Decode stops at
+// the first error.
+func (d *Decoder) ContinueOnError(on bool) {
+	d.contErr = on
 }
 
-func (d *Decoder) reset() {
-	d.pairbuf = d.pairbuf[0:0]
-	for k := range d.finfo {
-		delete(d.finfo, k)
+// A DecodeHook converts the value of a tuple before it is stored.
+// It is called with the tuple's attribute and value, and the type of
+// the struct field, map value or slice element that will hold it. If
+// the hook returns true, its result, which must be assignable to
+// target, is stored in place of the default conversion; a nil result
+// stores the zero value. If it returns false, the value is decoded as
+// usual. The val slice is only valid until the hook returns.
+type DecodeHook func(attr string, val []byte, target reflect.Type) (interface{}, bool, error)
+
+type decodeHook = DecodeHook
+
+// structInfos holds the struct fields of each type decoded by a
+// Decoder with an attribute mapper, by their mapped names.
+type structInfos = map[reflect.Type]*structInfo
+
+// SetDecodeHook makes the Decoder consult hook when storing values,
+// so that applications may convert values, such as CIDR strings to
+// *net.IPNet, without defining a type for every field. A nil hook
+// removes any hook previously set.
+func (d *Decoder) SetDecodeHook(hook DecodeHook) {
+	d.hook = hook
+}
+
+// hookVal calls the Decoder's hook, if any, for the tuple p, which is
+// to be stored to a value of type target. It reports whether the hook
+// converted the value.
+func (d *Decoder) hookVal(p pair, target reflect.Type) (reflect.Value, bool, error) {
+	if d.hook == nil {
+		return reflect.Value{}, false, nil
 	}
-	for k := range d.multi {
-		delete(d.attrs, k)
-		delete(d.multi, k)
+	v, ok, err := d.hook(string(p.attr), p.val, target)
+	if err != nil || !ok {
+		return reflect.Value{}, false, err
 	}
-	d.havemulti = false
+	if v == nil {
+		return reflect.Zero(target), true, nil
+	}
+	rv := reflect.ValueOf(v)
+	if !rv.Type().AssignableTo(target) {
+		return reflect.Value{}, false, &TypeError{target}
+	}
+	return rv, true, nil
 }
 
-func (d *Decoder) decodeSlice(val reflect.Value) error {
-	var err error
+// storeTuple stores the value of p in dst, as storeVal does, unless
+// the Decoder's hook converts it, and checks it against the min, max
+// and match options.
+func (d *Decoder) storeTuple(dst reflect.Value, p pair, opts tagOptions) error {
+	var hooked bool
+	if d.hook != nil {
+		target := dst
+		if dst.Kind() == reflect.Ptr && !dst.CanSet() {
+			target = dst.Elem()
+		}
+		v, ok, err := d.hookVal(p, target.Type())
+		if err != nil {
+			return err
+		} else if ok {
+			target.Set(v)
+			hooked = true
+		}
+	}
+	if !hooked {
+		if err := d.storeVal(dst, p.val, opts); err != nil {
+			return err
+		}
+	}
+	if opts == "" {
+		return nil
+	}
+	return validate(dst, p.val, opts)
+}
+
+// fail returns err, unless the Decoder continues on errors, in which
+// case err is recorded and nil is returned.
+func (d *Decoder) fail(err error) error {
+	if !d.contErr {
+		return err
+	}
+	d.errs = append(d.errs, err)
+	return nil
+}
+
+func (d *Decoder) decodePairs(p []pair, val reflect.Value) error {
+	typ := val.Type()
+	switch typ.Elem().Kind() {
+	default:
+		return &TypeError{val.Type()}
+	case reflect.Interface:
+		if typ.Elem().NumMethod() != 0 {
+			return &TypeError{val.Type()}
+		}
+		m := make(map[string]interface{})
+		if err := d.saveMap(p, reflect.ValueOf(m)); err != nil {
+			return err
+		}
+		val.Elem().Set(reflect.ValueOf(m))
+		return nil
+	case reflect.Map:
+		if d.hook == nil {
+			switch m := val.Interface().(type) {
+			case *map[string]string:
+				return d.saveStringMap(p, m)
+			case *map[string][]string:
+				return d.saveStringsMap(p, m)
+			}
+		}
+		// Decode into a copy, so that v is unmodified on error
+		tmp := reflect.MakeMap(typ.Elem())
+		copyMap(tmp, val.Elem())
+		if err := d.saveMap(p, tmp); err != nil {
+			return err
+		}
+		if val.Elem().IsNil() {
+			val.Elem().Set(tmp)
+		} else {
+			copyMap(val.Elem(), tmp)
+		}
+		return nil
+	case reflect.Struct:
+		if val.IsNil() {
+			return &TypeError{nil}
+		}
+		tmp := reflect.New(typ.Elem()).Elem()
+		tmp.Set(val.Elem())
+		if err := d.saveStruct(p, tmp, false); err != nil {
+			return err
+		}
+		val.Elem().Set(tmp)
+		return nil
+	}
+}
+
+// str converts b to a string, sharing its memory if the Decoder
+// was configured with ZeroCopy.
+func (d *Decoder) str(b []byte) string {
+	if d.zeroCopy && len(b) > 0 {
+		return unsafe.String(&b[0], len(b))
+	}
+	return string(b)
+}
+
+// saveStringMap is a fast path for decoding into a map[string]string,
+// which cannot fail after the check for repeated attributes. Keys are
+// always copied, as they outlive any single entry; only values share
+// memory with the line buffer under ZeroCopy.
+func (d *Decoder) saveStringMap(pairs []pair, m *map[string]string) error {
+	if d.havemulti {
+		return &TypeError{reflect.TypeOf(*m)}
+	}
+	if *m == nil {
+		*m = make(map[string]string, len(pairs))
+	}
+	for _, p := range pairs {
+		(*m)[string(p.attr)] = d.str(p.val)
+	}
+	return nil
+}
+
+// saveStringsMap is a fast path for decoding into a
+// map[string][]string. Keys are copied, as for saveStringMap.
+func (d *Decoder) saveStringsMap(pairs []pair, m *map[string][]string) error {
+	if *m == nil {
+		*m = make(map[string][]string, len(pairs))
+	}
+	for _, p := range pairs {
+		attr := string(p.attr)
+		(*m)[attr] = append((*m)[attr], d.str(p.val))
+	}
+	return nil
+}
+
+// storeKey stores attr in the map key k. Keys are never decoded with
+// ZeroCopy, as they outlive any single entry.
+func (d *Decoder) storeKey(k reflect.Value, attr []byte) error {
+	zeroCopy := d.zeroCopy
+	d.zeroCopy = false
+	defer func() { d.zeroCopy = zeroCopy }()
+	return d.storeVal(k, attr, "")
+}
 
+func copyMap(dst, src reflect.Value) {
+	iter := src.MapRange()
+	for iter.Next() {
+		dst.SetMapIndex(iter.Key(), iter.Value())
+	}
+}
+
+// decodeSlice decodes the remaining entries of the input into new
+// elements of the slice val points to. Elements of pointer type are
+// allocated as needed.
+func (d *Decoder) decodeSlice(val reflect.Value) error {
 	if val.Kind() != reflect.Ptr {
 		return &TypeError{val.Type()}
 	}
 	if val.Type().Elem().Kind() != reflect.Slice {
 		return &TypeError{val.Type()}
 	}
-	if val.Elem().IsNil() {
-		val.Elem().Set(reflect.MakeSlice(val.Type().Elem(), 0, 5))
-	}
-	add := reflect.New(val.Type().Elem().Elem())
-	for err = d.Decode(add.Interface()); err != nil; err = d.Decode(add.Interface()) {
-		s := reflect.Append(val.Elem(), add.Elem())
-		val.Elem().Set(s)
+	list := val.Elem()
+	et := list.Type().Elem()
+	for {
+		var add reflect.Value
+		if et.Kind() == reflect.Ptr {
+			add = reflect.New(et.Elem())
+		} else {
+			add = reflect.New(et)
+		}
+		err := d.Decode(add.Interface())
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if et.Kind() != reflect.Ptr {
+			add = add.Elem()
+		}
+		list = reflect.Append(list, add)
 	}
-	if err == io.EOF {
-		return nil
-	} else if err != nil {
-		return err
+	if list.IsNil() {
+		list = reflect.MakeSlice(list.Type(), 0, 0)
 	}
+	val.Elem().Set(list)
 	return nil
 }
 
+// isListType reports whether a value of type t holds one element for
+// each tuple decoded into it, rather than decoding a single value
+// itself, as a []byte or a type implementing Unmarshaler does.
+func isListType(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice || t.Elem().Kind() == reflect.Uint8 {
+		return false
+	}
+	pt := reflect.PtrTo(t)
+	return !pt.Implements(unmarshalerType) && !pt.Implements(textUnmarshalerType)
+}
+
 func (d *Decoder) saveMap(pairs []pair, val reflect.Value) error {
 	kv := reflect.New(val.Type().Key())
 
-	if d.havemulti {
+	if val.Type().Elem().Kind() == reflect.Interface {
+		// Repeated attributes are stored as a []interface{}
+		for _, p := range pairs {
+			if err := d.storeKey(kv, p.attr); err != nil {
+				return err
+			}
+			v, ok, err := d.hookVal(p, val.Type().Elem())
+			if err != nil {
+				if err := d.fail(fieldError(p, "", err)); err != nil {
+					return err
+				}
+				continue
+			} else if !ok {
+				v = reflect.ValueOf(inferValue(p.val))
+			}
+			if d.counts[string(p.attr)] > 1 {
+				slot := val.MapIndex(kv.Elem())
+				var list []interface{}
+				if slot.IsValid() {
+					list, _ = slot.Interface().([]interface{})
+				}
+				v = reflect.ValueOf(append(list, v.Interface()))
+			}
+			if !v.Type().AssignableTo(val.Type().Elem()) {
+				return &TypeError{val.Type()}
+			}
+			val.SetMapIndex(kv.Elem(), v)
+		}
+	} else if d.havemulti || isListType(val.Type().Elem()) {
 		if val.Type().Elem().Kind() != reflect.Slice {
 			return &TypeError{val.Type()}
 		}
 		vv := reflect.New(val.Type().Elem().Elem())
 		for _, p := range pairs {
-			if err := storeVal(kv, p.attr); err != nil {
+			if err := d.storeKey(kv, p.attr); err != nil {
 				return err
 			}
-			if err := storeVal(vv, p.val); err != nil {
-				return err
+			if err := d.storeTuple(vv, p, ""); err != nil {
+				if err := d.fail(fieldError(p, "", err)); err != nil {
+					return err
+				}
+				continue
 			}
 			slot := val.MapIndex(kv.Elem())
 			if slot.Kind() == reflect.Invalid {
@@ -108,11 +497,14 @@ func (d *Decoder) saveMap(pairs []pair, val reflect.Value) error {
 	} else {
 		vv := reflect.New(val.Type().Elem())
 		for _, p := range pairs {
-			if err := storeVal(kv, p.attr); err != nil {
+			if err := d.storeKey(kv, p.attr); err != nil {
 				return err
 			}
-			if err := storeVal(vv, p.val); err != nil {
-				return err
+			if err := d.storeTuple(vv, p, ""); err != nil {
+				if err := d.fail(fieldError(p, "", err)); err != nil {
+					return err
+				}
+				continue
 			}
 			val.SetMapIndex(kv.Elem(), vv.Elem())
 		}
@@ -120,246 +512,542 @@ func (d *Decoder) saveMap(pairs []pair, val reflect.Value) error {
 	return nil
 }
 
-func (d *Decoder) saveStruct(pairs []pair, val reflect.Value) error {
-	var tag string
-	typ := val.Type()
+// A structInfo describes how entries are decoded into a struct type.
+type structInfo struct {
+	fields   map[string]field // by attribute
+	subs     map[string]field // nested entries, by key attribute
+	raw      []field
+	required [][]string   // names of each required field
+	badRaw   reflect.Type // type of a raw field that cannot hold bytes
+	nested   reflect.Type // type of the first nested entry field
+}
+
+type structKey struct {
+	typ reflect.Type
+	tag string
+}
 
+// structCache maps a structKey to its *structInfo.
+var structCache sync.Map
+
+// cachedStructInfo returns the structInfo for typ, using the struct
+// tag key tag.
+func cachedStructInfo(typ reflect.Type, tag string) *structInfo {
+	key := structKey{typ, tag}
+	if si, ok := structCache.Load(key); ok {
+		return si.(*structInfo)
+	}
+	si, _ := structCache.LoadOrStore(key, newStructInfo(typ, tag, nil))
+	return si.(*structInfo)
+}
+
+// structInfo returns the structInfo for typ, with its attribute names
+// given by the Decoder's attribute mapper.
+func (d *Decoder) structInfo(typ reflect.Type) *structInfo {
+	if d.mapAttr == nil {
+		return cachedStructInfo(typ, d.tag)
+	}
+	if si, ok := d.structs[typ]; ok {
+		return si
+	}
+	if d.structs == nil {
+		d.structs = make(structInfos)
+	}
+	si := newStructInfo(typ, d.tag, d.mapAttr)
+	d.structs[typ] = si
+	return si
+}
+
+// newStructInfo returns the structInfo for typ, using the struct tag
+// key tagKey. If mapAttr is not nil, it is applied to each attribute.
+func newStructInfo(typ reflect.Type, tagKey string, mapAttr func(string) string) *structInfo {
+	si := &structInfo{fields: make(map[string]field)}
 	for i := 0; i < typ.NumField(); i++ {
-		field := typ.Field(i)
-		if !val.FieldByIndex(field.Index).CanSet() {
+		ft := typ.Field(i)
+		if !ft.IsExported() {
 			continue
 		}
-		tag = field.Tag.Get("ndb")
-		if tag != "" {
-			d.finfo[tag] = field.Index
-		} else {
-			d.finfo[field.Name] = field.Index
+		tag := ft.Tag.Get(tagKey)
+		if tag == "-" {
+			continue
+		}
+		name, opts := parseTag(tag)
+		if opts.Has("raw") {
+			if ft.Type.Kind() != reflect.Slice || ft.Type.Elem().Kind() != reflect.Uint8 {
+				if si.badRaw == nil {
+					si.badRaw = ft.Type
+				}
+				continue
+			}
+			si.raw = append(si.raw, field{ft.Name, ft.Index, opts})
+			continue
+		}
+		names := tagNames(name, ft.Name)
+		if mapAttr != nil {
+			for i := range names {
+				names[i] = mapAttr(names[i])
+			}
+		}
+		if et, ok := nestedType(ft.Type); ok {
+			if si.nested == nil {
+				si.nested = ft.Type
+			}
+			if key := keyAttr(et, tagKey); key != "" {
+				if mapAttr != nil {
+					key = mapAttr(key)
+				}
+				if si.subs == nil {
+					si.subs = make(map[string]field)
+				}
+				si.subs[key] = field{ft.Name, ft.Index, opts}
+			}
+			continue
+		}
+		for _, name := range names {
+			si.fields[name] = field{ft.Name, ft.Index, opts}
+		}
+		if opts.Has("required") {
+			si.required = append(si.required, names)
+		}
+	}
+	return si
+}
+
+func (d *Decoder) saveStruct(pairs []pair, val reflect.Value, nested bool) error {
+	si := d.structInfo(val.Type())
+	counts := d.counts
+	if nested {
+		counts = countPairs(pairs)
+		if si.nested != nil {
+			return &TypeError{si.nested}
+		}
+	}
+	if si.badRaw != nil {
+		return &TypeError{si.badRaw}
+	}
+	if !nested {
+		for _, fi := range si.raw {
+			val.FieldByIndex(fi.index).SetBytes(append([]byte(nil), d.line...))
+		}
+	}
+	if si.subs != nil {
+		var err error
+		if pairs, err = d.saveSubEntries(pairs, val, si.subs); err != nil {
+			return err
+		}
+		counts = countPairs(pairs)
+	}
+	var missing []string
+	for _, names := range si.required {
+		if !slices.ContainsFunc(names, func(name string) bool { return counts[name] > 0 }) {
+			missing = append(missing, names[0])
+		}
+	}
+	if missing != nil {
+		if err := d.fail(&MissingError{missing}); err != nil {
+			return err
 		}
 	}
 	for _, p := range pairs {
-		if id, ok := d.finfo[string(p.attr)]; ok {
-			f := val.FieldByIndex(id)
-			if _, ok := d.multi[string(p.attr)]; ok {
+		if fi, ok := si.fields[string(p.attr)]; ok {
+			f := val.FieldByIndex(fi.index)
+			if f.Kind() == reflect.Ptr && f.Type().Elem().Kind() == reflect.Slice {
+				// Append to a copy of the slice, which the
+				// caller's value may share
+				ptr := reflect.New(f.Type().Elem())
+				if !f.IsNil() {
+					ptr.Elem().Set(f.Elem())
+				}
+				f.Set(ptr)
+				f = ptr.Elem()
+			}
+			if sep, ok := fi.opts.listSep(); ok && f.Kind() == reflect.Slice {
+				if err := d.saveList(f, p, sep, fi.opts); err != nil {
+					if err := d.fail(fieldError(p, fi.name, err)); err != nil {
+						return err
+					}
+				}
+			} else if counts[string(p.attr)] > 1 || isListType(f.Type()) {
 				if f.Kind() != reflect.Slice {
 					return &TypeError{f.Type()}
 				}
 				add := reflect.New(f.Type().Elem())
-				if err := storeVal(add, p.val); err != nil {
-					return err
+				if err := d.storeTuple(add, p, fi.opts); err != nil {
+					if err := d.fail(fieldError(p, fi.name, err)); err != nil {
+						return err
+					}
+					continue
 				}
 				f.Set(reflect.Append(f, add.Elem()))
-			} else if err := storeVal(f, p.val); err != nil {
-				return err
+			} else if err := d.storeTuple(f, p, fi.opts); err != nil {
+				if err := d.fail(fieldError(p, fi.name, err)); err != nil {
+					return err
+				}
 			}
 		}
 	}
 	return nil
 }
 
-func storeVal(dst reflect.Value, src []byte) error {
-	if dst.Kind() == reflect.Ptr {
-		if dst.IsNil() {
-			dst.Set(reflect.New(dst.Type().Elem()))
+// saveList appends the elements of the list value of p, separated by
+// sep, to the slice f.
+func (d *Decoder) saveList(f reflect.Value, p pair, sep string, opts tagOptions) error {
+	if len(p.val) == 0 {
+		return nil
+	}
+	list := f
+	for _, item := range bytes.Split(p.val, []byte(sep)) {
+		add := reflect.New(f.Type().Elem())
+		if err := d.storeTuple(add, pair{p.attr, item}, opts); err != nil {
+			return err
+		}
+		list = reflect.Append(list, add.Elem())
+	}
+	f.Set(list)
+	return nil
+}
+
+// saveSubEntries stores each continuation line of the current entry
+// whose first attribute is a key in subs into the corresponding
+// nested struct field of val. It returns the remaining tuples.
+func (d *Decoder) saveSubEntries(pairs []pair, val reflect.Value, subs map[string]field) ([]pair, error) {
+	var own []pair
+	seen := make(map[string]bool)
+	for i := 0; i < len(pairs); {
+		n := d.lineAt(pairs, i)
+		j := i + 1
+		for j < len(pairs) && d.lineAt(pairs, j) == n {
+			j++
+		}
+		line := pairs[i:j]
+		key := string(line[0].attr)
+		if fi, ok := subs[key]; ok && n > 0 {
+			f := val.FieldByIndex(fi.index)
+			if f.Kind() == reflect.Slice {
+				add := reflect.New(f.Type().Elem())
+				if err := d.saveStruct(line, add.Elem(), true); err != nil {
+					return nil, err
+				}
+				f.Set(reflect.Append(f, add.Elem()))
+			} else if seen[key] {
+				return nil, &TypeError{f.Type()}
+			} else if err := d.saveStruct(line, f, true); err != nil {
+				return nil, err
+			}
+			seen[key] = true
+		} else {
+			own = append(own, line...)
+		}
+		i = j
+	}
+	return own, nil
+}
+
+// inferValue converts the value of a tuple to an int, float64, bool
+// or string, whichever it looks like. A bare attribute is true.
+// Numbers with leading zeros, such as zip codes or Ethernet addresses
+// made of digits, are kept as strings so that no digits are lost.
+func inferValue(src []byte) interface{} {
+	s := string(src)
+	switch {
+	case src == nil:
+		return true
+	case s == "true":
+		return true
+	case s == "false":
+		return false
+	case !isNumeric(s), hasLeadingZero(s):
+		return s
+	}
+	if i, err := strconv.ParseInt(s, 10, 0); err == nil {
+		return int(i)
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// hasLeadingZero reports whether the number s, after any sign,
+// begins with a zero followed by another digit.
+func hasLeadingZero(s string) bool {
+	s = strings.TrimLeft(s, "+-")
+	return len(s) > 1 && s[0] == '0' && '0' <= s[1] && s[1] <= '9'
+}
+
+// isNumeric reports whether s looks like a decimal number, as
+// opposed to, for instance, "inf" or a hexadecimal float.
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case '0' <= c && c <= '9', c == '.', c == 'e', c == 'E', c == '+', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func countPairs(pairs []pair) map[string]int {
+	counts := make(map[string]int, len(pairs))
+	for _, p := range pairs {
+		counts[string(p.attr)]++
+	}
+	return counts
+}
+
+// nestedType returns the struct type of a field holding a nested
+// entry, which is a struct or slice of structs that does not decode
+// or encode itself.
+func nestedType(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return nil, false
+	}
+	pt := reflect.PtrTo(t)
+	for _, it := range []reflect.Type{unmarshalerType, textUnmarshalerType, marshalerType, textMarshalerType} {
+		if t.Implements(it) || pt.Implements(it) {
+			return nil, false
+		}
+	}
+	return t, true
+}
+
+// keyAttr returns the attribute of the first field of the nested
+// entry type t, which identifies its lines.
+func keyAttr(t reflect.Type, key string) string {
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		tag := ft.Tag.Get(key)
+		if ft.PkgPath != "" || tag == "-" {
+			continue
+		}
+		if _, ok := nestedType(ft.Type); ok {
+			continue
+		}
+		name, _ := parseTag(tag)
+		return tagName(name, ft.Name)
+	}
+	return ""
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func (d *Decoder) storeVal(dst reflect.Value, src []byte, opts tagOptions) error {
+	for dst.Kind() == reflect.Ptr {
+		if dst.CanSet() {
+			// Store to a copy of the pointed-to value, which
+			// the caller's value may share
+			p := reflect.New(dst.Type().Elem())
+			if !dst.IsNil() {
+				p.Elem().Set(dst.Elem())
+			}
+			dst.Set(p)
 		}
 		dst = dst.Elem()
 	}
+	if d.weak && src != nil && len(src) == 0 {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if layout, ok := opts.Get("format"); ok && dst.Type() == timeType {
+		t, err := time.Parse(layout, string(src))
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if ok, err := storeNetip(dst, src); ok {
+		return err
+	}
+	if dst.CanAddr() {
+		switch u := dst.Addr().Interface().(type) {
+		case Unmarshaler:
+			return u.UnmarshalNDB(src)
+		case encoding.TextUnmarshaler:
+			return u.UnmarshalText(src)
+		}
+	}
+
+	if dst.Type() == durationType {
+		d, err := time.ParseDuration(string(src))
+		if err != nil {
+			return err
+		}
+		dst.SetInt(int64(d))
+		return nil
+	}
 
 	switch dst.Kind() {
 	default:
 		return &TypeError{dst.Type()}
+	case reflect.Interface:
+		v := reflect.ValueOf(inferValue(src))
+		if !v.Type().AssignableTo(dst.Type()) {
+			return &TypeError{dst.Type()}
+		}
+		dst.Set(v)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		itmp, err := strconv.ParseInt(string(src), 10, dst.Type().Bits())
+		s := string(src)
+		if d.weak {
+			s = weakNumber(s, true)
+		}
+		itmp, err := strconv.ParseInt(s, intBase(s), dst.Type().Bits())
 		if err != nil {
 			return err
 		}
 		dst.SetInt(itmp)
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		utmp, err := strconv.ParseUint(string(src), 10, dst.Type().Bits())
+		s := string(src)
+		if d.weak {
+			s = weakNumber(s, true)
+		}
+		utmp, err := strconv.ParseUint(s, intBase(s), dst.Type().Bits())
 		if err != nil {
 			return err
 		}
 		dst.SetUint(utmp)
 	case reflect.Float32, reflect.Float64:
-		ftmp, err := strconv.ParseFloat(string(src), dst.Type().Bits())
+		s := string(src)
+		if d.weak {
+			s = weakNumber(s, false)
+		}
+		ftmp, err := strconv.ParseFloat(s, dst.Type().Bits())
 		if err != nil {
 			return err
 		}
 		dst.SetFloat(ftmp)
 	case reflect.Bool:
-		value, err := strconv.ParseBool(strings.TrimSpace(string(src)))
+		// A bare attribute, such as trusted, states a fact
+		if len(bytes.TrimSpace(src)) == 0 {
+			dst.SetBool(true)
+			break
+		}
+		s := strings.TrimSpace(string(src))
+		if t, f, ok := opts.boolWords(); ok {
+			if strings.EqualFold(s, t) || strings.EqualFold(s, f) {
+				dst.SetBool(strings.EqualFold(s, t))
+				break
+			}
+		}
+		if d.weak {
+			if value, ok := weakBool(s); ok {
+				dst.SetBool(value)
+				break
+			}
+		}
+		value, err := parseBool(s)
 		if err != nil {
 			return err
 		}
 		dst.SetBool(value)
 	case reflect.String:
-		dst.SetString(string(src))
+		dst.SetString(d.str(src))
 	case reflect.Slice:
-		if len(src) == 0 {
-			src = []byte{}
+		if dst.Type().Elem().Kind() != reflect.Uint8 {
+			// A slice of slices, such as [][]string
+			return &TypeError{dst.Type()}
+		}
+		if opts.Has("hex") {
+			b, err := hex.AppendDecode(nil, src)
+			if err != nil {
+				return err
+			}
+			dst.SetBytes(b)
+			break
+		} else if opts.Has("base64") {
+			b, err := base64.StdEncoding.AppendDecode(nil, src)
+			if err != nil {
+				return err
+			}
+			dst.SetBytes(b)
+			break
 		}
-		dst.SetBytes(src)
+		// src is overwritten by the next line read
+		dst.SetBytes(append([]byte{}, src...))
 	}
 	return nil
 }
 
-type scanState []int
+// intBase returns the base in which to parse the integer s: 0, so
+// that strconv detects the base, if s has a 0x, 0o or 0b prefix, and
+// 10 otherwise, so that leading zeros do not make a number octal.
+func intBase(s string) int {
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	if len(s) > 2 && s[0] == '0' {
+		switch s[1] {
+		case 'x', 'X', 'o', 'O', 'b', 'B':
+			return 0
+		}
+	}
+	return 10
+}
 
-func (s *scanState) push(n int) {
-	*s = append(*s, n)
+// boolWords are the spellings of bool values accepted in addition
+// to those of strconv.ParseBool.
+var boolWords = map[string]bool{
+	"yes":     true,
+	"no":      false,
+	"on":      true,
+	"off":     false,
+	"enable":  true,
+	"disable": false,
 }
-func (s scanState) top() int {
-	if len(s) > 0 {
-		return s[len(s)-1]
+
+// parseBool parses a bool value spelled as strconv.ParseBool accepts,
+// or as yes/no, on/off or enable/disable, in any case.
+func parseBool(s string) (bool, error) {
+	if b, ok := boolWords[strings.ToLower(s)]; ok {
+		return b, nil
 	}
-	return scanNone
+	return strconv.ParseBool(s)
 }
-func (s *scanState) pop() int {
-	v := s.top()
-	if len(*s) > 0 {
-		*s = (*s)[0 : len(*s)-1]
+
+// weakBool parses the bool spellings accepted for weakly typed input.
+func weakBool(s string) (value, ok bool) {
+	s = strings.ToLower(s)
+	switch s {
+	case "y":
+		return true, true
+	case "n":
+		return false, true
 	}
-	return v
+	b, err := parseBool(s)
+	return b, err == nil
 }
 
-const (
-	scanNone = iota
-	scanAttr
-	scanValue
-	scanValueStart
-	scanQuoteStart
-	scanQuoteValue
-	scanQuoteClose
-)
-
-// This is the main tokenizing function. For now it's a messy state machine.
-// It could be cleaned up with better use of structures and methods, or
-// by copying Rob Pike's Go lexing talk.
-func (d *Decoder) parseLine(line []byte) ([]pair, error) {
-	var add pair
-	var beg, offset int64
-	var esc bool
-
-	state := make(scanState, 0, 3)
-	buf := bytes.NewReader(line)
-
-	for r, sz, err := buf.ReadRune(); err == nil; r, sz, err = buf.ReadRune() {
-		if r == 0xFFFD && sz == 1 {
-			return nil, errBadUnicode(line, offset)
-		}
-		switch state.top() {
-		case scanNone:
-			if unicode.IsSpace(r) {
-				// skip
-			} else if unicode.IsLetter(r) || unicode.IsNumber(r) {
-				state.push(scanAttr)
-				beg = offset
-			} else {
-				return nil, errBadAttr(line, offset)
-			}
-		case scanAttr:
-			if unicode.IsSpace(r) {
-				add.attr = line[beg:offset]
-				d.pairbuf = append(d.pairbuf, add)
-				if _, ok := d.attrs[string(add.attr)]; ok {
-					d.havemulti = true
-					d.multi[string(add.attr)] = struct{}{}
-				} else {
-					d.attrs[string(add.attr)] = struct{}{}
-				}
-				add.attr, add.val, esc = nil, nil, false
-				state.pop()
-			} else if r == '=' {
-				add.attr = line[beg:offset]
-				if _, ok := d.attrs[string(add.attr)]; ok {
-					d.havemulti = true
-					d.multi[string(add.attr)] = struct{}{}
-				} else {
-					d.attrs[string(add.attr)] = struct{}{}
-				}
-				state.pop()
-				state.push(scanValueStart)
-			} else if !(r == '-' || unicode.IsLetter(r) || unicode.IsNumber(r)) {
-				return nil, errBadAttr(line, offset)
-			}
-		case scanValueStart:
-			beg = offset
-			state.pop()
-			state.push(scanValue)
-
-			if r == '\'' {
-				state.push(scanQuoteStart)
-				break
-			}
-			fallthrough
-		case scanValue:
-			if unicode.IsSpace(r) {
-				state.pop()
-				add.val = line[beg:offset]
-				if esc {
-					add.val = bytes.Replace(add.val, []byte("''"), []byte("'"), -1)
-				}
-				d.pairbuf = append(d.pairbuf, add)
-				add.attr, add.val = nil, nil
-			}
-		case scanQuoteClose:
-			state.pop()
-			if r == '\'' {
-				esc = true
-				state.push(scanQuoteValue)
-			} else if unicode.IsSpace(r) {
-				state.pop()
-				add.val = line[beg : offset-1]
-				if esc {
-					add.val = bytes.Replace(add.val, []byte("''"), []byte("'"), -1)
-				}
-				d.pairbuf = append(d.pairbuf, add)
-				add.attr, add.val, esc = nil, nil, false
-			} else {
-				return nil, errMissingSpace(line, offset)
-			}
-		case scanQuoteStart:
-			state.pop()
-			if r != '\'' {
-				beg++
-				state.pop()
-				state.push(scanQuoteValue)
-			} else {
-				esc = true
-			}
-		case scanQuoteValue:
-			if r == '\'' {
-				state.pop()
-				state.push(scanQuoteClose)
-			} else if r == '\n' {
-				return nil, errUnterminated(line, offset)
-			}
+// weakNumber rewrites s for weakly typed input, so that bools become
+// 1 or 0 and, if integer is set, whole floats lose their exponent and
+// fraction.
+func weakNumber(s string, integer bool) string {
+	if b, ok := weakBool(s); ok {
+		if b {
+			return "1"
 		}
-		offset += int64(sz)
+		return "0"
 	}
-	switch state.top() {
-	case scanQuoteValue, scanQuoteStart:
-		return nil, errUnterminated(line, offset)
-	case scanAttr:
-		add.attr = line[beg:offset]
-		if _, ok := d.attrs[string(add.attr)]; ok {
-			d.havemulti = true
-			d.multi[string(add.attr)] = struct{}{}
-		} else {
-			d.attrs[string(add.attr)] = struct{}{}
-		}
-		d.pairbuf = append(d.pairbuf, add)
-	case scanValueStart:
-		beg = offset
-		fallthrough
-	case scanQuoteClose:
-		offset--
-		fallthrough
-	case scanValue:
-		add.val = line[beg:offset]
-		if esc {
-			add.val = bytes.Replace(add.val, []byte("''"), []byte("'"), -1)
-		}
-		d.pairbuf = append(d.pairbuf, add)
-	}
-	return d.pairbuf, nil
+	if integer {
+		if _, err := strconv.ParseInt(s, intBase(s), 64); err == nil {
+			return s
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return strconv.FormatInt(int64(f), 10)
+		}
+	}
+	return s
 }
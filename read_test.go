@@ -1,9 +1,18 @@
+//go:build !ndbnoreflect
+
 package ndb
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 type screenCfg struct {
@@ -207,3 +216,802 @@ func Test_parsing(t *testing.T) {
 func match(p1, p2 pair) bool {
 	return (bytes.Compare(p1.attr, p2.attr) == 0) && (bytes.Compare(p1.val, p2.val) == 0)
 }
+
+func TestDecoderMulti(t *testing.T) {
+	var net netCfg
+	d := NewDecoder(bytes.NewReader([]byte("host-name=a vlan=1 vlan=2\nhost-name=b native-vlan=3\n")))
+	if err := d.Decode(&net); err != nil {
+		t.Fatal(err)
+	}
+	if !d.HasMulti() || d.Count("vlan") != 2 || d.Count("host-name") != 1 {
+		t.Errorf("Got HasMulti=%v Count(vlan)=%d after %v", d.HasMulti(), d.Count("vlan"), net)
+	}
+	if err := d.Decode(&net); err != nil {
+		t.Fatal(err)
+	}
+	if d.HasMulti() || d.Count("vlan") != 0 || d.Count("host-name") != 1 {
+		t.Errorf("Got HasMulti=%v Count(vlan)=%d after %v", d.HasMulti(), d.Count("vlan"), net)
+	}
+}
+
+func TestUnmarshalWithTagName(t *testing.T) {
+	var v struct {
+		Host string `cfg:"sys"`
+		Port int    `ndb:"sys" cfg:"port"`
+	}
+	if err := UnmarshalWith([]byte("sys=fir port=80"), &v, TagName("cfg")); err != nil {
+		t.Fatal(err)
+	}
+	if v.Host != "fir" || v.Port != 80 {
+		t.Errorf("Got %+v, wanted Host=fir Port=80", v)
+	}
+}
+
+// A vlanID is encoded with a "v" prefix, such as v66.
+type vlanID int
+
+func (v vlanID) MarshalNDB() ([]byte, error) {
+	return []byte(fmt.Sprintf("v%d", int(v))), nil
+}
+
+func (v *vlanID) UnmarshalNDB(b []byte) error {
+	_, err := fmt.Sscanf(string(b), "v%d", (*int)(v))
+	return err
+}
+
+type switchPort struct {
+	Port   string   `ndb:"port"`
+	Vlan   []vlanID `ndb:"vlan"`
+	Native vlanID   `ndb:"native-vlan"`
+}
+
+func TestUnmarshaler(t *testing.T) {
+	var p switchPort
+	if err := Unmarshal([]byte("port=ge-0/0/1 vlan=v10 vlan=v20 native-vlan=v1"), &p); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(p) != "{ge-0/0/1 [10 20] 1}" {
+		t.Errorf("Got %v, wanted {ge-0/0/1 [10 20] 1}", p)
+	}
+	if err := Unmarshal([]byte("native-vlan=66"), &p); err == nil {
+		t.Errorf("Unmarshal accepted native-vlan=66, which UnmarshalNDB rejects")
+	}
+}
+
+type hostAddr struct {
+	Sys string `ndb:"sys"`
+	IP  net.IP `ndb:"ip"`
+}
+
+func TestTextUnmarshaler(t *testing.T) {
+	var h hostAddr
+	if err := Unmarshal([]byte("sys=fir ip=135.104.9.1"), &h); err != nil {
+		t.Fatal(err)
+	}
+	if !h.IP.Equal(net.IPv4(135, 104, 9, 1)) {
+		t.Errorf("Got %v, wanted ip 135.104.9.1", h)
+	}
+	if err := Unmarshal([]byte("sys=fir ip=bogus"), &h); err == nil {
+		t.Errorf("Unmarshal accepted ip=bogus")
+	}
+}
+
+type lease struct {
+	IP      string    `ndb:"ip"`
+	Expires time.Time `ndb:"expires,format=2006-01-02"`
+	Renewed time.Time `ndb:"renewed"`
+}
+
+func TestTime(t *testing.T) {
+	var l lease
+	if err := Unmarshal([]byte("ip=10.0.0.1 expires=2024-03-01 renewed=2024-02-01T10:00:00Z"), &l); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC); !l.Expires.Equal(want) {
+		t.Errorf("Got expires %v, wanted %v", l.Expires, want)
+	}
+	if want := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC); !l.Renewed.Equal(want) {
+		t.Errorf("Got renewed %v, wanted %v", l.Renewed, want)
+	}
+	if err := Unmarshal([]byte("expires=03/01/2024"), &l); err == nil {
+		t.Error("Unmarshal accepted a date not matching the format")
+	}
+}
+
+func TestTagOptions(t *testing.T) {
+	name, opts := parseTag("expires,format=Mon, 02 Jan 2006")
+	if name != "expires" {
+		t.Errorf("Got name %q, wanted expires", name)
+	}
+	if layout, ok := opts.Get("format"); !ok || layout != "Mon, 02 Jan 2006" {
+		t.Errorf("Got format %q, wanted the rest of the tag", layout)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	var v struct {
+		Timeout time.Duration `ndb:"timeout"`
+		Retry   []time.Duration
+	}
+	if err := Unmarshal([]byte("timeout=2h45m Retry=1s Retry=500ms"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Timeout != 2*time.Hour+45*time.Minute {
+		t.Errorf("Got timeout %v, wanted 2h45m", v.Timeout)
+	}
+	if len(v.Retry) != 2 || v.Retry[1] != 500*time.Millisecond {
+		t.Errorf("Got retry %v, wanted [1s 500ms]", v.Retry)
+	}
+	if err := Unmarshal([]byte("timeout=30"), &v); err == nil {
+		t.Error("Unmarshal accepted a duration without a unit")
+	}
+}
+
+func TestSkipField(t *testing.T) {
+	var v struct {
+		Sys   string `ndb:"sys"`
+		Cache string `ndb:"-"`
+	}
+	v.Cache = "keep"
+	if err := Unmarshal([]byte("sys=fir Cache=x"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Sys != "fir" || v.Cache != "keep" {
+		t.Errorf("Got %+v, wanted Sys=fir Cache=keep", v)
+	}
+}
+
+func TestRequired(t *testing.T) {
+	var v struct {
+		Sys  string `ndb:"sys,required"`
+		IP   string `ndb:"ip,required"`
+		Dom  string `ndb:"dom"`
+		Port int    `ndb:",required"`
+	}
+	err := Unmarshal([]byte("dom=fir.example.com ip=10.0.0.1"), &v)
+	missing, ok := err.(*MissingError)
+	if !ok {
+		t.Fatalf("Got error %v, wanted *MissingError", err)
+	}
+	if fmt.Sprint(missing.Attrs) != "[sys Port]" {
+		t.Errorf("Got missing %v, wanted [sys Port]", missing.Attrs)
+	}
+	if v.Dom != "" {
+		t.Errorf("Unmarshal modified v on error: %+v", v)
+	}
+	if err := Unmarshal([]byte("sys=fir ip=10.0.0.1 Port=80"), &v); err != nil {
+		t.Error(err)
+	}
+}
+
+const commentTest = `# Plan 9 style database
+#
+ipnet=murray-hill ip=135.104.0.0 ipmask=255.255.0.0 # the lab
+
+sys=fir	# a comment
+	ip=135.104.9.1
+# a comment between lines
+	dom=fir.example.com
+	# an indented comment
+sys=oak ip=135.104.9.2 url='http://oak/#top' frag=a#b
+`
+
+func TestComments(t *testing.T) {
+	d := NewDecoder(strings.NewReader(commentTest))
+	want := []string{
+		"[{ipnet murray-hill} {ip 135.104.0.0} {ipmask 255.255.0.0}]",
+		"[{sys fir} {ip 135.104.9.1} {dom fir.example.com}]",
+		"[{sys oak} {ip 135.104.9.2} {url http://oak/#top} {frag a#b}]",
+	}
+	for _, w := range want {
+		e, err := d.DecodeEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(e) != w {
+			t.Errorf("Got %v, wanted %s", e, w)
+		}
+	}
+	if _, err := d.DecodeEntry(); err != io.EOF {
+		t.Errorf("Got %v, wanted io.EOF", err)
+	}
+}
+
+func TestBareAttr(t *testing.T) {
+	var v struct {
+		Sys     string `ndb:"sys"`
+		Trusted bool   `ndb:"trusted"`
+		Bootf   string `ndb:"bootf"`
+		Dhcp    bool   `ndb:"dhcp"`
+		Proxy   bool   `ndb:"proxy"`
+	}
+	v.Bootf = "old"
+	if err := Unmarshal([]byte("sys=fir trusted bootf= dhcp"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Sys != "fir" || !v.Trusted || v.Bootf != "" || !v.Dhcp || v.Proxy {
+		t.Errorf("Got %+v", v)
+	}
+}
+
+func TestRawField(t *testing.T) {
+	type host struct {
+		Sys string   `ndb:"sys"`
+		Raw RawEntry `ndb:",raw"`
+	}
+	type bytesHost struct {
+		Sys string `ndb:"sys"`
+		Raw []byte `ndb:",raw"`
+	}
+	data := "sys=fir ip=10.0.0.2\n\tdom=fir.example.com\nsys=oak\n"
+	d := NewDecoder(strings.NewReader(data))
+	var h host
+	if err := d.Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	if want := "sys=fir ip=10.0.0.2\n\tdom=fir.example.com"; string(h.Raw) != want {
+		t.Errorf("Got %q, wanted %q", h.Raw, want)
+	}
+	var b bytesHost
+	if err := d.Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Sys != "oak" || string(b.Raw) != "sys=oak" {
+		t.Errorf("Got %+v", b)
+	}
+
+	out, err := Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "sys=fir" {
+		t.Errorf("Got %q, wanted raw field to be skipped", out)
+	}
+	out, err = Marshal(h.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != data[:len(h.Raw)] {
+		t.Errorf("Got %q, wanted entry to be written unmodified", out)
+	}
+}
+
+func TestInterfaceMap(t *testing.T) {
+	data := "sys=fir port=22 load=0.5 up=true trusted bootf= ip=10.0.0.2 dns=a dns=b ether=0011aabbccdd " +
+		"mac=001122334455 zip=02134 vlan=0 skew=-07"
+	want := map[string]interface{}{
+		"sys":     "fir",
+		"port":    22,
+		"load":    0.5,
+		"mac":     "001122334455",
+		"zip":     "02134",
+		"vlan":    0,
+		"skew":    "-07",
+		"up":      true,
+		"trusted": true,
+		"bootf":   "",
+		"ip":      "10.0.0.2",
+		"dns":     []interface{}{"a", "b"},
+		"ether":   "0011aabbccdd",
+	}
+	var m map[string]interface{}
+	if err := Unmarshal([]byte(data), &m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Got %#v, wanted %#v", m, want)
+	}
+	var v interface{}
+	if err := Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Got %#v, wanted %#v", v, want)
+	}
+}
+
+func TestFieldError(t *testing.T) {
+	var v struct {
+		Sys  string `ndb:"sys"`
+		Vlan int    `ndb:"vlan"`
+	}
+	err := Unmarshal([]byte("sys=fir vlan=abc"), &v)
+	fe, ok := err.(*FieldError)
+	if !ok {
+		t.Fatalf("Got %T %v, wanted *FieldError", err, err)
+	}
+	if fe.Attr != "vlan" || fe.Value != "abc" || fe.Field != "Vlan" {
+		t.Errorf("Got %+v", fe)
+	}
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("Got %v, wanted it to wrap strconv.ErrSyntax", err)
+	}
+
+	var m map[string]int
+	err = Unmarshal([]byte("a=1 b=x"), &m)
+	if fe, ok := err.(*FieldError); !ok || fe.Attr != "b" || fe.Field != "" {
+		t.Errorf("Got %#v, wanted *FieldError for b", err)
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	var v struct {
+		Sys  string `ndb:"sys,required"`
+		Vlan int    `ndb:"vlan"`
+		Port int    `ndb:"port"`
+		MTU  int    `ndb:"mtu"`
+	}
+	d := NewDecoder(strings.NewReader("vlan=abc port=22 mtu=big"))
+	d.ContinueOnError(true)
+	err := d.Decode(&v)
+	if err == nil {
+		t.Fatal("Got nil, wanted errors")
+	}
+	var attrs []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		switch e := e.(type) {
+		case *FieldError:
+			attrs = append(attrs, e.Attr)
+		case *MissingError:
+			attrs = append(attrs, e.Attrs...)
+		default:
+			t.Errorf("Unexpected error %v", e)
+		}
+	}
+	if want := "[sys vlan mtu]"; fmt.Sprint(attrs) != want {
+		t.Errorf("Got errors for %v, wanted %s", attrs, want)
+	}
+	if v.Port != 22 {
+		t.Errorf("Got port %d, wanted 22", v.Port)
+	}
+}
+
+func TestDecodeAtomic(t *testing.T) {
+	type host struct {
+		Sys  string  `ndb:"sys"`
+		Dom  *string `ndb:"dom"`
+		Vlan []int   `ndb:"vlan"`
+		MTU  int     `ndb:"mtu"`
+	}
+	dom := "old.example.com"
+	v := host{"old", &dom, []int{1, 2}, 1500}
+	err := Unmarshal([]byte("sys=fir dom=fir.example.com vlan=3 vlan=4 mtu=big"), &v)
+	if err == nil {
+		t.Fatal("Got nil, wanted error")
+	}
+	if v.Sys != "old" || dom != "old.example.com" || v.Dom != &dom ||
+		fmt.Sprint(v.Vlan) != "[1 2]" || v.MTU != 1500 {
+		t.Errorf("Got %+v (dom=%s), wanted it unmodified", v, *v.Dom)
+	}
+
+	m := map[string]int{"a": 1}
+	if err := Unmarshal([]byte("a=2 b=x"), &m); err == nil {
+		t.Fatal("Got nil, wanted error")
+	}
+	if !reflect.DeepEqual(m, map[string]int{"a": 1}) {
+		t.Errorf("Got %v, wanted it unmodified", m)
+	}
+	if err := Unmarshal([]byte("b=3"), &m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[string]int{"a": 1, "b": 3}) {
+		t.Errorf("Got %v, wanted a=1 b=3", m)
+	}
+}
+
+func TestStructInfoCache(t *testing.T) {
+	type host struct {
+		Sys  string `ndb:"sys" cfg:"name"`
+		Port int    `ndb:"port,required"`
+	}
+	typ := reflect.TypeOf(host{})
+	si := cachedStructInfo(typ, "ndb")
+	if cachedStructInfo(typ, "ndb") != si {
+		t.Error("struct info was not cached")
+	}
+	if _, ok := si.fields["sys"]; !ok || fmt.Sprint(si.required) != "[[port]]" {
+		t.Errorf("Got %+v", si)
+	}
+	if _, ok := cachedStructInfo(typ, "cfg").fields["name"]; !ok {
+		t.Error("struct info not keyed by tag name")
+	}
+}
+
+func BenchmarkDecodeStruct(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, "host-name=p2-jbs%d vlan=64 vlan=52 vlan=100 native-vlan=666\n", i)
+	}
+	data := buf.Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := NewDecoder(bytes.NewReader(data))
+		for d.More() {
+			var v netCfg
+			if err := d.Decode(&v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestZeroCopy(t *testing.T) {
+	type host struct {
+		Sys string `ndb:"sys"`
+		Dom string `ndb:"dom"`
+	}
+	d := NewDecoderWith(strings.NewReader("sys=fir dom=fir.example.com\nsys=oak dom=oak.example.com\n"), ZeroCopy())
+	var h host
+	if err := d.Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Sys != "fir" || h.Dom != "fir.example.com" {
+		t.Errorf("Got %+v", h)
+	}
+	if !d.More() {
+		t.Fatal("More returned false")
+	}
+	if h.Sys != "fir" || h.Dom != "fir.example.com" {
+		t.Errorf("Got %+v after More", h)
+	}
+	sys := strings.Clone(h.Sys)
+	n := testing.AllocsPerRun(10, func() {
+		d.Reset(strings.NewReader("sys=pine dom=pine.example.com\n"))
+		d.Decode(&h)
+	})
+	if sys != "fir" || h.Sys != "pine" {
+		t.Errorf("Got %q and %+v", sys, h)
+	}
+	t.Logf("%v allocations per Decode", n)
+}
+
+func TestUnmarshalString(t *testing.T) {
+	var v netCfg
+	if err := UnmarshalString("host-name=p2 vlan=1 vlan=2 native-vlan=3", &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Host != "p2" || fmt.Sprint(v.Vlan) != "[1 2]" || v.Native != 3 {
+		t.Errorf("Got %+v", v)
+	}
+}
+
+func TestStringMaps(t *testing.T) {
+	var m map[string]string
+	if err := Unmarshal([]byte("sys=fir dom='fir example'"), &m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[string]string{"sys": "fir", "dom": "fir example"}) {
+		t.Errorf("Got %v", m)
+	}
+	if err := Unmarshal([]byte("ip=1 ip=2"), &m); err == nil {
+		t.Error("Got nil, wanted error for repeated attribute")
+	}
+	var mm map[string][]string
+	if err := Unmarshal([]byte("sys=fir ip=1 ip=2"), &mm); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"sys": {"fir"}, "ip": {"1", "2"}}
+	if !reflect.DeepEqual(mm, want) {
+		t.Errorf("Got %v, wanted %v", mm, want)
+	}
+}
+
+func TestZeroCopyMapKeys(t *testing.T) {
+	m := make(map[string]string)
+	d := NewDecoderWith(strings.NewReader("sys=fir dom=fir.example.com\ndom=oak.example.com sys=oak\n"), ZeroCopy())
+	for i := 0; i < 2; i++ {
+		if err := d.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := map[string]string{"sys": "oak", "dom": "oak.example.com"}; !reflect.DeepEqual(m, want) {
+		t.Errorf("Got %v, wanted %v", m, want)
+	}
+	mi := make(map[string]int)
+	d = NewDecoderWith(strings.NewReader("a=1 b=2\nb=3 a=4\n"), ZeroCopy())
+	for i := 0; i < 2; i++ {
+		if err := d.Decode(&mi); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := map[string]int{"a": 4, "b": 3}; !reflect.DeepEqual(mi, want) {
+		t.Errorf("Got %v, wanted %v", mi, want)
+	}
+}
+
+func BenchmarkDecodeStringMap(b *testing.B) {
+	line := []byte("sys=p2-jbs239 dom=p2-jbs239.example.com ip=10.0.4.239 ether=0011aabbccdd bootf=/386/9pxeload")
+	for i := 0; i < b.N; i++ {
+		var m map[string]string
+		if err := Unmarshal(line, &m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeHook(t *testing.T) {
+	var host struct {
+		Sys  string     `ndb:"sys"`
+		DHCP bool       `ndb:"dhcp"`
+		Net  *net.IPNet `ndb:"net"`
+	}
+	d := NewDecoder(strings.NewReader("sys=fir dhcp=on net=10.0.0.0/8\n"))
+	d.SetDecodeHook(func(attr string, val []byte, target reflect.Type) (interface{}, bool, error) {
+		switch {
+		case target.Kind() == reflect.Bool && string(val) == "on":
+			return true, true, nil
+		case target == reflect.TypeOf((*net.IPNet)(nil)):
+			_, n, err := net.ParseCIDR(string(val))
+			return n, true, err
+		}
+		return nil, false, nil
+	})
+	if err := d.Decode(&host); err != nil {
+		t.Fatal(err)
+	}
+	if host.Sys != "fir" || !host.DHCP || host.Net == nil || host.Net.String() != "10.0.0.0/8" {
+		t.Errorf("Got %+v", host)
+	}
+
+	var m map[string]string
+	d = NewDecoder(strings.NewReader("sys=fir\n"))
+	d.SetDecodeHook(func(attr string, val []byte, target reflect.Type) (interface{}, bool, error) {
+		return 1, true, nil
+	})
+	if err := d.Decode(&m); err == nil {
+		t.Errorf("Got %v, wanted error for unassignable hook result", m)
+	}
+}
+
+func TestWeaklyTypedInput(t *testing.T) {
+	type host struct {
+		DHCP    bool    `ndb:"dhcp"`
+		Trusted bool    `ndb:"trusted"`
+		Port    int     `ndb:"port"`
+		Mask    uint32  `ndb:"mask"`
+		Weight  float64 `ndb:"weight"`
+		TTL     int     `ndb:"ttl"`
+		Name    string  `ndb:"name"`
+	}
+	input := "dhcp=YES trusted port=8e1 mask=0xff000000 weight=on ttl= name=42"
+	var h host
+	if err := UnmarshalWith([]byte(input), &h, WeaklyTypedInput()); err != nil {
+		t.Fatal(err)
+	}
+	want := host{DHCP: true, Trusted: true, Port: 80, Mask: 0xff000000, Weight: 1, Name: "42"}
+	if h != want {
+		t.Errorf("Got %+v, wanted %+v", h, want)
+	}
+	if err := Unmarshal([]byte(input), &h); err == nil {
+		t.Error("Got nil, wanted error without WeaklyTypedInput")
+	}
+	h = host{DHCP: true}
+	if err := UnmarshalWith([]byte("dhcp="), &h, WeaklyTypedInput()); err != nil {
+		t.Fatal(err)
+	} else if h.DHCP {
+		t.Error("Got true, wanted empty value to store false")
+	}
+}
+
+func TestBoolSpellings(t *testing.T) {
+	type iface struct {
+		DHCP  bool `ndb:"dhcp,bool=yes|no"`
+		Up    bool `ndb:"link,bool=up|down"`
+		Route bool `ndb:"route"`
+		IPv6  bool `ndb:"ipv6"`
+	}
+	var v iface
+	if err := Unmarshal([]byte("dhcp=Yes link=up route=on ipv6=enable"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if want := (iface{true, true, true, true}); v != want {
+		t.Errorf("Got %+v, wanted %+v", v, want)
+	}
+	if err := Unmarshal([]byte("dhcp=no link=down route=OFF ipv6=disable"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v != (iface{}) {
+		t.Errorf("Got %+v, wanted all false", v)
+	}
+	if err := Unmarshal([]byte("route=down"), &v); err == nil {
+		t.Error("Got nil, wanted error for unknown spelling")
+	}
+	b, err := Marshal(iface{DHCP: true, Up: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "dhcp=yes link=down route=false ipv6=false"; string(b) != want {
+		t.Errorf("Got %s, wanted %s", b, want)
+	}
+}
+
+func TestDecodeSlices(t *testing.T) {
+	type host struct {
+		Sys   string    `ndb:"sys"`
+		IP    []*string `ndb:"ip"`
+		Ports *[]int    `ndb:"port"`
+		DNS   []string  `ndb:"dns"`
+	}
+	input := "sys=fir ip=10.0.0.1 port=22 dns=a\nsys=oak ip=10.0.0.2 ip=10.0.0.3 port=80 port=443\n"
+	var hosts []*host
+	if err := Unmarshal([]byte(input), &hosts); err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("Got %d hosts, wanted 2", len(hosts))
+	}
+	if h := hosts[0]; h.Sys != "fir" || len(h.IP) != 1 || *h.IP[0] != "10.0.0.1" ||
+		h.Ports == nil || !reflect.DeepEqual(*h.Ports, []int{22}) || !reflect.DeepEqual(h.DNS, []string{"a"}) {
+		t.Errorf("Got %+v", h)
+	}
+	if h := hosts[1]; h.Sys != "oak" || len(h.IP) != 2 || *h.IP[1] != "10.0.0.3" ||
+		!reflect.DeepEqual(*h.Ports, []int{80, 443}) {
+		t.Errorf("Got %+v", h)
+	}
+
+	var maps []map[string]string
+	if err := Unmarshal([]byte(input), &maps); err == nil {
+		t.Error("Got nil, wanted error for repeated attributes")
+	}
+	if err := Unmarshal([]byte("sys=fir\nsys=oak\n"), &maps); err != nil {
+		t.Fatal(err)
+	}
+	if len(maps) != 2 || maps[0]["sys"] != "fir" || maps[1]["sys"] != "oak" {
+		t.Errorf("Got %v, wanted separate maps for each entry", maps)
+	}
+
+	var ports map[string][]int
+	if err := Unmarshal([]byte("port=22"), &ports); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ports, map[string][]int{"port": {22}}) {
+		t.Errorf("Got %v", ports)
+	}
+}
+
+func TestAttrMapper(t *testing.T) {
+	type iface struct {
+		Name string
+		MTU  int
+	}
+	type host struct {
+		Sys       string
+		IP        []string
+		HostName  string `ndb:"host-name"`
+		Boot_File string
+		Ifaces    []iface
+	}
+	kebab := func(s string) string {
+		return strings.ToLower(strings.ReplaceAll(s, "_", "-"))
+	}
+	input := "SYS=fir IP=10.0.0.1 ip=10.0.0.2 Host-Name=fir.example.com boot-file=/386/9pxeload\n\tname=eth0 mtu=1500\n"
+	d := NewDecoder(strings.NewReader(input))
+	d.SetAttrMapper(kebab)
+	var h host
+	if err := d.Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	want := host{"fir", []string{"10.0.0.1", "10.0.0.2"}, "fir.example.com", "/386/9pxeload", []iface{{"eth0", 1500}}}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("Got %+v, wanted %+v", h, want)
+	}
+	if d.Count("ip") != 2 || !d.HasMulti() {
+		t.Errorf("Got Count(ip)=%d HasMulti=%v, wanted 2 true", d.Count("ip"), d.HasMulti())
+	}
+
+	d = NewDecoderWith(strings.NewReader(input), AttrMapper(kebab))
+	lines, err := d.DecodeLines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0][3].Attr != "host-name" || lines[1][0].Attr != "name" {
+		t.Errorf("Got %v", lines)
+	}
+
+	b, err := MarshalWith(h, AttrMapper(kebab))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "sys=fir ip=10.0.0.1 ip=10.0.0.2 host-name=fir.example.com boot-file=/386/9pxeload") {
+		t.Errorf("Got %s", b)
+	}
+	var h2 host
+	if err := UnmarshalWith(b, &h2, AttrMapper(kebab)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h2, want) {
+		t.Errorf("Got %+v, wanted %+v", h2, want)
+	}
+}
+
+func TestTagAliases(t *testing.T) {
+	type host struct {
+		Name string   `ndb:"host-name hostname sys,required"`
+		IP   []string `ndb:"ip addr"`
+	}
+	for _, input := range []string{
+		"host-name=fir ip=10.0.0.1 addr=10.0.0.2",
+		"hostname=fir addr=10.0.0.1 addr=10.0.0.2",
+		"sys=fir ip=10.0.0.1 ip=10.0.0.2",
+	} {
+		var h host
+		if err := UnmarshalString(input, &h); err != nil {
+			t.Errorf("%s: %v", input, err)
+			continue
+		}
+		if h.Name != "fir" || len(h.IP) != 2 {
+			t.Errorf("Got %+v from %s", h, input)
+		}
+	}
+	var h host
+	err := UnmarshalString("ip=10.0.0.1", &h)
+	var merr *MissingError
+	if !errors.As(err, &merr) || fmt.Sprint(merr.Attrs) != "[host-name]" {
+		t.Errorf("Got %v, wanted missing host-name", err)
+	}
+	b, err := Marshal(host{"fir", []string{"10.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "host-name=fir ip=10.0.0.1" {
+		t.Errorf("Got %s, wanted host-name=fir ip=10.0.0.1", b)
+	}
+}
+
+func TestBlankLineEntries(t *testing.T) {
+	type host struct {
+		Sys string
+		IP  []string `ndb:"ip"`
+		Dom string   `ndb:"dom"`
+	}
+	input := "# hosts\nSys=fir\nip=10.0.0.1\nip=10.0.0.2\n\n\nSys=oak\n\tdom=oak.example.com\n"
+	d := NewDecoderWith(strings.NewReader(input), BlankLineEntries())
+	var hosts []host
+	if err := d.Decode(&hosts); err != nil {
+		t.Fatal(err)
+	}
+	want := []host{
+		{"fir", []string{"10.0.0.1", "10.0.0.2"}, ""},
+		{"oak", nil, "oak.example.com"},
+	}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("Got %+v, wanted %+v", hosts, want)
+	}
+	b, err := MarshalWith(hosts, BlankLineEntries())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "ip=10.0.0.2 dom=\n\nSys=oak") {
+		t.Errorf("Got %q, wanted entries separated by a blank line", b)
+	}
+}
+
+func TestBytesNotShared(t *testing.T) {
+	var hosts []struct {
+		Sys string
+		Key []byte
+	}
+	if err := Unmarshal([]byte("Sys=a Key=aaaa\nSys=b Key=bbbb\nSys=c Key=cccc"), &hosts); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, h := range hosts {
+		keys = append(keys, string(h.Key))
+	}
+	if want := "[aaaa bbbb cccc]"; fmt.Sprint(keys) != want {
+		t.Errorf("Got keys %v, wanted %s", keys, want)
+	}
+}
+
+func TestNestedSlices(t *testing.T) {
+	var v struct {
+		X [][]string
+		Y []*[]int `ndb:"y"`
+	}
+	for _, input := range []string{"X=a X=b", "X=a", "y=1 y=2", "y=1"} {
+		var te *TypeError
+		if err := Unmarshal([]byte(input), &v); !errors.As(err, &te) {
+			t.Errorf("%s: Got %v, wanted *TypeError", input, err)
+		}
+	}
+}
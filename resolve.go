@@ -0,0 +1,117 @@
+package ndb
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// A HostResolver looks up host names and addresses. It is
+// satisfied by *net.Resolver as well as *Resolver.
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+	LookupAddr(ctx context.Context, addr string) (names []string, err error)
+}
+
+// A Resolver answers host name and address queries from the
+// entries in a Database, in the manner of Plan 9's ndb lookups
+// preceding DNS. Hosts are named by their sys= and dom= attributes,
+// which are compared without regard to case, as in DNS, and addressed
+// by their ip= attributes. Answers from DB are cached if
+// DB.CacheResults has been called.
+type Resolver struct {
+	DB *Database
+
+	// Fallback, if non-nil, is consulted for queries that
+	// cannot be answered from DB, such as net.DefaultResolver.
+	Fallback HostResolver
+}
+
+// LookupHost returns the ip= values of every entry whose sys= or
+// dom= attribute matches host. If ctx is done before the entries
+// have been searched, its error is returned.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, err := r.DB.lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+	if r.Fallback != nil {
+		return r.Fallback.LookupHost(ctx, host)
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// LookupAddr returns the names of every entry with an ip= attribute
+// matching addr. Domain names from dom= attributes are preferred;
+// the sys= name is used for entries without one. If ctx is done
+// before the entries have been searched, its error is returned.
+func (r *Resolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	names, err := r.DB.lookupAddr(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		return names, nil
+	}
+	if r.Fallback != nil {
+		return r.Fallback.LookupAddr(ctx, addr)
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+// lookupHost returns the ip= values of every entry whose sys= or dom=
+// attribute matches host, ignoring case.
+func (db *Database) lookupHost(ctx context.Context, host string) ([]string, error) {
+	db.rlock()
+	defer db.mu.RUnlock()
+	return cachedQuery(db.cache, db.metrics, "host\x00"+strings.ToLower(host), func() ([]string, error) {
+		var addrs []string
+		for _, e := range db.entries {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if hasFold(e, "sys", host) || hasFold(e, "dom", host) {
+				addrs = append(addrs, e.GetAll("ip")...)
+			}
+		}
+		return addrs, nil
+	})
+}
+
+// lookupAddr returns the names of every entry with an ip= attribute
+// matching addr.
+func (db *Database) lookupAddr(ctx context.Context, addr string) ([]string, error) {
+	db.rlock()
+	defer db.mu.RUnlock()
+	return cachedQuery(db.cache, db.metrics, "addr\x00"+addr, func() ([]string, error) {
+		var names []string
+		for _, e := range db.entries {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if !e.has("ip", addr) {
+				continue
+			}
+			if dom := e.GetAll("dom"); len(dom) > 0 {
+				names = append(names, dom...)
+			} else {
+				names = append(names, e.GetAll("sys")...)
+			}
+		}
+		return names, nil
+	})
+}
+
+// hasFold reports whether e contains a tuple with the attribute attr
+// whose value matches val without regard to case.
+func hasFold(e Entry, attr, val string) bool {
+	for _, p := range e {
+		if p.Attr == attr && strings.EqualFold(p.Val, val) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package ndb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+type staticResolver map[string][]string
+
+func (s staticResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return s[host], nil
+}
+
+func (s staticResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return s[addr], nil
+}
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+	r := &Resolver{DB: openTestDB(t)}
+
+	if addrs, err := r.LookupHost(ctx, "oak"); err != nil {
+		t.Error(err)
+	} else if fmt.Sprint(addrs) != "[135.104.9.2 135.104.9.3]" {
+		t.Errorf("LookupHost(oak) = %v", addrs)
+	}
+	if addrs, err := r.LookupHost(ctx, "fir.example.com"); err != nil {
+		t.Error(err)
+	} else if fmt.Sprint(addrs) != "[135.104.9.1]" {
+		t.Errorf("LookupHost(fir.example.com) = %v", addrs)
+	}
+	if names, err := r.LookupAddr(ctx, "135.104.9.3"); err != nil {
+		t.Error(err)
+	} else if fmt.Sprint(names) != "[oak.example.com]" {
+		t.Errorf("LookupAddr(135.104.9.3) = %v", names)
+	}
+	_, err := r.LookupHost(ctx, "elm")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("LookupHost(elm) returned %v, wanted not found", err)
+	}
+
+	r.Fallback = staticResolver{"elm": {"10.0.0.1"}}
+	if addrs, err := r.LookupHost(ctx, "elm"); err != nil {
+		t.Error(err)
+	} else if fmt.Sprint(addrs) != "[10.0.0.1]" {
+		t.Errorf("LookupHost(elm) with fallback = %v", addrs)
+	}
+}
+
+func TestResolverFold(t *testing.T) {
+	r := &Resolver{DB: openTestDB(t)}
+	if addrs, err := r.LookupHost(context.Background(), "FIR.Example.COM"); err != nil {
+		t.Error(err)
+	} else if fmt.Sprint(addrs) != "[135.104.9.1]" {
+		t.Errorf("LookupHost(FIR.Example.COM) = %v", addrs)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.LookupHost(ctx, "oak"); err != context.Canceled {
+		t.Errorf("LookupHost with canceled context returned %v", err)
+	}
+	if _, err := r.LookupAddr(ctx, "135.104.9.3"); err != context.Canceled {
+		t.Errorf("LookupAddr with canceled context returned %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package ndb
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+)
+
+// LockFile makes Database.WriteFile and Database.Save hold an
+// exclusive advisory lock, with flock(2), on the file named by the
+// destination path with ".lock" appended while they write, so that
+// cooperating writers do not overwrite each other's changes. It is
+// ignored by Encoders and Decoders. Where flock is unavailable,
+// writing fails with errors.ErrUnsupported.
+func LockFile() Option {
+	return func(c *config) {
+		c.lock = true
+	}
+}
+
+// WriteFile writes the entries in the Database to the file at path,
+// one per line. The entries are written to a temporary file in the
+// same directory, which is synced to disk and renamed over path, so
+// that concurrent readers see either the old file or the new one,
+// never a partial write. An existing file's permissions are kept. If
+// entries with syntax errors were skipped when a Database opened with
+// MapFile was parsed, WriteFile returns the first error and writes
+// nothing, as the entries would otherwise be lost.
+func (db *Database) WriteFile(path string, opts ...Option) error {
+	if newConfig(opts).lock {
+		unlock, err := lockPath(path + ".lock")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	mode := os.FileMode(0664)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	dir, name := filepath.Split(path)
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	db.rlock()
+	defer db.mu.RUnlock()
+	if db.skipped != nil {
+		return db.skipped
+	}
+	w := bufio.NewWriter(tmp)
+	var line []byte
+	for _, e := range db.entries {
+		if line, err = AppendEntry(line[:0], e); err != nil {
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Save writes the Database back to the file it was opened from, as
+// WriteFile does.
+func (db *Database) Save(opts ...Option) error {
+	db.mu.RLock()
+	path := db.path
+	db.mu.RUnlock()
+	if path == "" {
+		return errNoPath
+	}
+	return db.WriteFile(path, opts...)
+}
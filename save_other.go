@@ -0,0 +1,13 @@
+//go:build !unix
+
+package ndb
+
+import "errors"
+
+func lockPath(path string) (func() error, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func syncDir(dir string) error {
+	return nil
+}
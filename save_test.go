@@ -0,0 +1,39 @@
+package ndb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local")
+	if err := os.WriteFile(path, []byte(testDB), 0640); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.entries = append(db.entries, Entry{{"sys", "elm"}, {"dom", "elm example"}})
+	if err := db.Save(LockFile()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testDB + "sys=elm dom='elm example'\n"; string(b) != want {
+		t.Errorf("Got %q, wanted %q", b, want)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("Got %v, %v, wanted mode 0640", fi.Mode(), err)
+	}
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".local.tmp*"))
+	if len(files) != 0 {
+		t.Errorf("Temporary files left behind: %v", files)
+	}
+	if err := new(Database).Save(); err == nil {
+		t.Error("Got nil, wanted error saving a Database without a path")
+	}
+}
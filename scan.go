@@ -0,0 +1,522 @@
+package ndb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+type pair struct {
+	attr, val []byte
+}
+
+func (p pair) String() string {
+	return string(p.attr) + " => " + string(p.val)
+}
+
+// The kinds of syntax error. A *SyntaxError wraps one of these, so
+// they may be tested for with errors.Is.
+var (
+	ErrBadAttribute      = errors.New("Invalid attribute name")
+	ErrUnterminatedQuote = errors.New("Unterminated quoted string")
+	ErrInvalidUTF8       = errors.New("Invalid UTF8 input")
+	ErrMissingSpace      = errors.New("Missing white space between tuples")
+)
+
+// The errors returned when input exceeds the limits set on a
+// Decoder.
+var (
+	ErrLineTooLong   = errors.New("Line too long")
+	ErrTooManyTuples = errors.New("Too many tuples")
+	ErrValueTooLong  = errors.New("Value too long")
+)
+
+var errNoSeek = errors.New("ndb: Decoder input does not implement io.Seeker")
+
+func syntaxError(line []byte, offset int64, kind error) error {
+	return &SyntaxError{Data: line, Offset: offset, Message: kind.Error(), Err: kind}
+}
+
+func errBadAttr(line []byte, offset int64) error {
+	return syntaxError(line, offset, ErrBadAttribute)
+}
+func errUnterminated(line []byte, offset int64) error {
+	return syntaxError(line, offset, ErrUnterminatedQuote)
+}
+func errBadUnicode(line []byte, offset int64) error {
+	return syntaxError(line, offset, ErrInvalidUTF8)
+}
+func errMissingSpace(line []byte, offset int64) error {
+	return syntaxError(line, offset, ErrMissingSpace)
+}
+
+// readLine returns the next logical line from the input. A logical
+// line is a line followed by any continuation lines, which begin with
+// white space; they are joined with their new lines intact. Blank
+// lines and lines consisting only of a comment are skipped. The
+// returned slice is only valid until the next call to readLine.
+func (d *Decoder) readLine() ([]byte, error) {
+	if d.peeked {
+		d.peeked = false
+		if d.peekErr != nil {
+			return nil, d.peekErr
+		}
+		return d.linebuf, nil
+	}
+	var err error
+	d.linebuf = d.linebuf[:0]
+	for len(d.linebuf) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		d.start = d.offset
+		d.spans = append(d.spans[:0], span{0, d.offset, d.lineno + 1})
+		d.linebuf, err = d.appendPhysLine(d.linebuf)
+		if err == ErrLineTooLong {
+			if !isBlank(d.linebuf) {
+				return nil, d.skipContinued()
+			}
+			// A comment line belongs to no entry
+			err = nil
+		}
+		if isBlank(d.linebuf) {
+			d.linebuf = d.linebuf[:0]
+		}
+	}
+	for err == nil {
+		next, perr := d.src.Peek(1)
+		if perr != nil {
+			break
+		}
+		switch c := next[0]; {
+		case c == ' ' || c == '\t' || d.paragraphs && c != '#' && c != '\n' && c != '\r':
+			n := len(d.linebuf)
+			d.linebuf = append(d.linebuf, '\n')
+			d.spans = append(d.spans, span{len(d.linebuf), d.offset, d.lineno + 1})
+			d.linebuf, err = d.appendPhysLine(d.linebuf)
+			if err == ErrLineTooLong {
+				return nil, d.skipContinued()
+			}
+			if d.paragraphs && len(bytes.TrimSpace(d.linebuf[n:])) == 0 {
+				// A blank line ends the entry
+				d.linebuf, d.spans = d.linebuf[:n], d.spans[:len(d.spans)-1]
+				d.end = d.offset
+				return d.linebuf, err
+			}
+		case c == '#':
+			d.scratch, err = d.appendPhysLine(d.scratch[:0])
+			if err == ErrLineTooLong {
+				err = nil
+			}
+		default:
+			d.end = d.offset
+			return d.linebuf, nil
+		}
+	}
+	d.end = d.offset
+	return d.linebuf, nil
+}
+
+// appendPhysLine appends the next line of input to buf, without
+// its line terminator. If buf would grow past the Decoder's line
+// limit, the rest of the line is consumed but not appended, and
+// appendPhysLine returns ErrLineTooLong.
+func (d *Decoder) appendPhysLine(buf []byte) ([]byte, error) {
+	var n int
+	var long bool
+	for {
+		line, err := d.src.ReadSlice('\n')
+		d.offset += int64(len(line))
+		n += len(line)
+		if !long {
+			buf = append(buf, line...)
+		}
+		if err == bufio.ErrBufferFull {
+			// allow for a carriage return
+			long = long || d.maxLine > 0 && len(buf) > d.maxLine+1
+			continue
+		}
+		if n > 0 {
+			d.lineno++
+		}
+		if n := len(buf); n > 0 && buf[n-1] == '\n' {
+			buf = buf[:n-1]
+			if n > 1 && buf[n-2] == '\r' {
+				buf = buf[:n-2]
+			}
+		}
+		if err == io.EOF && len(line) > 0 {
+			err = nil
+		}
+		if long || d.maxLine > 0 && len(buf) > d.maxLine {
+			if err == nil {
+				err = ErrLineTooLong
+			}
+		}
+		return buf, err
+	}
+}
+
+// skipContinued discards the continuation lines of an entry that
+// exceeded the line limit, and returns ErrLineTooLong.
+func (d *Decoder) skipContinued() error {
+	for {
+		next, err := d.src.Peek(1)
+		if err != nil || next[0] != ' ' && next[0] != '\t' && next[0] != '#' {
+			return ErrLineTooLong
+		}
+		d.scratch, _ = d.appendPhysLine(d.scratch[:0])
+	}
+}
+
+// isBlank reports whether line contains only white space or
+// a comment.
+func isBlank(line []byte) bool {
+	line = bytes.TrimLeftFunc(line, unicode.IsSpace)
+	return len(line) == 0 || line[0] == '#'
+}
+
+// A span records where a physical line starts within the logical
+// line being parsed, and in the input.
+type span struct {
+	pos    int
+	offset int64
+	line   int
+}
+
+func (d *Decoder) getPairs() ([]pair, error) {
+	line, err := d.readLine()
+	if err != nil {
+		return nil, err
+	}
+	d.reset()
+	d.line = line
+	p, err := d.parseLine(line)
+	if err == nil {
+		err = d.checkLimits(p)
+	}
+	if err == nil && d.mapAttr != nil {
+		d.mapAttrs(p)
+	}
+	if e, ok := err.(*SyntaxError); ok {
+		d.locate(e)
+		count(d.metrics, MetricSyntaxErrors, 1)
+	} else if err == nil {
+		count(d.metrics, MetricEntries, 1)
+	}
+	return p, err
+}
+
+// checkLimits returns a *SyntaxError if the tuples p exceed the
+// Decoder's limits.
+func (d *Decoder) checkLimits(p []pair) error {
+	if d.maxTuples > 0 && len(p) > d.maxTuples {
+		return syntaxError(d.line, d.offsetOf(p[d.maxTuples]), ErrTooManyTuples)
+	}
+	if d.maxValue > 0 {
+		for _, t := range p {
+			if len(t.val) > d.maxValue {
+				return syntaxError(d.line, d.offsetOf(t), ErrValueTooLong)
+			}
+		}
+	}
+	return nil
+}
+
+// offsetOf returns the offset of the tuple p within the logical line
+// most recently read.
+func (d *Decoder) offsetOf(p pair) int64 {
+	// p.attr is a subslice of d.line
+	return int64(cap(d.line) - cap(p.attr))
+}
+
+// locate fills in the position of a syntax error in the current
+// logical line, and copies its data so it outlives the line buffer.
+func (d *Decoder) locate(e *SyntaxError) {
+	for i := len(d.spans) - 1; i >= 0; i-- {
+		if sp := d.spans[i]; int64(sp.pos) <= e.Offset {
+			e.Line = sp.line
+			e.Column = int(e.Offset) - sp.pos + 1
+			e.InputOffset = sp.offset + e.Offset - int64(sp.pos)
+			break
+		}
+	}
+	e.Data = append([]byte(nil), e.Data...)
+}
+
+// lineOf returns the index of the physical line, within the logical
+// line most recently read, on which the tuple p begins.
+func (d *Decoder) lineOf(p pair) int {
+	return bytes.Count(d.line[:d.offsetOf(p)], []byte{'\n'})
+}
+
+// lineAt returns the index of the physical line on which the tuple
+// p[i] begins, where p holds the tuples of the logical line most
+// recently read.
+func (d *Decoder) lineAt(p []pair, i int) int {
+	if d.mapAttr != nil {
+		return d.plines[i]
+	}
+	return d.lineOf(p[i])
+}
+
+// mapAttrs replaces the attribute of each tuple in p with the name
+// given by the Decoder's attribute mapper, and counts the new names.
+// The line of each tuple is recorded first, as it can no longer be
+// found from an attribute that is not a part of the line.
+func (d *Decoder) mapAttrs(p []pair) {
+	d.plines = d.plines[:0]
+	clear(d.counts)
+	d.havemulti = false
+	for i := range p {
+		d.plines = append(d.plines, d.lineOf(p[i]))
+		p[i].attr = []byte(d.mapAttr(string(p[i].attr)))
+		d.countAttr(p[i].attr)
+	}
+}
+
+func (d *Decoder) reset() {
+	d.pairbuf = d.pairbuf[0:0]
+	for k := range d.counts {
+		delete(d.counts, k)
+	}
+	d.havemulti = false
+}
+
+func (d *Decoder) countAttr(attr []byte) {
+	if d.nocount {
+		return
+	}
+	n := d.counts[string(attr)] + 1
+	d.counts[string(attr)] = n
+	if n > 1 {
+		d.havemulti = true
+	}
+}
+
+// isSpace is unicode.IsSpace, with a fast path for ASCII.
+func isSpace(r rune) bool {
+	if r < utf8.RuneSelf {
+		return r == ' ' || '\t' <= r && r <= '\r'
+	}
+	return unicode.IsSpace(r)
+}
+
+// isAlnum reports whether r is a letter or number, with a fast path
+// for ASCII.
+func isAlnum(r rune) bool {
+	if r < utf8.RuneSelf {
+		return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9'
+	}
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// attrRune reports whether r may appear in an attribute or, if first
+// is set, begin one. Attributes may hold any printable character but
+// '=', as in ndb(6); with StrictAttrs, they hold only letters, digits
+// and '-', and begin with a letter or digit.
+func (d *Decoder) attrRune(r rune, first bool) bool {
+	if d.strictAttrs {
+		return isAlnum(r) || r == '-' && !first
+	}
+	return r != '=' && unicode.IsPrint(r)
+}
+
+// isAttrByte reports whether c is an ASCII byte that may appear
+// within an attribute.
+func isAttrByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-'
+}
+
+type scanState []int
+
+func (s *scanState) push(n int) {
+	*s = append(*s, n)
+}
+func (s scanState) top() int {
+	if len(s) > 0 {
+		return s[len(s)-1]
+	}
+	return scanNone
+}
+func (s *scanState) pop() int {
+	v := s.top()
+	if len(*s) > 0 {
+		*s = (*s)[0 : len(*s)-1]
+	}
+	return v
+}
+
+const (
+	scanNone = iota
+	scanAttr
+	scanValue
+	scanValueStart
+	scanQuoteStart
+	scanQuoteValue
+	scanQuoteClose
+)
+
+// This is the main tokenizing function. For now it's a messy state machine.
+// It could be cleaned up with better use of structures and methods, or
+// by copying Rob Pike's Go lexing talk.
+func (d *Decoder) parseLine(line []byte) ([]pair, error) {
+	var add pair
+	var beg, offset int64
+	var esc bool
+
+	// Plan 9 quotes values with double quotes
+	quote := byte('\'')
+	if d.profile == Plan9Strict {
+		quote = '"'
+	}
+	state := d.state[:0]
+
+	for offset < int64(len(line)) {
+		// Skip over runs of ASCII that cannot change the state
+		switch state.top() {
+		case scanAttr:
+			for offset < int64(len(line)) && isAttrByte(line[offset]) {
+				offset++
+			}
+		case scanValue:
+			for offset < int64(len(line)) && ' ' < line[offset] && line[offset] < utf8.RuneSelf {
+				offset++
+			}
+		case scanQuoteValue:
+			for offset < int64(len(line)) && line[offset] != quote && line[offset] != '\n' && line[offset] < utf8.RuneSelf {
+				offset++
+			}
+		}
+		if offset == int64(len(line)) {
+			break
+		}
+
+		// Most input is ASCII; only decode runes for high bytes
+		r, sz := rune(line[offset]), 1
+		if r >= utf8.RuneSelf {
+			r, sz = utf8.DecodeRune(line[offset:])
+			if r == utf8.RuneError && sz == 1 {
+				return nil, errBadUnicode(line, offset)
+			}
+		}
+		switch state.top() {
+		case scanNone:
+			if isSpace(r) {
+				// skip
+			} else if r == '#' {
+				// Comments run to the end of the physical line
+				n := int64(bytes.IndexByte(line[offset:], '\n'))
+				if n == -1 {
+					n = int64(len(line)) - offset
+				}
+				offset += n
+				continue
+			} else if d.attrRune(r, true) {
+				state.push(scanAttr)
+				beg = offset
+			} else {
+				return nil, errBadAttr(line, offset)
+			}
+		case scanAttr:
+			if isSpace(r) {
+				add.attr = line[beg:offset]
+				d.pairbuf = append(d.pairbuf, add)
+				d.countAttr(add.attr)
+				add.attr, add.val, esc = nil, nil, false
+				state.pop()
+			} else if r == '=' {
+				add.attr = line[beg:offset]
+				d.countAttr(add.attr)
+				state.pop()
+				state.push(scanValueStart)
+			} else if !d.attrRune(r, false) {
+				return nil, errBadAttr(line, offset)
+			}
+		case scanValueStart:
+			beg = offset
+			state.pop()
+			state.push(scanValue)
+
+			if r == rune(quote) {
+				state.push(scanQuoteStart)
+				break
+			}
+			fallthrough
+		case scanValue:
+			if isSpace(r) {
+				state.pop()
+				add.val = line[beg:offset]
+				if esc {
+					add.val = bytes.Replace(add.val, []byte("''"), []byte("'"), -1)
+				}
+				d.pairbuf = append(d.pairbuf, add)
+				add.attr, add.val = nil, nil
+			}
+		case scanQuoteClose:
+			state.pop()
+			if r == rune(quote) && d.profile == Plan9Strict {
+				return nil, errMissingSpace(line, offset)
+			} else if r == rune(quote) {
+				esc = true
+				state.push(scanQuoteValue)
+			} else if isSpace(r) {
+				state.pop()
+				add.val = line[beg : offset-1]
+				if esc {
+					add.val = bytes.Replace(add.val, []byte("''"), []byte("'"), -1)
+				}
+				d.pairbuf = append(d.pairbuf, add)
+				add.attr, add.val, esc = nil, nil, false
+			} else {
+				return nil, errMissingSpace(line, offset)
+			}
+		case scanQuoteStart:
+			state.pop()
+			if r != rune(quote) {
+				beg++
+				state.pop()
+				state.push(scanQuoteValue)
+			} else if d.profile == Plan9Strict {
+				// An empty quoted value
+				beg++
+				state.pop()
+				state.push(scanQuoteClose)
+			} else {
+				esc = true
+			}
+		case scanQuoteValue:
+			if r == rune(quote) {
+				state.pop()
+				state.push(scanQuoteClose)
+			} else if r == '\n' {
+				return nil, errUnterminated(line, offset)
+			}
+		}
+		offset += int64(sz)
+	}
+	switch state.top() {
+	case scanQuoteValue, scanQuoteStart:
+		return nil, errUnterminated(line, offset)
+	case scanAttr:
+		add.attr = line[beg:offset]
+		d.countAttr(add.attr)
+		d.pairbuf = append(d.pairbuf, add)
+	case scanValueStart:
+		add.val = line[offset:offset]
+		d.pairbuf = append(d.pairbuf, add)
+	case scanQuoteClose:
+		offset--
+		fallthrough
+	case scanValue:
+		add.val = line[beg:offset]
+		if esc {
+			add.val = bytes.Replace(add.val, []byte("''"), []byte("'"), -1)
+		}
+		d.pairbuf = append(d.pairbuf, add)
+	}
+	return d.pairbuf, nil
+}
@@ -0,0 +1,125 @@
+package ndb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var parseLineTests = []struct {
+	in  string
+	out string
+}{
+	{"sys=fir ip=10.0.0.2", "[sys => fir ip => 10.0.0.2]"},
+	{"sys=fir\tdom='a b'  trusted", "[sys => fir dom => a b trusted => ]"},
+	{"naïve=café ñ", "[naïve => café ñ => ]"},
+	{"sys=fir ip=x", "[sys => fir ip => x]"},
+	{"sys=fir # ip=x\n\tdom=y", "[sys => fir dom => y]"},
+}
+
+func TestParseLine(t *testing.T) {
+	d := NewDecoder(nil)
+	for _, tt := range parseLineTests {
+		d.reset()
+		p, err := d.parseLine([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+		} else if fmt.Sprint(p) != tt.out {
+			t.Errorf("%q: Got %v, wanted %s", tt.in, p, tt.out)
+		}
+	}
+	d.reset()
+	_, err := d.parseLine([]byte("sys=f\xffir"))
+	if e, ok := err.(*SyntaxError); !ok || e.Offset != 5 {
+		t.Errorf("Got %v, wanted invalid UTF8 at offset 5", err)
+	}
+}
+
+func BenchmarkParseLine(b *testing.B) {
+	line := []byte("sys=p2-jbs239 dom=p2-jbs239.example.com ip=10.0.4.239 ether=0011aabbccdd " +
+		"vlan=64 vlan=52 vlan=100 native-vlan=666 desc='rack 4, shelf 2' bootf=/386/9pxeload")
+	d := NewDecoder(nil)
+	b.SetBytes(int64(len(line)))
+	for i := 0; i < b.N; i++ {
+		d.reset()
+		if _, err := d.parseLine(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	input := "sys=fir ip=1\ns\x7fs=1 a='x'y b=1\n\tdom='oak\nsys=elm\n"
+	want := []struct {
+		line, col int
+		err       error
+	}{
+		{2, 2, ErrBadAttribute},
+		{2, 12, ErrMissingSpace},
+		{3, 10, ErrUnterminatedQuote},
+	}
+	errs := Check([]byte(input))
+	if len(errs) != len(want) {
+		t.Fatalf("Got %d errors %v, wanted %d", len(errs), errs, len(want))
+	}
+	for i, e := range errs {
+		if w := want[i]; e.Line != w.line || e.Column != w.col || e.Err != w.err {
+			t.Errorf("Got %v at %d:%d, wanted %v at %d:%d", e.Err, e.Line, e.Column, w.err, w.line, w.col)
+		}
+	}
+	if Valid([]byte(input)) {
+		t.Error("Valid reported invalid input as valid")
+	}
+	if !Valid([]byte("sys=fir\n\tip=1\n")) || Check([]byte("sys=fir")) != nil {
+		t.Error("Valid input reported as invalid")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a := "sys=fir ip=1 ip=2\n\tdom='fir'\n# comment\nsys=oak\n"
+	for _, tt := range []struct {
+		b    string
+		want bool
+	}{
+		{"dom=fir   ip=2 sys=fir\n  ip=1\n\nsys=oak", true},
+		{"sys=fir ip=1 dom=fir\nsys=oak\n", false},
+		{"sys=oak\nsys=fir ip=1 ip=2 dom=fir\n", false},
+		{"sys=fir ip=1 ip=2 dom=fir\nsys=oak\nsys=elm\n", false},
+	} {
+		got, err := Equal([]byte(a), []byte(tt.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Equal(%q, %q) = %v, wanted %v", a, tt.b, got, tt.want)
+		}
+	}
+	if _, err := Equal([]byte(a), []byte("sys='fir")); err == nil {
+		t.Error("Got nil, wanted syntax error")
+	}
+}
+
+func TestAttrCharset(t *testing.T) {
+	input := "auth.dom=example.com ip_addr=10.0.0.1 auth-dom=plan9 x:y=1\n"
+	e, err := NewDecoder(strings.NewReader(input)).DecodeEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Entry{{"auth.dom", "example.com"}, {"ip_addr", "10.0.0.1"}, {"auth-dom", "plan9"}, {"x:y", "1"}}
+	if fmt.Sprint(e) != fmt.Sprint(want) {
+		t.Errorf("Got %v, wanted %v", e, want)
+	}
+	if b, err := AppendEntry(nil, e); err != nil || string(b) != strings.TrimSpace(input) {
+		t.Errorf("Got %s, %v, wanted %s", b, err, input)
+	}
+	d := NewDecoderWith(strings.NewReader(input), StrictAttrs())
+	if _, err := d.DecodeEntry(); !errors.Is(err, ErrBadAttribute) {
+		t.Errorf("Got %v, wanted %v", err, ErrBadAttribute)
+	}
+	for _, attr := range []string{"#x", "a=b", "a\x00b"} {
+		if _, err := AppendEntry(nil, Entry{{attr, "1"}}); !errors.Is(err, ErrBadAttribute) {
+			t.Errorf("%q: Got %v, wanted %v", attr, err, ErrBadAttribute)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package ndb
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SortEntries sorts entries by the first value of attr. The sort
+// is stable, and entries without attr are placed last. If numeric
+// is true, values are compared as numbers, with values that are
+// not valid numbers, including NaN, placed after those that are.
+// Otherwise values are compared in natural order, where runs of
+// digits compare by their numeric value, so that "sys9" sorts before
+// "sys10".
+func SortEntries(entries []Entry, attr string, numeric bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, aok := entries[i].first(attr)
+		b, bok := entries[j].first(attr)
+		if !aok || !bok {
+			return aok && !bok
+		}
+		if numeric {
+			return numericLess(a, b)
+		}
+		return naturalLess(a, b)
+	})
+}
+
+// SortBy sorts the entries in the Database by attr, following
+// the rules of SortEntries. Slices returned by Entries before the
+// sort keep their order.
+func (db *Database) SortBy(attr string, numeric bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.load()
+	db.entries = append([]Entry(nil), db.entries...)
+	SortEntries(db.entries, attr, numeric)
+	db.offsets = nil
+	db.reindex()
+	if db.cache != nil {
+		db.cache.clear()
+	}
+}
+
+func numericLess(a, b string) bool {
+	x, xok := parseNumber(a)
+	y, yok := parseNumber(b)
+	switch {
+	case !xok && !yok:
+		return naturalLess(a, b)
+	case !xok || !yok:
+		return xok
+	}
+	return x < y
+}
+
+// parseNumber parses s as a number for numericLess. NaN, which is
+// neither less nor greater than any number, is not a valid number.
+func parseNumber(s string) (float64, bool) {
+	x, err := strconv.ParseFloat(s, 64)
+	return x, err == nil && !math.IsNaN(x)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func naturalLess(a, b string) bool {
+	for len(a) > 0 && len(b) > 0 {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			var x, y string
+			x, a = digitRun(a)
+			y, b = digitRun(b)
+			xs, ys := strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+			if len(xs) != len(ys) {
+				return len(xs) < len(ys)
+			}
+			if xs != ys {
+				return xs < ys
+			}
+			if len(x) != len(y) {
+				return len(x) < len(y)
+			}
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func digitRun(s string) (run, rest string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
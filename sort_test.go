@@ -0,0 +1,60 @@
+package ndb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		less bool
+	}{
+		{"sys9", "sys10", true},
+		{"sys10", "sys9", false},
+		{"a", "b", true},
+		{"10.0.0.2", "10.0.0.10", true},
+		{"x", "x1", true},
+		{"x01", "x1", false},
+	}
+	for _, tt := range tests {
+		if got := naturalLess(tt.a, tt.b); got != tt.less {
+			t.Errorf("naturalLess(%q, %q) = %v, wanted %v", tt.a, tt.b, got, tt.less)
+		}
+	}
+}
+
+func TestSortBy(t *testing.T) {
+	db := openTestDB(t)
+	db.SortBy("sys", false)
+	var got []string
+	for _, e := range db.Entries() {
+		v, _ := e.first("sys")
+		got = append(got, v)
+	}
+	if len(got) != 3 || got[0] != "fir" || got[1] != "oak" || got[2] != "" {
+		t.Errorf("Got order %q, wanted fir, oak, then the entry without sys", got)
+	}
+}
+
+func TestSortEntriesNumeric(t *testing.T) {
+	want := []string{"-1", "2", "10", "NaN", "nan", "x"}
+	for _, in := range [][]string{
+		{"10", "NaN", "2", "x", "-1", "nan"},
+		{"nan", "x", "NaN", "-1", "2", "10"},
+		{"2", "nan", "-1", "x", "10", "NaN"},
+	} {
+		var entries []Entry
+		for _, v := range in {
+			entries = append(entries, Entry{{"n", v}})
+		}
+		SortEntries(entries, "n", true)
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Get("n"))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Sorted %q into %q, wanted %q", in, got, want)
+		}
+	}
+}
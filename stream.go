@@ -0,0 +1,47 @@
+package ndb
+
+import (
+	"context"
+	"io"
+)
+
+// A Result is an entry read by Stream, or the error that ended the
+// stream.
+type Result struct {
+	Entry Entry
+	Err   error
+}
+
+// Stream reads the remaining entries in the Decoder's input in a new
+// goroutine, as read by DecodeEntry, and sends them on the returned
+// channel, which is closed at the end of the input. A read error or
+// syntax error is sent as a Result with a non-nil Err, after which
+// the channel is closed. The channel is unbuffered, so the goroutine
+// reads no further ahead than the entry waiting to be received.
+//
+// When ctx is done, the goroutine stops and closes the channel
+// without sending ctx's error; entries read but not yet received are
+// discarded. A read from the input that is in progress cannot be
+// interrupted, and the goroutine exits once it returns. The Decoder
+// must not be used by other goroutines until the channel is closed.
+func (d *Decoder) Stream(ctx context.Context) <-chan Result {
+	c := make(chan Result)
+	go func() {
+		defer close(c)
+		for ctx.Err() == nil {
+			e, err := d.DecodeEntry()
+			if err == io.EOF {
+				return
+			}
+			select {
+			case c <- Result{e, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return c
+}
@@ -0,0 +1,48 @@
+package ndb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	d := NewDecoder(strings.NewReader("sys=fir\nsys=oak\nsys='elm\nsys=ash\n"))
+	var got []string
+	var err error
+	for r := range d.Stream(context.Background()) {
+		if r.Err != nil {
+			err = r.Err
+			continue
+		}
+		got = append(got, r.Entry.Get("sys"))
+	}
+	if strings.Join(got, " ") != "fir oak" {
+		t.Errorf("Got %v, wanted [fir oak]", got)
+	}
+	var se *SyntaxError
+	if !errors.As(err, &se) || se.Line != 3 {
+		t.Errorf("Got %v, wanted syntax error on line 3", err)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	d := NewDecoder(strings.NewReader(strings.Repeat("sys=fir\n", 100)))
+	ctx, cancel := context.WithCancel(context.Background())
+	c := d.Stream(ctx)
+	if r := <-c; r.Err != nil || r.Entry.Get("sys") != "fir" {
+		t.Fatalf("Got %v, wanted sys=fir", r)
+	}
+	cancel()
+	n := 0
+	for range c {
+		n++
+	}
+	if n > 1 {
+		t.Errorf("Received %d entries after cancellation, wanted at most 1", n)
+	}
+	if d.InputOffset() == int64(100*len("sys=fir\n")) {
+		t.Error("Stream read the whole input after cancellation")
+	}
+}
@@ -0,0 +1,108 @@
+package ndb
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tagOptions is the comma-separated list of options following the
+// attribute name in a struct tag, such as `ndb:"expires,format=2006-01-02"`.
+type tagOptions string
+
+// A field describes the struct field an attribute is stored in.
+type field struct {
+	name  string
+	index []int
+	opts  tagOptions
+}
+
+// parseTag splits a struct tag into the attribute name and its options.
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.IndexByte(tag, ','); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+// tagNames returns the attribute names in the name part of a struct
+// tag, which are separated by spaces, or def if there are none. A
+// field is decoded from any of its names, and encoded with the first.
+func tagNames(name, def string) []string {
+	if names := strings.Fields(name); len(names) > 0 {
+		return names
+	}
+	return []string{def}
+}
+
+// tagName returns the first of the names returned by tagNames, the
+// one a field is encoded with, without allocating.
+func tagName(name, def string) string {
+	name = strings.TrimLeftFunc(name, unicode.IsSpace)
+	if i := strings.IndexFunc(name, unicode.IsSpace); i != -1 {
+		name = name[:i]
+	}
+	if name == "" {
+		return def
+	}
+	return name
+}
+
+// Get returns the value of the option key=value. Because layouts and
+// patterns may contain commas, the value of the format or match option
+// runs to the end of the tag, so it must be the last option.
+func (o tagOptions) Get(key string) (string, bool) {
+	for _, opt := range o.split() {
+		if strings.HasPrefix(opt, key+"=") {
+			return opt[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// Has reports whether the flag option name is present.
+func (o tagOptions) Has(name string) bool {
+	for _, opt := range o.split() {
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// boolWords returns the spellings of true and false given by the
+// bool option, as in `ndb:"dhcp,bool=yes|no"`.
+func (o tagOptions) boolWords() (t, f string, ok bool) {
+	v, ok := o.Get("bool")
+	if !ok {
+		return "", "", false
+	}
+	t, f, ok = strings.Cut(v, "|")
+	return t, f, ok
+}
+
+// listSep returns the separator of a list written as a single value,
+// given by the comma option, or by the sep option, as in
+// `ndb:"ports,sep=;"`.
+func (o tagOptions) listSep() (string, bool) {
+	if o.Has("comma") {
+		return ",", true
+	}
+	return o.Get("sep")
+}
+
+func (o tagOptions) split() []string {
+	var opts []string
+	s := string(o)
+	for s != "" {
+		if strings.HasPrefix(s, "format=") || strings.HasPrefix(s, "match=") {
+			return append(opts, s)
+		}
+		i := strings.IndexByte(s, ',')
+		if i == -1 {
+			return append(opts, s)
+		}
+		opts = append(opts, s[:i])
+		s = s[i+1:]
+	}
+	return opts
+}
@@ -0,0 +1,58 @@
+package ndb
+
+// A TokenKind identifies the type of a Token.
+type TokenKind int
+
+const (
+	// An AttrToken holds the attribute of a tuple.
+	AttrToken TokenKind = iota + 1
+	// A ValueToken holds the value of the tuple whose
+	// attribute was the preceding token. It is omitted
+	// for attributes without a value.
+	ValueToken
+	// An EndToken marks the end of an entry. Its Text is empty.
+	EndToken
+)
+
+// A Token is an element of the ndb input stream returned by the
+// Decoder's Token method.
+type Token struct {
+	Kind TokenKind
+	// Text holds the attribute name, or the unquoted value.
+	// It is only valid until the next call to Token.
+	Text []byte
+}
+
+// Token returns the next token in the input stream. Each tuple is
+// returned as an AttrToken followed by a ValueToken, and each entry
+// ends with an EndToken; blank lines are skipped. At the end of the
+// input, Token returns io.EOF.
+//
+// Token reuses its internal buffers between entries, so large
+// databases may be processed without allocating memory per tuple.
+// Calls to Token should not be mixed with calls to Decode in the
+// middle of an entry.
+func (d *Decoder) Token() (Token, error) {
+	for d.tokpos >= len(d.tokbuf) {
+		if d.tokpos == len(d.tokbuf) && d.tokpos > 0 {
+			d.tokpos++
+			return Token{Kind: EndToken}, nil
+		}
+		p, err := d.getPairs()
+		if err != nil {
+			return Token{}, err
+		}
+		d.tokbuf, d.tokpos, d.tokval = p, 0, false
+	}
+	p := d.tokbuf[d.tokpos]
+	if !d.tokval {
+		d.tokval = p.val != nil
+		if !d.tokval {
+			d.tokpos++
+		}
+		return Token{AttrToken, p.attr}, nil
+	}
+	d.tokval = false
+	d.tokpos++
+	return Token{ValueToken, p.val}, nil
+}
@@ -0,0 +1,52 @@
+package ndb
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestToken(t *testing.T) {
+	d := NewDecoder(strings.NewReader("sys=fir ip='10.0.0.1'\n\nsys=oak\n"))
+	var got []string
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		switch tok.Kind {
+		case AttrToken:
+			got = append(got, "attr:"+string(tok.Text))
+		case ValueToken:
+			got = append(got, "val:"+string(tok.Text))
+		case EndToken:
+			got = append(got, "end")
+		}
+	}
+	want := "[attr:sys val:fir attr:ip val:10.0.0.1 end attr:sys val:oak end]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Got %v, wanted %s", got, want)
+	}
+}
+
+func TestEmptyValueAtEOL(t *testing.T) {
+	d := NewDecoder(strings.NewReader("sys=fir bootf=\nsys=oak bootf="))
+	var got []string
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if tok.Kind == ValueToken {
+			got = append(got, string(tok.Text))
+		}
+	}
+	if want := "[fir  oak ]"; fmt.Sprint(got) != want {
+		t.Errorf("Got %q, wanted %s", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+package ndb
+
+// Tuples is an ordered multimap of attributes to values. Unlike a
+// map, it keeps both the order of the attributes and the order of
+// the values of a repeated attribute, for entries such as
+// ipgw=a ipgw=b in which the order matters. A *Tuples may be passed
+// to Decode to receive the tuples of an entry, and a Tuples passed
+// to Encode writes them in order.
+type Tuples []Pair
+
+// Get returns the value of the first tuple in t with the given
+// attribute, or the empty string if there is none.
+func (t Tuples) Get(attr string) string {
+	return Entry(t).Get(attr)
+}
+
+// GetAll returns the values of every tuple in t with the given
+// attribute, in order.
+func (t Tuples) GetAll(attr string) []string {
+	return Entry(t).GetAll(attr)
+}
+
+// Add appends the tuple attr=val to t.
+func (t *Tuples) Add(attr, val string) {
+	*t = append(*t, Pair{attr, val})
+}
+
+// Set sets the value of the first tuple in t with the given attribute
+// to val, in its place, and removes the others; if there is none, the
+// tuple attr=val is appended to t.
+func (t *Tuples) Set(attr, val string) {
+	keep := (*t)[:0]
+	var found bool
+	for _, p := range *t {
+		if p.Attr == attr {
+			if found {
+				continue
+			}
+			p.Val, found = val, true
+		}
+		keep = append(keep, p)
+	}
+	*t = keep
+	if !found {
+		t.Add(attr, val)
+	}
+}
+
+// Del removes every tuple in t with the given attribute, preserving
+// the order of the remaining tuples.
+func (t *Tuples) Del(attr string) {
+	(*Entry)(t).Del(attr)
+}
+
+// MarshalNDB returns the tuples of t as an entry.
+func (t Tuples) MarshalNDB() ([]byte, error) {
+	return AppendEntry(nil, Entry(t))
+}
+
+// UnmarshalNDB replaces the contents of t with the tuples of the
+// entry b.
+func (t *Tuples) UnmarshalNDB(b []byte) error {
+	pairs, err := AppendPairs((*t)[:0], b)
+	if err != nil {
+		return err
+	}
+	*t = pairs
+	return nil
+}
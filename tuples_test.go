@@ -0,0 +1,49 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTuples(t *testing.T) {
+	input := "sys=gw ipgw=10.0.0.2 ip=10.0.0.1 ipgw=10.0.0.1\n\tdom=gw.example.com\nsys=fir\n"
+	var all []Tuples
+	if err := Unmarshal([]byte(input), &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("Got %d entries, wanted 2", len(all))
+	}
+	tu := all[0]
+	if got := fmt.Sprint(tu.GetAll("ipgw")); got != "[10.0.0.2 10.0.0.1]" {
+		t.Errorf("Got %s, wanted ipgw in input order", got)
+	}
+	if tu.Get("dom") != "gw.example.com" {
+		t.Errorf("Got dom=%s", tu.Get("dom"))
+	}
+
+	tu.Set("ipgw", "10.0.0.3")
+	tu.Set("mtu", "9000")
+	tu.Del("dom")
+	tu.Add("ipgw", "10.0.0.4")
+	b, err := Marshal(tu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "sys=gw ipgw=10.0.0.3 ip=10.0.0.1 mtu=9000 ipgw=10.0.0.4"
+	if string(b) != want {
+		t.Errorf("Got %s, wanted %s", b, want)
+	}
+
+	all[0] = tu
+	var buf strings.Builder
+	if err := NewEncoder(&buf).Encode(all); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "sys=gw ipgw=10.0.0.3 ip=10.0.0.1 mtu=9000 ipgw=10.0.0.4\nsys=fir" {
+		t.Errorf("Got %q", buf.String())
+	}
+}
@@ -0,0 +1,114 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"cmp"
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Errors wrapped by a *FieldError when a value fails the checks given
+// by the min, max or match options of its struct field.
+var (
+	ErrBelowMin = errors.New("Value less than minimum")
+	ErrAboveMax = errors.New("Value greater than maximum")
+	ErrNoMatch  = errors.New("Value does not match pattern")
+)
+
+var errBadBound = errors.New("ndb: min and max options need a number, duration or string field")
+
+// patternCache maps the pattern of a match option to its
+// *regexp.Regexp.
+var patternCache sync.Map
+
+// validate checks the value v, decoded from src, against the min, max
+// and match options in opts.
+func validate(v reflect.Value, src []byte, opts tagOptions) error {
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if bound, ok := opts.Get("min"); ok && v.Kind() != reflect.Ptr {
+		if c, err := compareBound(v, bound); err != nil {
+			return err
+		} else if c < 0 {
+			return ErrBelowMin
+		}
+	}
+	if bound, ok := opts.Get("max"); ok && v.Kind() != reflect.Ptr {
+		if c, err := compareBound(v, bound); err != nil {
+			return err
+		} else if c > 0 {
+			return ErrAboveMax
+		}
+	}
+	if pat, ok := opts.Get("match"); ok {
+		re, err := compilePattern(pat)
+		if err != nil {
+			return err
+		}
+		if !re.Match(src) {
+			return ErrNoMatch
+		}
+	}
+	return nil
+}
+
+func compilePattern(pat string) (*regexp.Regexp, error) {
+	if re, ok := patternCache.Load(pat); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pat)
+	if err != nil {
+		return nil, err
+	}
+	patternCache.Store(pat, re)
+	return re, nil
+}
+
+// compareBound returns -1, 0 or 1 as v is less than, equal to or
+// greater than bound. Strings are compared by their length in
+// characters, and durations may be given as time.ParseDuration
+// accepts.
+func compareBound(v reflect.Value, bound string) (int, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var b int64
+		var err error
+		if v.Type() == durationType {
+			var d time.Duration
+			d, err = time.ParseDuration(bound)
+			b = int64(d)
+		} else {
+			b, err = strconv.ParseInt(bound, 0, 64)
+		}
+		if err != nil {
+			return 0, err
+		}
+		return cmp.Compare(v.Int(), b), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		b, err := strconv.ParseUint(bound, 0, 64)
+		if err != nil {
+			return 0, err
+		}
+		return cmp.Compare(v.Uint(), b), nil
+	case reflect.Float32, reflect.Float64:
+		b, err := strconv.ParseFloat(bound, 64)
+		if err != nil {
+			return 0, err
+		}
+		return cmp.Compare(v.Float(), b), nil
+	case reflect.String:
+		b, err := strconv.Atoi(bound)
+		if err != nil {
+			return 0, err
+		}
+		return cmp.Compare(utf8.RuneCountInString(v.String()), b), nil
+	}
+	return 0, errBadBound
+}
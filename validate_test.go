@@ -0,0 +1,51 @@
+//go:build !ndbnoreflect
+
+package ndb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	type service struct {
+		Name    string        `ndb:"name,min=1,max=8,match=^[a-z][a-z0-9-]*$"`
+		Port    int           `ndb:"port,min=1,max=65535"`
+		Weight  float64       `ndb:"weight,max=1"`
+		Timeout time.Duration `ndb:"timeout,min=1s"`
+		Peers   []uint        `ndb:"peer,min=0x10"`
+	}
+	var s service
+	if err := UnmarshalString("name=web port=80 weight=0.5 timeout=5s peer=16 peer=32", &s); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		in   string
+		attr string
+		err  error
+	}{
+		{"name=Web", "name", ErrNoMatch},
+		{"name=webserver1", "name", ErrAboveMax},
+		{"name=", "name", ErrBelowMin},
+		{"port=0", "port", ErrBelowMin},
+		{"port=70000", "port", ErrAboveMax},
+		{"weight=1.5", "weight", ErrAboveMax},
+		{"timeout=10ms", "timeout", ErrBelowMin},
+		{"peer=16 peer=8", "peer", ErrBelowMin},
+	} {
+		var s service
+		err := UnmarshalString(tt.in, &s)
+		var ferr *FieldError
+		if !errors.As(err, &ferr) || ferr.Attr != tt.attr || !errors.Is(err, tt.err) {
+			t.Errorf("%s: Got %v, wanted %v for %s", tt.in, err, tt.err, tt.attr)
+		}
+	}
+
+	var bad struct {
+		On bool `ndb:"on,min=1"`
+	}
+	if err := UnmarshalString("on=true", &bad); !errors.Is(err, errBadBound) {
+		t.Errorf("Got %v, wanted %v", err, errBadBound)
+	}
+}
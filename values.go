@@ -0,0 +1,36 @@
+package ndb
+
+import (
+	"net/url"
+	"sort"
+)
+
+// Values returns the tuples of e as url.Values, so that an entry may
+// be sent as a query string or form. The values of a repeated
+// attribute are kept in order.
+func (e Entry) Values() url.Values {
+	v := make(url.Values, len(e))
+	for _, p := range e {
+		v[p.Attr] = append(v[p.Attr], p.Val)
+	}
+	return v
+}
+
+// EntryFromValues returns an Entry holding the keys and values of v,
+// such as the parsed query string of a request. Because url.Values is
+// unordered, the attributes are sorted by name; the values of each
+// attribute keep their order.
+func EntryFromValues(v url.Values) Entry {
+	attrs := make([]string, 0, len(v))
+	for attr := range v {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	var e Entry
+	for _, attr := range attrs {
+		for _, val := range v[attr] {
+			e.Add(attr, val)
+		}
+	}
+	return e
+}
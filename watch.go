@@ -0,0 +1,167 @@
+package ndb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"time"
+)
+
+var errNoPath = errors.New("ndb: database was not opened from a file")
+
+// defaultPoll is the interval at which Watch checks for changes, if
+// none is set with SetPollInterval.
+const defaultPoll = 5 * time.Second
+
+// fileState identifies a version of a Database's file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+func newFileState(fi os.FileInfo, src []byte) fileState {
+	return fileState{fi.ModTime(), fi.Size(), sha256.Sum256(src)}
+}
+
+// Reload reads the file the Database was opened from again, and
+// replaces the entries in the Database with its contents. If the file
+// cannot be read or parsed, the Database is left unchanged and the
+// error is returned. Entries returned before the reload are not
+// modified.
+func (db *Database) Reload() error {
+	db.mu.RLock()
+	path := db.path
+	db.mu.RUnlock()
+	if path == "" {
+		return errNoPath
+	}
+	fresh, _, err := db.open(path)
+	if err != nil {
+		return err
+	}
+	db.replace(fresh)
+	return nil
+}
+
+// open reads the file at path as the Database was read, with or
+// without MapFile.
+func (db *Database) open(path string) (*Database, []byte, error) {
+	db.mu.RLock()
+	mapped, m := db.mapped, db.metrics
+	db.mu.RUnlock()
+	if mapped {
+		return openMapped(path, m)
+	}
+	return openFile(path, m)
+}
+
+// replace makes db hold the contents of fresh.
+func (db *Database) replace(fresh *Database) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.lazy != nil {
+		db.lazy.release()
+	}
+	db.entries = fresh.entries
+	db.lazy = fresh.lazy
+	db.skipped = fresh.skipped
+	db.offsets = fresh.offsets
+	db.mtime = fresh.mtime
+	db.origins = fresh.origins
+	db.file = fresh.file
+	db.reindex()
+	if db.cache != nil {
+		db.cache.clear()
+	}
+
+	db.hmu.Lock()
+	db.hashes = nil
+	db.hmu.Unlock()
+}
+
+// SetPollInterval sets how often Watch checks the Database's file for
+// changes. The default is 5 seconds.
+func (db *Database) SetPollInterval(d time.Duration) {
+	db.mu.Lock()
+	db.poll = d
+	db.mu.Unlock()
+}
+
+// Watch polls the file the Database was opened from, and reloads the
+// Database when the file's contents change, as Plan 9's cs does for
+// its databases. A change is detected by a new modification time or
+// size, and confirmed by a checksum of the contents. After each
+// reload, a value is sent on the returned channel, unless one is
+// already pending. A file that cannot be read or parsed, such as one
+// that is being rewritten, is ignored until it changes again. The
+// channel is closed when ctx is done.
+func (db *Database) Watch(ctx context.Context) (<-chan struct{}, error) {
+	db.mu.RLock()
+	path, poll := db.path, db.poll
+	db.mu.RUnlock()
+	if path == "" {
+		return nil, errNoPath
+	}
+	if poll <= 0 {
+		poll = defaultPoll
+	}
+	c := make(chan struct{}, 1)
+	go func() {
+		defer close(c)
+		t := time.NewTicker(poll)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			if db.changed(path) {
+				select {
+				case c <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return c, nil
+}
+
+// changed reloads the Database if the file at path differs from the
+// one it was read from, and reports whether it did.
+func (db *Database) changed(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	db.mu.RLock()
+	old := db.file
+	db.mu.RUnlock()
+	if fi.ModTime().Equal(old.modTime) && fi.Size() == old.size {
+		return false
+	}
+	fresh, src, err := db.open(path)
+	if err != nil {
+		return false
+	}
+	if sum := sha256.Sum256(src); bytes.Equal(sum[:], old.sum[:]) {
+		// Only the modification time changed, which hash files
+		// are checked against
+		if fresh.lazy != nil {
+			fresh.lazy.release()
+		}
+		db.mu.Lock()
+		db.file = fresh.file
+		db.mtime = fresh.mtime
+		db.mu.Unlock()
+		db.hmu.Lock()
+		db.hashes = nil
+		db.hmu.Unlock()
+		return false
+	}
+	db.replace(fresh)
+	return true
+}
@@ -0,0 +1,165 @@
+package ndb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local")
+	if err := os.WriteFile(path, []byte(testDB), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := db.Entries()
+	if err := os.WriteFile(path, []byte("sys=elm\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if e := db.Entries(); len(e) != 1 || e[0].Get("sys") != "elm" {
+		t.Errorf("Got %v after reload, wanted sys=elm", e)
+	}
+	if len(old) != 3 {
+		t.Errorf("Reload modified entries returned earlier: %v", old)
+	}
+	if err := os.WriteFile(path, []byte("sys='elm\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reload(); err == nil {
+		t.Error("Got nil, wanted syntax error")
+	}
+	if e := db.Entries(); len(e) != 1 {
+		t.Errorf("Got %v after failed reload, wanted sys=elm", e)
+	}
+	if err := new(Database).Reload(); err == nil {
+		t.Error("Got nil, wanted error reloading a Database without a path")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local")
+	if err := os.WriteFile(path, []byte(testDB), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetPollInterval(time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := db.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite with the same size, so only the checksum differs
+	next := strings.Replace(testDB, "sys=fir", "sys=fix", 1)
+	if err := os.WriteFile(path, []byte(next), 0666); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No change detected")
+	}
+	if found, _ := db.Search("sys", "fir"); len(found) != 0 {
+		t.Errorf("Got %v, wanted no sys=fir after reload", found)
+	}
+	cancel()
+	for range c {
+	}
+}
+
+func TestTouch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local")
+	if err := os.WriteFile(path, []byte(testDB), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if db.changed(path) {
+		t.Error("Reloaded a file whose contents did not change")
+	}
+	if want := uint32(future.Unix()); db.mtime != want {
+		t.Errorf("Got mtime %d, wanted %d", db.mtime, want)
+	}
+}
+
+// TestConcurrentQueries is most useful with the race detector.
+func TestConcurrentQueries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local")
+	if err := os.WriteFile(path, []byte(testDB), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Index("sys")
+	entries := db.Entries()
+	first := entries[0].Get("ipnet")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if found, err := db.Search("ip", "135.104.9.3"); err != nil || len(found) != 1 {
+					t.Errorf("Search(ip, 135.104.9.3) = %v, %v", found, err)
+					return
+				}
+				if v, err := db.Value("sys", "fir", "ip"); err != nil || v != "135.104.9.1" {
+					t.Errorf("Value(sys, fir, ip) = %q, %v", v, err)
+					return
+				}
+				if info, err := db.Ipinfo("135.104.9.2", "ipmask"); err != nil || len(info) != 1 {
+					t.Errorf("Ipinfo = %v, %v", info, err)
+					return
+				}
+				for _, e := range db.Entries() {
+					e.Get("sys")
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		if err := db.Reload(); err != nil {
+			t.Error(err)
+			break
+		}
+		db.SortBy("sys", false)
+		db.Index("ip")
+	}
+	close(done)
+	wg.Wait()
+	if entries[0].Get("ipnet") != first {
+		t.Error("Entries returned earlier were modified")
+	}
+}
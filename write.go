@@ -1,132 +1,539 @@
+//go:build !ndbnoreflect
+
 package ndb
 
 import (
 	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"reflect"
-	"unicode"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 	"unicode/utf8"
+	"unsafe"
 )
 
+// Marshal encodes a value into an ndb string. Marshal will use the String
+// method of each struct field or map entry to produce ndb output.
+// If v is a slice or array, multiple ndb lines will be output, one
+// for each element. For structs, attribute names will be the name of
+// the struct field, or the fields ndb annotation if it exists; of the
+// names listed in an annotation, the first is used.
+// Ndb attributes may not contain white space. Ndb values may contain
+// white space but may not contain new lines. If Marshal cannot produce
+// valid ndb strings, an error is returned. Struct fields are encoded
+// in the order they are declared, and map entries are sorted by their
+// attribute names, so the output for a given value is always the same;
+// the AttrOrder option chooses a different order. A bool field with
+// the flag option, as in `ndb:"trusted,flag"`, is written as the bare
+// attribute trusted when true, and omitted when false. A bool field
+// with the bool option, as in `ndb:"dhcp,bool=yes|no"`, is written
+// with the given spellings of true and false. An integer field with
+// the hex or octal option, as in `ndb:"mask,hex"`, is written in that
+// base, with a 0x or 0o prefix. A []byte field with the hex or base64
+// option, as in `ndb:"key,base64"`, is written as a single value in
+// that encoding, rather than as a tuple for each byte. A slice field
+// with the comma option, as in `ndb:"ports,comma"`, is written as a
+// single tuple listing its elements separated by commas, such as
+// ports=80,443; the sep option, as in `ndb:"ports,sep=;"`, chooses
+// another separator. Elements containing the separator cannot be
+// decoded again.
+//
+// A struct field whose type is a struct, or a slice of structs, is
+// written as indented continuation lines following the entry, one per
+// nested struct. Unmarshal decodes a continuation line into such a
+// field when its first attribute is that of the nested struct's first
+// field. Nested structs may not themselves contain nested structs.
+// Values implementing Marshaler or encoding.TextMarshaler are encoded
+// using those methods instead.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalWith is like Marshal, but encodes v using an Encoder
+// configured with the given options.
+func MarshalWith(v interface{}, opts ...Option) ([]byte, error) {
+	var buf bytes.Buffer
+	e := NewEncoderWith(&buf, opts...)
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalAppend is like Marshal, but appends the encoding of v to
+// dst and returns the extended buffer, so that a buffer may be reused
+// across calls. Encoding a pointer to a struct of strings, integers
+// and bools into a buffer with room for it does not allocate. If v
+// cannot be encoded, dst is returned unchanged, along with the error.
+func MarshalAppend(dst []byte, v interface{}) ([]byte, error) {
+	e := appendEncoders.Get().(*Encoder)
+	defer appendEncoders.Put(e)
+	e.buf = dst
+	err := e.encode(v)
+	b := e.buf
+	e.buf, e.wrote = nil, false
+	if err != nil {
+		return dst, err
+	}
+	return b, nil
+}
+
+// appendEncoders holds Encoders for MarshalAppend, so that their
+// scratch buffers are reused across calls.
+var appendEncoders = sync.Pool{
+	New: func() any {
+		return &Encoder{config: newConfig(nil), eol: "\n"}
+	},
+}
+
+// The Encode method will write the ndb encoding of the Go value v
+// to its backend io.Writer. Unlike Decode(), slice or array values
+// are valid, and will cause multiple ndb lines to be written. Each
+// entry written by the Encoder after the first starts on a new line.
+// If the value cannot be fully encoded, an error is returned and
+// no data will be written to the io.Writer.
+func (e *Encoder) Encode(v interface{}) error {
+	// Output is buffered until v is fully encoded
+	e.buf = e.buf[:0]
+	wrote := e.wrote
+	if err := e.encode(v); err != nil {
+		e.wrote = wrote
+		return err
+	}
+	_, err := e.out.Write(e.buf)
+	return err
+}
+
+func (e *Encoder) encode(v interface{}) error {
+	val := reflect.ValueOf(v)
+	// Drill down to the concrete value
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return &TypeError{nil}
+		} else {
+			val = val.Elem()
+		}
+	}
+	if val.Kind() != reflect.Slice || isMarshaler(val) {
+		// Entries are written one per line
+		if e.wrote {
+			e.buf = append(e.buf, e.eol...)
+			if e.paragraphs {
+				e.buf = append(e.buf, e.eol...)
+			}
+		}
+		e.wrote = true
+	}
+	defer func() {
+		e.start = false
+		e.col = 0
+	}()
+	if m, ok := marshalerFor(val); ok {
+		b, err := m.MarshalNDB()
+		if err != nil {
+			return err
+		}
+		if !validEntry(b) {
+			return &SyntaxError{Message: fmt.Sprintf("Invalid entry %s", b)}
+		}
+		e.buf = append(e.buf, b...)
+		return nil
+	}
+	switch val.Kind() {
+	case reflect.Slice:
+		return e.encodeSlice(val)
+	case reflect.Struct:
+		return e.encodeStruct(val)
+	case reflect.Map:
+		return e.encodeMap(val)
+	default:
+		return &TypeError{val.Type()}
+	}
+}
+
 func (e *Encoder) encodeSlice(val reflect.Value) error {
 	for i := 0; i < val.Len(); i++ {
-		e.Encode(val.Index(i).Interface())
+		if err := e.encode(val.Index(i).Interface()); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// An encField is a struct field to be written as a tuple.
+type encField struct {
+	attr string
+	val  reflect.Value
+	opts tagOptions
+}
+
 func (e *Encoder) encodeStruct(val reflect.Value) error {
+	var subs []reflect.Value
 	typ := val.Type()
+	var small [8]encField // avoids allocating for most structs
+	fields := small[:0]
 	for i := 0; i < typ.NumField(); i++ {
 		ft := typ.Field(i)
-		attr := ft.Name
-		if tag := ft.Tag.Get("ndb"); tag != "" {
-			attr = tag
+		if !ft.IsExported() {
+			continue
 		}
-		err := e.writeTuple(attr, val.Field(i))
-		if err != nil {
+		tag := ft.Tag.Get(e.tag)
+		if tag == "-" {
+			continue
+		}
+		if _, ok := nestedType(ft.Type); ok {
+			if e.nested {
+				return &TypeError{ft.Type}
+			}
+			subs = append(subs, val.Field(i))
+			continue
+		}
+		attr, o := parseTag(tag)
+		if o.Has("raw") {
+			continue
+		}
+		attr = tagName(attr, ft.Name)
+		if e.mapAttr != nil {
+			attr = e.mapAttr(attr)
+		}
+		fields = append(fields, encField{attr, val.Field(i), o})
+	}
+	if e.less != nil {
+		slices.SortStableFunc(fields, func(a, b encField) int {
+			if e.less(a.attr, b.attr) {
+				return -1
+			} else if e.less(b.attr, a.attr) {
+				return 1
+			}
+			return 0
+		})
+	}
+	for _, f := range fields {
+		if err := e.writeTuple(f.attr, f.val, f.opts); err != nil {
 			return err
 		}
 	}
+	return e.encodeSubEntries(subs)
+}
+
+// encodeSubEntries writes each struct in subs, or each element of a
+// slice of structs, as a continuation line of the current entry.
+// Continuation lines are not wrapped, so that each holds exactly one
+// nested entry.
+func (e *Encoder) encodeSubEntries(subs []reflect.Value) error {
+	width := e.width
+	e.width, e.nested = 0, true
+	defer func() {
+		e.width, e.nested = width, false
+	}()
+	for _, v := range subs {
+		vals := []reflect.Value{v}
+		if v.Kind() == reflect.Slice {
+			vals = vals[:0]
+			for i := 0; i < v.Len(); i++ {
+				vals = append(vals, v.Index(i))
+			}
+		}
+		for _, sv := range vals {
+			e.buf = append(e.buf, e.eol...)
+			e.buf = append(e.buf, '\t')
+			e.start, e.col = false, tabWidth
+			if err := e.encodeStruct(sv); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
 func (e *Encoder) encodeMap(val reflect.Value) error {
-	for _, k := range val.MapKeys() {
+	keys := val.MapKeys()
+	attrs := make([]string, len(keys))
+	for i, k := range keys {
+		attrs[i] = fmt.Sprint(k.Interface())
+		if e.mapAttr != nil {
+			attrs[i] = e.mapAttr(attrs[i])
+		}
+	}
+	less := e.less
+	if less == nil {
+		less = Alphabetical
+	}
+	sort.Sort(byAttr{keys, attrs, less})
+	for i, k := range keys {
 		v := val.MapIndex(k)
 
-		if err := e.writeTuple(k.Interface(), v); err != nil {
+		if err := e.writeTuple(attrs[i], v, ""); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (e *Encoder) writeTuple(k interface{}, v reflect.Value) error {
-	var values reflect.Value
-	var attrBuf, valBuf bytes.Buffer
-	fmt.Fprint(&attrBuf, k)
-
-	attr := attrBuf.Bytes()
+// byAttr sorts map keys by their attribute names.
+type byAttr struct {
+	keys  []reflect.Value
+	attrs []string
+	less  func(a, b string) bool
+}
 
-	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
-		sliceType := reflect.SliceOf(v.Type())
-		pv := reflect.New(sliceType)
-		pv.Elem().Set(reflect.MakeSlice(sliceType, 0, 1))
-		pv.Elem().Set(reflect.Append(pv.Elem(), v))
-		values = pv.Elem()
-	} else {
-		values = v
+func (s byAttr) Len() int { return len(s.keys) }
+func (s byAttr) Less(i, j int) bool {
+	if s.less(s.attrs[i], s.attrs[j]) {
+		return true
+	} else if s.less(s.attrs[j], s.attrs[i]) {
+		return false
 	}
+	return s.attrs[i] < s.attrs[j]
+}
+func (s byAttr) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.attrs[i], s.attrs[j] = s.attrs[j], s.attrs[i]
+}
 
-	for i := 0; i < values.Len(); i++ {
-		fmt.Fprint(&valBuf, values.Index(i).Interface())
-		val := valBuf.Bytes()
-		if e.start {
-			if _, err := e.out.Write([]byte{' '}); err != nil {
-				return err
-			}
-		} else {
-			e.start = true
+func (e *Encoder) writeTuple(attr string, v reflect.Value, opts tagOptions) error {
+	if !validAttr([]byte(attr)) {
+		return &SyntaxError{Message: fmt.Sprintf("Invalid attribute %s", attr), Err: ErrBadAttribute}
+	}
+	if opts.Has("flag") && v.Kind() == reflect.Bool {
+		return e.writeFlag(attr, v.Bool())
+	}
+	if t, f, ok := opts.boolWords(); ok && v.Kind() == reflect.Bool {
+		if !v.Bool() {
+			t = f
 		}
+		return e.writeValue(attr, t)
+	}
 
-		if !validAttr(attr) {
-			return &SyntaxError{nil, 0, fmt.Sprintf("Invalid attribute %s", attr)}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		if inHex, b64 := opts.Has("hex"), opts.Has("base64"); inHex || b64 {
+			return e.writeBytes(attr, v.Bytes(), inHex)
 		}
-		if !validVal(val) {
-			return &SyntaxError{nil, 0, fmt.Sprintf("Invalid value %s", val)}
+	}
+
+	// Slices and arrays produce a tuple for each element, unless
+	// they are written as a list
+	n, multi := 1, false
+	if _, ok := valueMarshaler(v); !ok && (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) {
+		n, multi = v.Len(), true
+	}
+	sep, list := opts.listSep()
+	list = list && multi
+	layout, hasLayout := opts.Get("format")
+	base := 10
+	if opts.Has("hex") {
+		base = 16
+	} else if opts.Has("octal") {
+		base = 8
+	}
+	val := e.valbuf[:0]
+	for i := 0; i < n; i++ {
+		item := v
+		if multi {
+			item = v.Index(i)
 		}
-		if bytes.IndexByte(val, '\'') != -1 {
-			val = bytes.Replace(val, []byte{'\''}, []byte{'\'', '\''}, -1)
+		if !list {
+			val = val[:0]
+		} else if i > 0 {
+			val = append(val, sep...)
 		}
-		if _, err := e.out.Write(attr); err != nil {
+		var err error
+		if val, err = appendVal(val, item, layout, hasLayout, base); err != nil {
 			return err
 		}
-		if _, err := e.out.Write([]byte{'='}); err != nil {
-			return err
+		e.valbuf = val
+		if list && i < n-1 {
+			continue
 		}
-		x := bytes.IndexFunc(val, func(r rune) bool {
-			return unicode.IsSpace(r)
-		})
-		if x != -1 {
-			if _, err := e.out.Write([]byte{'\''}); err != nil {
-				return err
-			}
+		if !validVal(val) {
+			return &SyntaxError{Message: fmt.Sprintf("Invalid value %s", val)}
 		}
-		if _, err := e.out.Write(val); err != nil {
+		// writeValue does not retain its argument, so it may
+		// share the value buffer's memory
+		var str string
+		if len(val) > 0 {
+			str = unsafe.String(&val[0], len(val))
+		}
+		if err := e.writeValue(attr, str); err != nil {
 			return err
 		}
-		if x != -1 {
-			if _, err := e.out.Write([]byte{'\''}); err != nil {
-				return err
+	}
+	return nil
+}
+
+// appendVal appends the text of the tuple value v to dst. Integers
+// are written in the given base.
+func appendVal(dst []byte, v reflect.Value, layout string, hasLayout bool, base int) ([]byte, error) {
+	if hasLayout && v.Type() == timeType {
+		return v.Interface().(time.Time).AppendFormat(dst, layout), nil
+	}
+	if b, ok := appendNetip(dst, v); ok {
+		return b, nil
+	}
+	if m, ok := valueMarshaler(v); ok {
+		b, err := m.MarshalNDB()
+		return append(dst, b...), err
+	}
+	if base != 10 {
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if i := v.Int(); i < 0 {
+				return appendUintBase(append(dst, '-'), uint64(-i), base), nil
 			}
+			return appendUintBase(dst, uint64(v.Int()), base), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return appendUintBase(dst, v.Uint(), base), nil
 		}
-		valBuf.Reset()
 	}
+	if v.Type().NumMethod() == 0 {
+		// No String method for fmt to use
+		switch v.Kind() {
+		case reflect.String:
+			return append(dst, v.String()...), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.AppendInt(dst, v.Int(), 10), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return strconv.AppendUint(dst, v.Uint(), 10), nil
+		case reflect.Bool:
+			return strconv.AppendBool(dst, v.Bool()), nil
+		}
+	}
+	return fmt.Append(dst, v.Interface()), nil
+}
+
+// writeBytes writes the tuple attr=b, with b encoded in hexadecimal
+// if inHex is true, and in standard base64 otherwise.
+func (e *Encoder) writeBytes(attr string, b []byte, inHex bool) error {
+	if inHex {
+		e.valbuf = hex.AppendEncode(e.valbuf[:0], b)
+	} else {
+		e.valbuf = base64.StdEncoding.AppendEncode(e.valbuf[:0], b)
+	}
+	return e.writeValue(attr, string(e.valbuf))
+}
+
+// writeValue writes the tuple attr=val, quoted as the Encoder's
+// profile requires.
+func (e *Encoder) writeValue(attr, val string) error {
+	if e.profile != Plan9Strict {
+		e.tuple = appendTuple(e.tuple[:0], attr, val)
+	} else if t, ok := appendPlan9Tuple(e.tuple[:0], attr, val); ok {
+		e.tuple = t
+	} else {
+		return &SyntaxError{Message: fmt.Sprintf("Invalid value %s for Plan 9", val)}
+	}
+	e.writeTok(e.tuple)
 	return nil
 }
 
-func validAttr(attr []byte) bool {
-	if !utf8.Valid(attr) {
-		return false
+// appendUintBase appends u to dst in base 16 or 8, with the prefix
+// that the Decoder recognizes.
+func appendUintBase(dst []byte, u uint64, base int) []byte {
+	if base == 16 {
+		dst = append(dst, "0x"...)
+	} else {
+		dst = append(dst, "0o"...)
 	}
-	x := bytes.IndexFunc(attr, func(r rune) bool {
-		switch {
-		case r == '\'':
-			return true
-		case unicode.IsSpace(r):
-			return true
+	return strconv.AppendUint(dst, u, base)
+}
+
+// writeFlag writes the bare attribute attr if set is true, and
+// nothing otherwise.
+func (e *Encoder) writeFlag(attr string, set bool) error {
+	if set {
+		e.tuple = append(e.tuple[:0], attr...)
+		e.writeTok(e.tuple)
+	}
+	return nil
+}
+
+// writeTok adds a single tuple to the output, preceded by a space
+// or, if the line would exceed the Encoder's maximum width, a new
+// line and a tab. Each rune is counted as one column.
+func (e *Encoder) writeTok(tok []byte) {
+	var sep string
+	n := utf8.RuneCount(tok)
+	if e.start {
+		sep = " "
+		if e.width > 0 && e.col+1+n > e.width {
+			sep = e.eol + "\t"
+			e.col = tabWidth
+		} else {
+			e.col++
 		}
-		return !unicode.IsLetter(r) &&
-			!unicode.IsNumber(r) &&
-			r != '-'
-	})
-	return x == -1
+	} else {
+		e.start = true
+	}
+	e.col += n
+	e.buf = append(e.buf, sep...)
+	e.buf = append(e.buf, tok...)
 }
 
-func validVal(val []byte) bool {
-	if !utf8.Valid(val) {
-		return false
+// interfaceFor returns v, or a pointer to v, as an interface
+// value if either implements the interface type it. The types are
+// checked first, to avoid allocating for values that do not.
+func interfaceFor(v reflect.Value, it reflect.Type) (interface{}, bool) {
+	if !v.IsValid() || !v.CanInterface() || v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, false
+	}
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	if v.Type().Implements(it) {
+		return v.Interface(), true
+	}
+	if v.CanAddr() && reflect.PointerTo(v.Type()).Implements(it) {
+		return v.Addr().Interface(), true
+	}
+	return nil, false
+}
+
+func isMarshaler(v reflect.Value) bool {
+	_, ok := marshalerFor(v)
+	return ok
+}
+
+// marshalerFor returns v, or a pointer to v, as a Marshaler, if
+// either implements the interface.
+func marshalerFor(v reflect.Value) (Marshaler, bool) {
+	x, ok := interfaceFor(v, marshalerType)
+	if !ok {
+		return nil, false
+	}
+	return x.(Marshaler), true
+}
+
+type textMarshaler struct {
+	encoding.TextMarshaler
+}
+
+func (m textMarshaler) MarshalNDB() ([]byte, error) {
+	return m.MarshalText()
+}
+
+// valueMarshaler is like marshalerFor, but falls back to
+// encoding.TextMarshaler for tuple values.
+func valueMarshaler(v reflect.Value) (Marshaler, bool) {
+	if m, ok := marshalerFor(v); ok {
+		return m, true
+	}
+	x, ok := interfaceFor(v, textMarshalerType)
+	if !ok {
+		return nil, false
 	}
-	return bytes.IndexByte(val, '\n') == -1
+	return textMarshaler{x.(encoding.TextMarshaler)}, true
 }
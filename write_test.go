@@ -1,7 +1,13 @@
+//go:build !ndbnoreflect
+
 package ndb
 
 import (
+	"bytes"
+	"net"
+	"reflect"
 	"testing"
+	"time"
 )
 
 var structWriteTests = []struct {
@@ -24,7 +30,11 @@ var mapWriteTests = []struct {
 }{
 	{
 		map[string] string {"user": "jenkins", "group": "jenkins"},
-		"user=jenkins group=jenkins",
+		"group=jenkins user=jenkins",
+	},
+	{
+		map[string] string {"sys": "p2", "ip": "10.0.0.1", "dom": "p2.example.com", "ether": "0011aabbccdd"},
+		"dom=p2.example.com ether=0011aabbccdd ip=10.0.0.1 sys=p2",
 	},
 }
 
@@ -51,3 +61,479 @@ func TestMapWrite(t *testing.T) {
 		}
 	}
 }
+
+func TestEncoderReset(t *testing.T) {
+	var b1, b2 bytes.Buffer
+	e := NewEncoder(&b1)
+	if err := e.Encode(structWriteTests[0].in); err != nil {
+		t.Fatal(err)
+	}
+	e.Reset(&b2)
+	if err := e.Encode(structWriteTests[1].in); err != nil {
+		t.Fatal(err)
+	}
+	if b1.String() != structWriteTests[0].out {
+		t.Errorf("Wanted %s, got %s", structWriteTests[0].out, b1.String())
+	}
+	if b2.String() != structWriteTests[1].out {
+		t.Errorf("Wanted %s, got %s", structWriteTests[1].out, b2.String())
+	}
+}
+
+func TestMarshalWithTagName(t *testing.T) {
+	v := struct {
+		Host string `cfg:"sys"`
+		Port int    `ndb:"sys" cfg:"port"`
+	}{"fir", 80}
+	b, err := MarshalWith(v, TagName("cfg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "sys=fir port=80" {
+		t.Errorf("Wanted sys=fir port=80, got %s", b)
+	}
+}
+
+func TestMarshaler(t *testing.T) {
+	p := switchPort{"ge-0/0/1", []vlanID{10, 20}, 1}
+	b, err := Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "port=ge-0/0/1 vlan=v10 vlan=v20 native-vlan=v1"; string(b) != want {
+		t.Errorf("Wanted %s, got %s", want, b)
+	}
+}
+
+func TestTextMarshaler(t *testing.T) {
+	h := hostAddr{"fir", net.IPv4(135, 104, 9, 1)}
+	b, err := Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sys=fir ip=135.104.9.1"; string(b) != want {
+		t.Errorf("Wanted %s, got %s", want, b)
+	}
+}
+
+func TestTimeWrite(t *testing.T) {
+	l := lease{
+		IP:      "10.0.0.1",
+		Expires: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Renewed: time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC),
+	}
+	b, err := Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ip=10.0.0.1 expires=2024-03-01 renewed=2024-02-01T10:00:00Z"; string(b) != want {
+		t.Errorf("Wanted %s, got %s", want, b)
+	}
+}
+
+func TestDurationWrite(t *testing.T) {
+	v := struct {
+		Timeout time.Duration `ndb:"timeout"`
+	}{30 * time.Second}
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "timeout=30s" {
+		t.Errorf("Wanted timeout=30s, got %s", b)
+	}
+}
+
+func TestSkipFieldWrite(t *testing.T) {
+	v := struct {
+		Sys   string         `ndb:"sys"`
+		Cache map[string]int `ndb:"-"`
+	}{"fir", map[string]int{"a": 1}}
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "sys=fir" {
+		t.Errorf("Wanted sys=fir, got %s", b)
+	}
+}
+
+func TestUnexportedFieldWrite(t *testing.T) {
+	v := struct {
+		Name    string
+		secret  string
+		timeout time.Duration
+	}{"a", "pw", time.Second}
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Name=a" {
+		t.Errorf("Wanted Name=a, got %s", b)
+	}
+}
+
+func TestAttrOrder(t *testing.T) {
+	v := struct {
+		Sys   string `ndb:"sys"`
+		IP    string `ndb:"ip"`
+		Ether string `ndb:"ether"`
+	}{"fir", "10.0.0.2", "0011aabbccdd"}
+	first := func(attr string) func(a, b string) bool {
+		return func(a, b string) bool { return a == attr && b != attr }
+	}
+	tests := []struct {
+		opts []Option
+		out  string
+	}{
+		{nil, "sys=fir ip=10.0.0.2 ether=0011aabbccdd"},
+		{[]Option{AttrOrder(Alphabetical)}, "ether=0011aabbccdd ip=10.0.0.2 sys=fir"},
+		{[]Option{AttrOrder(first("ether"))}, "ether=0011aabbccdd sys=fir ip=10.0.0.2"},
+	}
+	for _, tt := range tests {
+		b, err := MarshalWith(v, tt.opts...)
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != tt.out {
+			t.Errorf("Wanted %s, got %s", tt.out, b)
+		}
+	}
+	m := map[string]string{"sys": "fir", "ip": "10.0.0.2", "ether": "0011aabbccdd"}
+	b, err := MarshalWith(m, AttrOrder(first("sys")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sys=fir ether=0011aabbccdd ip=10.0.0.2"; string(b) != want {
+		t.Errorf("Wanted %s, got %s", want, b)
+	}
+}
+
+func TestFlagWrite(t *testing.T) {
+	type host struct {
+		Sys     string `ndb:"sys"`
+		Trusted bool   `ndb:"trusted,flag"`
+		Dhcp    bool   `ndb:"dhcp"`
+	}
+	tests := []struct {
+		in  host
+		out string
+	}{
+		{host{"fir", true, true}, "sys=fir trusted dhcp=true"},
+		{host{"fir", false, false}, "sys=fir dhcp=false"},
+	}
+	for _, tt := range tests {
+		b, err := Marshal(tt.in)
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != tt.out {
+			t.Errorf("Wanted %s, got %s", tt.out, b)
+		}
+		var v host
+		if err := Unmarshal(b, &v); err != nil {
+			t.Error(err)
+		} else if v != tt.in {
+			t.Errorf("Got %+v, wanted %+v", v, tt.in)
+		}
+	}
+}
+
+func TestMaxWidth(t *testing.T) {
+	v := struct {
+		Sys   string `ndb:"sys"`
+		Dom   string `ndb:"dom"`
+		IP    string `ndb:"ip"`
+		Ether string `ndb:"ether"`
+		Bootf string `ndb:"bootf"`
+	}{"fir", "fir.example.com", "135.104.9.1", "0011aabbccdd", "/386/9pxeload"}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetMaxWidth(48)
+	if err := e.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	want := "sys=fir dom=fir.example.com ip=135.104.9.1\n" +
+		"\tether=0011aabbccdd bootf=/386/9pxeload"
+	if buf.String() != want {
+		t.Errorf("Wanted %q, got %q", want, buf.String())
+	}
+	var w struct {
+		Sys   string `ndb:"sys"`
+		Bootf string `ndb:"bootf"`
+	}
+	if err := Unmarshal(buf.Bytes(), &w); err != nil {
+		t.Fatal(err)
+	}
+	if w.Sys != v.Sys || w.Bootf != v.Bootf {
+		t.Errorf("Got %+v after wrapping", w)
+	}
+
+	// Widths are counted in runes, not bytes
+	buf.Reset()
+	e = NewEncoder(&buf)
+	e.SetMaxWidth(20)
+	if err := e.Encode(map[string]string{"sys": "ŝŷŝ", "dom": "ďőm"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "dom=ďőm sys=ŝŷŝ"; buf.String() != want {
+		t.Errorf("Wanted %q, got %q", want, buf.String())
+	}
+}
+
+type iface struct {
+	Ether string `ndb:"ether"`
+	IP    string `ndb:"ip"`
+}
+
+type service struct {
+	Port  int    `ndb:"port"`
+	Proto string `ndb:"proto"`
+}
+
+type nestedHost struct {
+	Sys    string   `ndb:"sys"`
+	Dom    string   `ndb:"dom"`
+	Ifaces []iface  `ndb:"iface"`
+	Svc    service  `ndb:"svc"`
+	Notes  []string `ndb:"note"`
+}
+
+func TestNestedStruct(t *testing.T) {
+	h := nestedHost{
+		Sys: "fir",
+		Dom: "fir.example.com",
+		Ifaces: []iface{
+			{"0011aabbccdd", "10.0.0.2"},
+			{"0011aabbccde", "10.0.1.2"},
+		},
+		Svc:   service{22, "tcp"},
+		Notes: []string{"rack4", "ups"},
+	}
+	want := "sys=fir dom=fir.example.com note=rack4 note=ups\n" +
+		"\tether=0011aabbccdd ip=10.0.0.2\n" +
+		"\tether=0011aabbccde ip=10.0.1.2\n" +
+		"\tport=22 proto=tcp"
+	b, err := Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != want {
+		t.Errorf("Wanted %q, got %q", want, b)
+	}
+	var got nestedHost
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, h) {
+		t.Errorf("Got %+v, wanted %+v", got, h)
+	}
+}
+
+func TestNestedStructDecode(t *testing.T) {
+	// Continuation lines not starting a nested entry belong to
+	// the parent entry.
+	data := "sys=fir\n\tdom=fir.example.com\n\tether=0011aabbccdd ip=10.0.0.2\n\tnote=rack4 note=ups"
+	var got nestedHost
+	if err := Unmarshal([]byte(data), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := nestedHost{
+		Sys:    "fir",
+		Dom:    "fir.example.com",
+		Ifaces: []iface{{"0011aabbccdd", "10.0.0.2"}},
+		Notes:  []string{"rack4", "ups"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v, wanted %+v", got, want)
+	}
+}
+
+func TestEncodeAtomic(t *testing.T) {
+	type host struct {
+		Sys  string `ndb:"sys"`
+		Desc string `ndb:"desc"`
+	}
+	tests := []interface{}{
+		host{"fir", "two\nlines"},
+		host{"fir", "'quoted words"},
+		[]host{{"fir", "ok"}, {"oak", "two\nlines"}},
+		map[string]string{"a": "1", "b c": "2"},
+	}
+	for _, v := range tests {
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(v); err == nil {
+			t.Errorf("%v: Got nil, wanted error", v)
+		}
+		if buf.Len() > 0 {
+			t.Errorf("%v: Got partial output %q", v, buf.String())
+		}
+	}
+}
+
+func TestLineTerminator(t *testing.T) {
+	hosts := []iface{{"0011aabbccdd", "10.0.0.2"}, {"0011aabbccde", "10.0.0.3"}}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	if err := e.Encode(hosts); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Encode(iface{"0011aabbccdf", "10.0.0.4"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "ether=0011aabbccdd ip=10.0.0.2\n" +
+		"ether=0011aabbccde ip=10.0.0.3\n" +
+		"ether=0011aabbccdf ip=10.0.0.4"
+	if buf.String() != want {
+		t.Errorf("Wanted %q, got %q", want, buf.String())
+	}
+
+	buf.Reset()
+	e = NewEncoder(&buf)
+	for _, eol := range []string{"", "\r", "\n\n", " \n", "=", "'", "\u2028"} {
+		if err := e.SetLineTerminator(eol); err == nil {
+			t.Errorf("SetLineTerminator(%q) did not fail", eol)
+		}
+	}
+	if err := e.SetLineTerminator("\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	h := nestedHost{Sys: "fir", Ifaces: hosts}
+	if err := e.Encode([]nestedHost{h, h}); err != nil {
+		t.Fatal(err)
+	}
+	want = "sys=fir dom=\r\n\tether=0011aabbccdd ip=10.0.0.2\r\n\tether=0011aabbccde ip=10.0.0.3\r\n\tport=0 proto="
+	want = want + "\r\n" + want
+	if buf.String() != want {
+		t.Errorf("Wanted %q, got %q", want, buf.String())
+	}
+	var got []nestedHost
+	d := NewDecoder(&buf)
+	for d.More() {
+		var v nestedHost
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	if len(got) != 2 || !reflect.DeepEqual(got[1].Ifaces, hosts) {
+		t.Errorf("Got %+v after decoding", got)
+	}
+}
+
+// raceEnabled is set when the race detector, which makes sync.Pool
+// drop items, is enabled.
+var raceEnabled bool
+
+func TestMarshalAppend(t *testing.T) {
+	buf := []byte("# hosts\n")
+	buf, err := MarshalAppend(buf, iface{"0011aabbccdd", "10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# hosts\nether=0011aabbccdd ip=10.0.0.2"; string(buf) != want {
+		t.Errorf("Wanted %q, got %q", want, buf)
+	}
+	out, err := MarshalAppend(buf, map[string]string{"a b": "c"})
+	if err == nil {
+		t.Error("Got nil, wanted error")
+	}
+	if string(out) != string(buf) {
+		t.Errorf("Got %q, wanted buffer unchanged", out)
+	}
+
+	buf = make([]byte, 0, 256)
+	v := iface{"0011aabbccdd", "10.0.0.2"}
+	n := testing.AllocsPerRun(100, func() {
+		buf, _ = MarshalAppend(buf[:0], &v)
+	})
+	if n != 0 && !raceEnabled {
+		t.Errorf("MarshalAppend made %v allocations, wanted none", n)
+	}
+}
+
+func TestIntBase(t *testing.T) {
+	type flags struct {
+		Mask   uint32 `ndb:"mask,hex"`
+		Mode   int    `ndb:"mode,octal"`
+		Offset int    `ndb:"offset,hex"`
+		Port   int    `ndb:"port"`
+	}
+	v := flags{Mask: 0xff000000, Mode: 0755, Offset: -31, Port: 80}
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "mask=0xff000000 mode=0o755 offset=-0x1f port=80"; string(b) != want {
+		t.Errorf("Got %s, wanted %s", b, want)
+	}
+	var got flags
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != v {
+		t.Errorf("Got %+v, wanted %+v", got, v)
+	}
+	if err := Unmarshal([]byte("mask=0b101 mode=017 port=0080"), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Mask != 5 || got.Mode != 17 || got.Port != 80 {
+		t.Errorf("Got %+v, wanted binary mask and decimal mode and port", got)
+	}
+}
+
+func TestBytesEncoding(t *testing.T) {
+	type key struct {
+		ID  []byte `ndb:"id,hex"`
+		Key []byte `ndb:"key,base64"`
+	}
+	v := key{ID: []byte{0xde, 0xad, 0xbe, 0xef}, Key: []byte("secret key\n")}
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id=deadbeef key=c2VjcmV0IGtleQo="; string(b) != want {
+		t.Errorf("Got %s, wanted %s", b, want)
+	}
+	var got key
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("Got %+v, wanted %+v", got, v)
+	}
+	if err := Unmarshal([]byte("id=xyz"), &got); err == nil {
+		t.Error("Got nil, wanted error for invalid hex")
+	}
+}
+
+func TestListOption(t *testing.T) {
+	type svc struct {
+		Ports []int    `ndb:"ports,comma"`
+		Names []string `ndb:"names,sep=;"`
+		DNS   []string `ndb:"dns"`
+	}
+	v := svc{Ports: []int{80, 443, 8080}, Names: []string{"www", "web"}, DNS: []string{"a", "b"}}
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ports=80,443,8080 names=www;web dns=a dns=b"; string(b) != want {
+		t.Errorf("Got %s, wanted %s", b, want)
+	}
+	var got svc
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("Got %+v, wanted %+v", got, v)
+	}
+	got = svc{}
+	if err := Unmarshal([]byte("ports=22 ports=80,443 names="), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Ports, []int{22, 80, 443}) || got.Names != nil {
+		t.Errorf("Got %+v, wanted ports 22, 80 and 443", got)
+	}
+	if err := Unmarshal([]byte("ports=80,http"), &got); err == nil {
+		t.Error("Got nil, wanted error for invalid element")
+	}
+}